@@ -4,12 +4,17 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"server/internal/attachment"
 	"server/internal/auth"
 	"server/internal/config"
+	"server/internal/history"
+	"server/internal/job"
+	"server/internal/registry"
 	"server/internal/resource"
 	"server/internal/role"
 	"server/internal/router"
 	"server/internal/user"
+	"strconv"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -30,6 +35,20 @@ func main() {
 	// Initialize database
 	config.InitDB()
 
+	// Initialize repositories
+	authRepo := &auth.Repository{}
+	userRepo := &user.Repository{}
+	roleRepo := &role.Repository{}
+	registryRepo := &registry.Repository{}
+	historyRepo := &history.Repository{}
+
+	// Resource registry: create/alter business tables from metadata
+	registryService := &registry.Service{Repo: registryRepo}
+	if err := registryService.SyncAll(); err != nil {
+		log.Fatal("Failed to sync resource tables: ", err)
+	}
+	resourceRepo := &resource.Repository{Registry: registryService, History: historyRepo}
+
 	// Initialize Gin
 	r := gin.Default()
 
@@ -37,30 +56,47 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Write-Mode", "If-Match", "If-None-Match"},
+		ExposeHeaders:    []string{"X-Next-Cursor", "X-Total-Count", "ETag", "Location"},
 		AllowCredentials: true,
 	}))
 
-	// Initialize repositories
-	authRepo := &auth.Repository{}
-	userRepo := &user.Repository{}
-	roleRepo := &role.Repository{}
-	resourceRepo := &resource.Repository{}
-
 	// Initialize services
 	authService := &auth.Service{Repo: authRepo}
 	userService := &user.Service{Repo: userRepo}
 	roleService := &role.Service{Repo: roleRepo}
-	resourceService := &resource.Service{Repo: resourceRepo}
+	resourceService := &resource.Service{
+		Repo:          resourceRepo,
+		LenientWrites: os.Getenv("WRITE_MODE") == "lenient", // strict unless configured otherwise
+	}
 
 	// Initialize handlers
 	authHandler := &auth.Handler{Service: authService}
 	userHandler := &user.Handler{Service: userService}
 	roleHandler := &role.Handler{Service: roleService}
-	resourceHandler := &resource.Handler{Service: resourceService}
+	jobService := &job.Service{}
+	resourceHandler := &resource.Handler{Service: resourceService, Jobs: jobService}
+	registryHandler := &registry.Handler{Service: registryService}
+	jobHandler := &job.Handler{Service: jobService}
+
+	// Attachments are stored on local disk unless another Storage is plugged in
+	attachmentDir := os.Getenv("ATTACHMENT_DIR")
+	if attachmentDir == "" {
+		attachmentDir = "uploads/attachments"
+	}
+	attachmentMaxSize, _ := strconv.ParseInt(os.Getenv("ATTACHMENT_MAX_SIZE"), 10, 64) // bytes; default 10 MB
+	attachmentStorage := &attachment.LocalStorage{Dir: attachmentDir}
+	resourceRepo.Files = attachmentStorage // purged records take their files along
+	attachmentService := &attachment.Service{
+		Repo:    &attachment.Repository{},
+		Storage: attachmentStorage,
+		Records: resourceService,
+		MaxSize: attachmentMaxSize,
+	}
+	attachmentHandler := &attachment.Handler{Service: attachmentService}
 
 	// Setup routes
-	router.SetupRoutes(r, authHandler, userHandler, roleHandler, resourceHandler)
+	router.SetupRoutes(r, authHandler, userHandler, roleHandler, resourceHandler, registryHandler, jobHandler, attachmentHandler, registryService)
 
 	// Start server
 	port := os.Getenv("PORT")
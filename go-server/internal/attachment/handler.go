@@ -0,0 +1,129 @@
+package attachment
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"server/internal/permission"
+	"server/internal/resource"
+	"server/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead allows for the multipart headers around a file of the maximum size
+const multipartOverhead = 1 << 20
+
+type Handler struct {
+	Service *Service
+}
+
+func (h *Handler) List(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	attachments, err := h.Service.List(c.Param("resource"), c.Param("id"), claims)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+// Upload attaches the multipart "file" field to a record
+func (h *Handler) Upload(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+	res, id := c.Param("resource"), c.Param("id")
+
+	// Checked before the body is read, so unauthorized uploads are not buffered
+	if _, err := h.Service.authorize(res, id, claims, permission.ActionUploadAttachments); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.Service.maxSize()+multipartOverhead)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, ErrTooLarge)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"message": "file is required"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer f.Close()
+
+	a, err := h.Service.Upload(res, id, claims, fh.Filename, f)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, a)
+}
+
+// Link returns a short-lived signed download URL
+func (h *Handler) Link(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	link, err := h.Service.Link(c.Param("resource"), c.Param("id"), c.Param("attachment_id"), claims)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, link)
+}
+
+// Download serves an attachment from a signed link, as a download and never rendered inline
+func (h *Handler) Download(c *gin.Context) {
+	a, f, err := h.Service.Open(c.Param("token"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer f.Close()
+
+	c.DataFromReader(http.StatusOK, a.Size, a.ContentType, f, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": a.FileName}),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, no-store",
+	})
+}
+
+func (h *Handler) Delete(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	err := h.Service.Delete(c.Param("resource"), c.Param("id"), c.Param("attachment_id"), claims)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
+
+func respondError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrPermissionDenied):
+		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+	case errors.Is(err, ErrNotFound), errors.Is(err, resource.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	case errors.Is(err, ErrLinkExpired):
+		c.JSON(http.StatusGone, gin.H{"message": err.Error()})
+	case errors.Is(err, ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": err.Error()})
+	case errors.Is(err, ErrEmpty):
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	default:
+		log.Printf("Attachment request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+	}
+}
@@ -0,0 +1,81 @@
+package attachment
+
+import (
+	"database/sql"
+	"server/internal/config"
+)
+
+type Repository struct{}
+
+const columns = `a.id, a.record_id, a.file_name, a.content_type, a.size, COALESCE(a.uploaded_by, 0),
+	COALESCE(a.uploader, ''), a.created_at, a.storage_key`
+
+func scan(scan func(dest ...interface{}) error) (*Attachment, error) {
+	var a Attachment
+	err := scan(&a.ID, &a.RecordID, &a.FileName, &a.ContentType, &a.Size, &a.UploadedBy, &a.Uploader, &a.CreatedAt, &a.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// List returns the attachments of a record, oldest first
+func (r *Repository) List(resource string, recordID int) ([]Attachment, error) {
+	rows, err := config.DB.Query(`
+		SELECT `+columns+`
+		FROM attachments a
+		JOIN resources res ON a.resource_id = res.id
+		WHERE res.name = $1 AND a.record_id = $2
+		ORDER BY a.id
+	`, resource, recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		a, err := scan(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *a)
+	}
+	return attachments, rows.Err()
+}
+
+// Get returns an attachment of a record, or nil if the record has no such attachment
+func (r *Repository) Get(resource string, recordID, id int) (*Attachment, error) {
+	a, err := scan(config.DB.QueryRow(`
+		SELECT `+columns+`
+		FROM attachments a
+		JOIN resources res ON a.resource_id = res.id
+		WHERE res.name = $1 AND a.record_id = $2 AND a.id = $3
+	`, resource, recordID, id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// GetByID returns an attachment by id alone, for signed download links
+func (r *Repository) GetByID(id int) (*Attachment, error) {
+	a, err := scan(config.DB.QueryRow(`SELECT `+columns+` FROM attachments a WHERE a.id = $1`, id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+func (r *Repository) Create(resource string, a *Attachment) error {
+	return config.DB.QueryRow(`
+		INSERT INTO attachments (resource_id, record_id, file_name, content_type, size, storage_key, uploaded_by, uploader)
+		SELECT id, $2, $3, $4, $5, $6, $7, $8 FROM resources WHERE name = $1
+		RETURNING id, created_at
+	`, resource, a.RecordID, a.FileName, a.ContentType, a.Size, a.StorageKey, a.UploadedBy, a.Uploader).Scan(&a.ID, &a.CreatedAt)
+}
+
+func (r *Repository) Delete(id int) error {
+	_, err := config.DB.Exec(`DELETE FROM attachments WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,220 @@
+package attachment
+
+// Files attached to resource records, e.g. contracts on projects and invoices on orders. Each
+// operation needs its own table-level action (list_attachments, download_attachments,
+// upload_attachments, delete_attachments) and the record must be within the caller's read
+// scope. Downloads go through short-lived signed links so they work from a plain browser link.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"server/internal/permission"
+	"server/internal/resource"
+	"server/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const (
+	DefaultMaxSize = 10 << 20
+	LinkTTL        = 5 * time.Minute
+
+	sniffLen    = 3072 // bytes read to detect the content type
+	maxNameLen  = 255
+	defaultName = "file"
+)
+
+type Service struct {
+	Repo    *Repository
+	Storage Storage
+	Records *resource.Service
+	MaxSize int64 // largest accepted file in bytes; 0 means DefaultMaxSize
+}
+
+func (s *Service) maxSize() int64 {
+	if s.MaxSize > 0 {
+		return s.MaxSize
+	}
+	return DefaultMaxSize
+}
+
+// authorize checks an attachment action and that the record is visible to the caller
+func (s *Service) authorize(res, id string, user *utils.Claims, action string) (int, error) {
+	allowed, _ := permission.HasPermission(user.RoleID, res, action)
+	if !allowed {
+		return 0, ErrPermissionDenied
+	}
+	recordID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, resource.ErrNotFound
+	}
+	if err := s.Records.CheckRecord(res, id, user); err != nil {
+		return 0, err
+	}
+	return recordID, nil
+}
+
+// find returns an attachment of a record
+func (s *Service) find(res string, recordID int, attachmentID string) (*Attachment, error) {
+	id, err := strconv.Atoi(attachmentID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	a, err := s.Repo.Get(res, recordID, id)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, ErrNotFound
+	}
+	return a, nil
+}
+
+func (s *Service) List(res, id string, user *utils.Claims) ([]Attachment, error) {
+	recordID, err := s.authorize(res, id, user, permission.ActionListAttachments)
+	if err != nil {
+		return nil, err
+	}
+	return s.Repo.List(res, recordID)
+}
+
+// Upload stores a file and attaches it to a record. The content type is sniffed from the data;
+// files larger than the size limit are rejected.
+func (s *Service) Upload(res, id string, user *utils.Claims, name string, r io.Reader) (*Attachment, error) {
+	recordID, err := s.authorize(res, id, user, permission.ActionUploadAttachments)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrEmpty
+	}
+	header = header[:n]
+
+	a := &Attachment{
+		RecordID:    recordID,
+		FileName:    cleanName(name),
+		ContentType: mimetype.Detect(header).String(),
+		UploadedBy:  user.ID,
+		Uploader:    user.Username,
+		StorageKey:  utils.GenerateRandomToken(16),
+	}
+	max := s.maxSize()
+	a.Size, err = s.Storage.Save(a.StorageKey, io.LimitReader(io.MultiReader(bytes.NewReader(header), r), max+1))
+	if err != nil {
+		return nil, err
+	}
+	if a.Size > max {
+		s.Storage.Delete(a.StorageKey)
+		return nil, ErrTooLarge
+	}
+	if err := s.Repo.Create(res, a); err != nil {
+		s.Storage.Delete(a.StorageKey)
+		return nil, err
+	}
+	return a, nil
+}
+
+// Link returns a signed download URL for an attachment, valid for LinkTTL
+func (s *Service) Link(res, id, attachmentID string, user *utils.Claims) (*DownloadLink, error) {
+	recordID, err := s.authorize(res, id, user, permission.ActionDownloadAttachments)
+	if err != nil {
+		return nil, err
+	}
+	a, err := s.find(res, recordID, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(LinkTTL)
+	payload, err := json.Marshal(link{Kind: linkKind, Attachment: a.ID, Expires: expires.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return &DownloadLink{URL: "/api/attachments/" + utils.SignPayload(payload), ExpiresAt: expires.UTC()}, nil
+}
+
+// Open resolves a signed download link. The link is the only credential: permissions were
+// checked when it was issued.
+func (s *Service) Open(token string) (*Attachment, io.ReadCloser, error) {
+	payload, err := utils.VerifyPayload(token)
+	var l link
+	if err == nil {
+		err = json.Unmarshal(payload, &l)
+	}
+	if err != nil || l.Kind != linkKind {
+		return nil, nil, ErrNotFound
+	}
+	if time.Now().Unix() > l.Expires {
+		return nil, nil, ErrLinkExpired
+	}
+
+	a, err := s.Repo.GetByID(l.Attachment)
+	if err != nil {
+		return nil, nil, err
+	}
+	if a == nil {
+		return nil, nil, ErrNotFound
+	}
+	f, err := s.Storage.Open(a.StorageKey)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, f, nil
+}
+
+func (s *Service) Delete(res, id, attachmentID string, user *utils.Claims) error {
+	recordID, err := s.authorize(res, id, user, permission.ActionDeleteAttachments)
+	if err != nil {
+		return err
+	}
+	a, err := s.find(res, recordID, attachmentID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Repo.Delete(a.ID); err != nil {
+		return err
+	}
+	// The attachment is gone once its row is; a file left behind is only wasted space
+	if err := s.Storage.Delete(a.StorageKey); err != nil {
+		log.Printf("Failed to delete attachment file %s: %v", a.StorageKey, err)
+	}
+	return nil
+}
+
+// cleanName keeps the base name of an uploaded file, without control characters
+func cleanName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return defaultName
+	}
+	if runes := []rune(name); len(runes) > maxNameLen {
+		name = string(runes[:maxNameLen])
+	}
+	return name
+}
@@ -0,0 +1,32 @@
+package attachment
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\ada\notes.txt`, "notes.txt"},
+		{"dir/", "dir"},
+		{"  spaced .txt  ", "spaced .txt"},
+		{"bad\r\nname\x00.txt", "badname.txt"},
+		{"", defaultName},
+		{"..", defaultName},
+		{"/", defaultName},
+		{"\x07", defaultName},
+		{"Zoë Müller.pdf", "Zoë Müller.pdf"},
+	}
+	for _, tt := range tests {
+		if got := cleanName(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	long := strings.Repeat("é", maxNameLen+10)
+	if got := cleanName(long); len([]rune(got)) != maxNameLen {
+		t.Errorf("long name kept %d characters", len([]rune(got)))
+	}
+}
@@ -0,0 +1,51 @@
+package attachment
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStoragePath(t *testing.T) {
+	s := &LocalStorage{Dir: "/var/attachments"}
+	if path, err := s.path("a1b2c3"); err != nil || path != filepath.Join("/var/attachments", "a1b2c3") {
+		t.Fatalf("got %q, %v", path, err)
+	}
+	for _, key := range []string{"", ".", "..", "../etc/passwd", "a/b", "/abs", ".hidden", "a/../../b"} {
+		if path, err := s.path(key); err == nil {
+			t.Errorf("%q: accepted as %q", key, path)
+		}
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	s := &LocalStorage{Dir: filepath.Join(t.TempDir(), "files")}
+	if n, err := s.Save("k1", bytes.NewReader([]byte("hello"))); err != nil || n != 5 {
+		t.Fatalf("save: %d, %v", n, err)
+	}
+	if _, err := s.Save("k1", bytes.NewReader(nil)); err == nil {
+		t.Fatal("existing object overwritten")
+	}
+
+	f, err := s.Open("k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(f)
+	f.Close()
+	if string(content) != "hello" {
+		t.Fatalf("got %q", content)
+	}
+
+	if err := s.Delete("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open("k1"); !os.IsNotExist(err) {
+		t.Fatalf("deleted object opened: %v", err)
+	}
+	if err := s.Delete("k1"); err != nil {
+		t.Fatalf("deleting a missing object: %v", err)
+	}
+}
@@ -90,21 +90,3 @@ func (h *Handler) GetMyFieldPermissions(c *gin.Context) {
 
 	c.JSON(http.StatusOK, perms)
 }
-
-func (h *Handler) GetUsers(c *gin.Context) {
-	users, err := h.Service.GetAllUsers()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, users)
-}
-
-func (h *Handler) GetEmployees(c *gin.Context) {
-	employees, err := h.Service.GetEmployees()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, employees)
-}
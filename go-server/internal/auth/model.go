@@ -34,9 +34,16 @@ type User struct {
 	CreatedAt       time.Time `json:"created_at,omitempty"`
 }
 
+// FieldPermission is what the caller may do with one field. CanSetOnCreate and CanUpdate already
+// account for computed fields (never written); Immutable fields cannot change after creation.
 type FieldPermission struct {
-	Resource string `json:"resource"`
-	Field    string `json:"field"`
-	CanView  bool   `json:"can_view"`
-	CanEdit  bool   `json:"can_edit"`
+	Resource       string `json:"resource"`
+	Field          string `json:"field"`
+	CanView        bool   `json:"can_view"`
+	CanEdit        bool   `json:"can_edit"`
+	CanSetOnCreate bool   `json:"can_set_on_create"`
+	CanUpdate      bool   `json:"can_update"`
+	Immutable      bool   `json:"immutable"`
+	MaskMode       string `json:"mask_mode"`
+	CanAggregate   bool   `json:"can_aggregate"`
 }
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"server/internal/config"
+	"server/internal/permission"
 )
 
 type Repository struct{}
@@ -45,60 +46,20 @@ func (r *Repository) DeclineInvitation(userID int) error {
 
 func (r *Repository) GetPermissionsByRoleID(roleID int) ([]Permission, error) {
 	// Query the new RBAC schema (role_resource_permissions table)
-	query := `
-		SELECT rrp.role_id, res.name, rrp.can_view, rrp.can_create, rrp.can_update, rrp.can_delete
-		FROM role_resource_permissions rrp
-		JOIN resources res ON rrp.resource_id = res.id
-		WHERE rrp.role_id = $1
-	`
-
-	rows, err := config.DB.Query(query, roleID)
+	grants, err := permission.GrantedActions(roleID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
+	// Convert to action-based format that frontend expects
 	perms := []Permission{}
-	for rows.Next() {
-		var roleID int
-		var resourceName string
-		var canView, canCreate, canUpdate, canDelete bool
-
-		if err := rows.Scan(&roleID, &resourceName, &canView, &canCreate, &canUpdate, &canDelete); err != nil {
-			continue
-		}
-
-		// Convert to action-based format that frontend expects
-		if canView {
-			perms = append(perms, Permission{
-				RoleID:   roleID,
-				Resource: resourceName,
-				Action:   "read",
-			})
-		}
-		if canCreate {
-			perms = append(perms, Permission{
-				RoleID:   roleID,
-				Resource: resourceName,
-				Action:   "create",
-			})
-		}
-		if canUpdate {
-			perms = append(perms, Permission{
-				RoleID:   roleID,
-				Resource: resourceName,
-				Action:   "update",
-			})
-		}
-		if canDelete {
-			perms = append(perms, Permission{
-				RoleID:   roleID,
-				Resource: resourceName,
-				Action:   "delete",
-			})
-		}
+	for _, g := range grants {
+		perms = append(perms, Permission{
+			RoleID:   roleID,
+			Resource: g.Resource,
+			Action:   g.Action,
+		})
 	}
-
 	return perms, nil
 }
 
@@ -110,40 +71,6 @@ type Permission struct {
 	Attributes string `json:"attributes"`
 }
 
-func (r *Repository) GetAllUsersSimple() ([]map[string]interface{}, error) {
-	rows, err := config.DB.Query("SELECT id, username FROM users WHERE status = 'Active'")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []map[string]interface{}
-	for rows.Next() {
-		var id int
-		var username string
-		rows.Scan(&id, &username)
-		users = append(users, map[string]interface{}{"id": id, "username": username})
-	}
-	return users, nil
-}
-
-func (r *Repository) GetEmployeeNames() ([]map[string]interface{}, error) {
-	rows, err := config.DB.Query("SELECT id, name FROM employees")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var employees []map[string]interface{}
-	for rows.Next() {
-		var id int
-		var name string
-		rows.Scan(&id, &name)
-		employees = append(employees, map[string]interface{}{"id": id, "name": name})
-	}
-	return employees, nil
-}
-
 // Helper to check role permission
 func (r *Repository) GetFieldPermissionsByRoleID(roleID int) ([]FieldPermission, error) {
 	var query string
@@ -152,13 +79,17 @@ func (r *Repository) GetFieldPermissionsByRoleID(roleID int) ([]FieldPermission,
 	if roleID == 1 {
 		// Admin gets everything
 		query = `
-			SELECT res.name, rf.field_name, true, true
+			SELECT res.name, rf.field_name, true, true, rf.expression IS NULL, rf.expression IS NULL,
+				COALESCE(rf.immutable, false), 'omit', true
 			FROM resource_fields rf
 			JOIN resources res ON rf.resource_id = res.id
 		`
 	} else {
 		query = `
-			SELECT res.name, rf.field_name, rfp.can_view, rfp.can_edit
+			SELECT res.name, rf.field_name, rfp.can_view, rfp.can_edit,
+				COALESCE(rfp.can_set_on_create, false) AND rf.expression IS NULL,
+				COALESCE(rfp.can_update, false) AND rf.expression IS NULL,
+				COALESCE(rf.immutable, false), COALESCE(rfp.mask_mode, 'omit'), COALESCE(rfp.can_aggregate, false)
 			FROM role_field_permissions rfp
 			JOIN resource_fields rf ON rfp.resource_field_id = rf.id
 			JOIN resources res ON rf.resource_id = res.id
@@ -176,7 +107,7 @@ func (r *Repository) GetFieldPermissionsByRoleID(roleID int) ([]FieldPermission,
 	perms := []FieldPermission{}
 	for rows.Next() {
 		var p FieldPermission
-		if err := rows.Scan(&p.Resource, &p.Field, &p.CanView, &p.CanEdit); err != nil {
+		if err := rows.Scan(&p.Resource, &p.Field, &p.CanView, &p.CanEdit, &p.CanSetOnCreate, &p.CanUpdate, &p.Immutable, &p.MaskMode, &p.CanAggregate); err != nil {
 			continue
 		}
 		perms = append(perms, p)
@@ -185,24 +116,5 @@ func (r *Repository) GetFieldPermissionsByRoleID(roleID int) ([]FieldPermission,
 }
 
 func (r *Repository) HasPermission(roleID int, resource, action string) (bool, error) {
-	query := `
-		SELECT CASE 
-			WHEN $3 = 'read' THEN rrp.can_view
-			WHEN $3 = 'create' THEN rrp.can_create
-			WHEN $3 = 'update' THEN rrp.can_update
-			WHEN $3 = 'delete' THEN rrp.can_delete
-			ELSE false
-		END as has_permission
-		FROM role_resource_permissions rrp
-		JOIN resources res ON rrp.resource_id = res.id
-		WHERE rrp.role_id = $1 AND res.name = $2
-	`
-
-	var hasPermission bool
-	err := config.DB.QueryRow(query, roleID, resource, action).Scan(&hasPermission)
-	if err != nil {
-		return false, nil // No permission found
-	}
-
-	return hasPermission, nil
+	return permission.HasPermission(roleID, resource, action)
 }
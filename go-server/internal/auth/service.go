@@ -70,11 +70,3 @@ func (s *Service) GetPermissions(roleID int) ([]Permission, error) {
 func (s *Service) GetFieldPermissions(roleID int) ([]FieldPermission, error) {
 	return s.Repo.GetFieldPermissionsByRoleID(roleID)
 }
-
-func (s *Service) GetAllUsers() ([]map[string]interface{}, error) {
-	return s.Repo.GetAllUsersSimple()
-}
-
-func (s *Service) GetEmployees() ([]map[string]interface{}, error) {
-	return s.Repo.GetEmployeeNames()
-}
@@ -55,6 +55,7 @@ func initializeSchema() {
 			invitation_token TEXT,
 			status TEXT DEFAULT 'Pending',
 			is_admin BOOLEAN DEFAULT FALSE,
+			attributes JSONB DEFAULT '{}',
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 
@@ -74,6 +75,9 @@ func initializeSchema() {
 			id SERIAL PRIMARY KEY,
 			name TEXT UNIQUE NOT NULL,
 			display_name TEXT,
+			is_system BOOLEAN DEFAULT FALSE,
+			soft_delete BOOLEAN DEFAULT FALSE,
+			require_if_match BOOLEAN DEFAULT FALSE,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 
@@ -88,6 +92,11 @@ func initializeSchema() {
 			field_name TEXT NOT NULL,
 			data_type TEXT DEFAULT 'text',
 			is_sensitive BOOLEAN DEFAULT FALSE,
+			validation JSONB DEFAULT '{}',
+			min_group_size INTEGER DEFAULT 0,
+			ref_resource TEXT,
+			expression TEXT,
+			immutable BOOLEAN DEFAULT FALSE,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			UNIQUE(resource_id, field_name)
 		)`,
@@ -101,6 +110,13 @@ func initializeSchema() {
 			can_create BOOLEAN DEFAULT FALSE,
 			can_update BOOLEAN DEFAULT FALSE,
 			can_delete BOOLEAN DEFAULT FALSE,
+			can_restore BOOLEAN DEFAULT FALSE,
+			can_purge BOOLEAN DEFAULT FALSE,
+			can_export BOOLEAN DEFAULT FALSE,
+			can_list_attachments BOOLEAN DEFAULT FALSE,
+			can_download_attachments BOOLEAN DEFAULT FALSE,
+			can_upload_attachments BOOLEAN DEFAULT FALSE,
+			can_delete_attachments BOOLEAN DEFAULT FALSE,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			UNIQUE(role_id, resource_id)
 		)`,
@@ -111,46 +127,99 @@ func initializeSchema() {
 			resource_field_id INTEGER REFERENCES resource_fields(id) ON DELETE CASCADE,
 			can_view BOOLEAN DEFAULT FALSE,
 			can_edit BOOLEAN DEFAULT FALSE,
+			can_set_on_create BOOLEAN DEFAULT FALSE,
+			can_update BOOLEAN DEFAULT FALSE,
+			mask_mode TEXT DEFAULT 'omit',
+			can_aggregate BOOLEAN DEFAULT FALSE,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			UNIQUE(role_id, resource_field_id)
 		)`,
 
-		// Legacy permissions table (kept for backward compatibility, will be deprecated)
-		`CREATE TABLE IF NOT EXISTS permissions (
+		// Row-level security: a predicate in the internal/expr language per role, resource and action
+		`CREATE TABLE IF NOT EXISTS role_row_filters (
 			id SERIAL PRIMARY KEY,
-			role_id INTEGER REFERENCES roles(id),
-			resource TEXT,
-			action TEXT,
-			attributes TEXT
+			role_id INTEGER REFERENCES roles(id) ON DELETE CASCADE,
+			resource_id INTEGER REFERENCES resources(id) ON DELETE CASCADE,
+			action TEXT NOT NULL,
+			expression TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(role_id, resource_id, action)
 		)`,
 
-		// Business Data Tables
-		`CREATE TABLE IF NOT EXISTS employees (
+		// State machines of text/enum fields; from_state '' marks a state new records may start in
+		`CREATE TABLE IF NOT EXISTS field_transitions (
 			id SERIAL PRIMARY KEY,
+			resource_field_id INTEGER REFERENCES resource_fields(id) ON DELETE CASCADE,
 			name TEXT NOT NULL,
-			position TEXT,
-			salary INTEGER,
-			department TEXT,
+			from_state TEXT NOT NULL DEFAULT '',
+			to_state TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(resource_field_id, from_state, to_state)
+		)`,
+
+		// Transitions granted per role (Admin may perform all of them)
+		`CREATE TABLE IF NOT EXISTS role_transition_permissions (
+			id SERIAL PRIMARY KEY,
+			role_id INTEGER REFERENCES roles(id) ON DELETE CASCADE,
+			transition_id INTEGER REFERENCES field_transitions(id) ON DELETE CASCADE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(role_id, transition_id)
+		)`,
+
+		// Changes to sensitive fields held for approval (resources.approver_role_id)
+		`CREATE TABLE IF NOT EXISTS pending_changes (
+			id SERIAL PRIMARY KEY,
+			resource_id INTEGER REFERENCES resources(id) ON DELETE CASCADE,
+			record_id INTEGER NOT NULL,
+			changes JSONB NOT NULL,
+			previous JSONB NOT NULL,
+			requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			requester TEXT,
+			status TEXT NOT NULL DEFAULT 'pending',
+			reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			reviewer TEXT,
+			reviewed_at TIMESTAMP,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 
-		`CREATE TABLE IF NOT EXISTS projects (
+		// Files attached to resource records; the content lives in the attachment storage
+		`CREATE TABLE IF NOT EXISTS attachments (
 			id SERIAL PRIMARY KEY,
-			name TEXT NOT NULL,
-			assigned_to TEXT,
-			status TEXT,
-			budget INTEGER,
+			resource_id INTEGER REFERENCES resources(id) ON DELETE CASCADE,
+			record_id INTEGER NOT NULL,
+			file_name TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			storage_key TEXT UNIQUE NOT NULL,
+			uploaded_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			uploader TEXT,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 
-		`CREATE TABLE IF NOT EXISTS orders (
+		// Change history of resource records (see internal/history)
+		`CREATE TABLE IF NOT EXISTS record_history (
 			id SERIAL PRIMARY KEY,
-			customer_name TEXT NOT NULL,
-			amount INTEGER,
-			status TEXT,
-			order_date TEXT,
+			resource_id INTEGER REFERENCES resources(id) ON DELETE CASCADE,
+			record_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			actor_id INTEGER,
+			actor TEXT,
+			changes JSONB DEFAULT '{}',
+			snapshot JSONB DEFAULT '{}',
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+
+		// Legacy permissions table (kept for backward compatibility, will be deprecated)
+		`CREATE TABLE IF NOT EXISTS permissions (
+			id SERIAL PRIMARY KEY,
+			role_id INTEGER REFERENCES roles(id),
+			resource TEXT,
+			action TEXT,
+			attributes TEXT
+		)`,
+
+		// Business data tables (employees, projects, orders, ...) are created from
+		// resources/resource_fields metadata by the registry at startup.
 	}
 
 	for _, query := range tables {
@@ -160,9 +229,161 @@ func initializeSchema() {
 		}
 	}
 
+	// Schema migrations for databases created by earlier versions
+	migrations := []string{
+		`ALTER TABLE resources ADD COLUMN IF NOT EXISTS is_system BOOLEAN DEFAULT FALSE`,
+		// roles and users are managed by their own endpoints, not the generic data API
+		`UPDATE resources SET is_system = TRUE WHERE name IN ('roles', 'users')`,
+		// Validation rules: existing business fields keep the Node backend's REQUIRED_FIELDS behaviour
+		`ALTER TABLE resource_fields ADD COLUMN IF NOT EXISTS validation JSONB`,
+		`UPDATE resource_fields SET validation = '{"required": true}'
+		 WHERE validation IS NULL AND resource_id IN (SELECT id FROM resources WHERE name IN ('employees', 'projects', 'orders'))`,
+		`UPDATE resource_fields SET validation = '{}' WHERE validation IS NULL`,
+		`ALTER TABLE resource_fields ALTER COLUMN validation SET DEFAULT '{}'`,
+		// User attributes referenced by row filters as :user.<name>
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS attributes JSONB DEFAULT '{}'`,
+		// How a non-viewable field is presented (see permission.MaskOmit and friends)
+		`ALTER TABLE role_field_permissions ADD COLUMN IF NOT EXISTS mask_mode TEXT DEFAULT 'omit'`,
+		// Soft delete (per resource) and the trash actions that come with it
+		`ALTER TABLE resources ADD COLUMN IF NOT EXISTS soft_delete BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE role_resource_permissions ADD COLUMN IF NOT EXISTS can_restore BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE role_resource_permissions ADD COLUMN IF NOT EXISTS can_purge BOOLEAN DEFAULT FALSE`,
+		`UPDATE role_resource_permissions SET can_restore = TRUE, can_purge = TRUE WHERE role_id = 1`,
+		`ALTER TABLE role_resource_permissions ADD COLUMN IF NOT EXISTS can_export BOOLEAN DEFAULT FALSE`,
+		`UPDATE role_resource_permissions SET can_export = TRUE WHERE role_id = 1`,
+		`ALTER TABLE resources ADD COLUMN IF NOT EXISTS require_if_match BOOLEAN DEFAULT FALSE`,
+		`CREATE INDEX IF NOT EXISTS record_history_record_idx ON record_history (resource_id, record_id, created_at)`,
+		// Aggregate-only field access; sensitive numbers only aggregate over groups of 5 or more
+		`ALTER TABLE role_field_permissions ADD COLUMN IF NOT EXISTS can_aggregate BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE resource_fields ADD COLUMN IF NOT EXISTS min_group_size INTEGER`,
+		`UPDATE resource_fields SET min_group_size = CASE WHEN is_sensitive AND data_type = 'number' THEN 5 ELSE 0 END
+		 WHERE min_group_size IS NULL`,
+		`ALTER TABLE resource_fields ALTER COLUMN min_group_size SET DEFAULT 0`,
+		// Reference fields (data_type 'reference'). projects.assigned_to held employee names; it now
+		// holds employee ids. Names matching no employee, or several, are left unassigned; the names
+		// are kept in assigned_to_name (registered as a read-only field below) to fix them by hand.
+		`ALTER TABLE resource_fields ADD COLUMN IF NOT EXISTS ref_resource TEXT`,
+		`DO $$
+		BEGIN
+			IF to_regclass('projects') IS NOT NULL AND to_regclass('employees') IS NOT NULL AND EXISTS (
+				SELECT 1 FROM resource_fields rf JOIN resources r ON rf.resource_id = r.id
+				WHERE r.name = 'projects' AND rf.field_name = 'assigned_to' AND rf.data_type = 'text'
+			) THEN
+				ALTER TABLE projects RENAME COLUMN assigned_to TO assigned_to_name;
+				ALTER TABLE projects ADD COLUMN assigned_to INTEGER REFERENCES employees (id);
+				UPDATE projects p SET assigned_to = (
+					SELECT MIN(e.id) FROM employees e WHERE e.name = p.assigned_to_name HAVING COUNT(*) = 1
+				);
+				UPDATE resource_fields SET data_type = 'reference', ref_resource = 'employees'
+				WHERE field_name = 'assigned_to' AND resource_id = (SELECT id FROM resources WHERE name = 'projects');
+			END IF;
+		END $$`,
+		// Typed fields: orders.order_date becomes a DATE and orders.amount a decimal. Dates that do
+		// not parse keep the column TEXT (and the field a text field) until they are fixed.
+		`DO $$
+		BEGIN
+			IF to_regclass('orders') IS NOT NULL AND EXISTS (
+				SELECT 1 FROM resource_fields rf JOIN resources r ON rf.resource_id = r.id
+				WHERE r.name = 'orders' AND rf.field_name = 'order_date' AND rf.data_type = 'text'
+			) THEN
+				ALTER TABLE orders ALTER COLUMN order_date TYPE DATE USING NULLIF(order_date, '')::date;
+				ALTER TABLE orders ALTER COLUMN amount TYPE NUMERIC(18, 4);
+				UPDATE resource_fields SET data_type = CASE field_name WHEN 'order_date' THEN 'date' ELSE 'decimal' END
+				WHERE field_name IN ('order_date', 'amount') AND resource_id = (SELECT id FROM resources WHERE name = 'orders');
+			END IF;
+		EXCEPTION WHEN invalid_datetime_format OR datetime_field_overflow THEN
+			RAISE NOTICE 'orders.order_date left as text: %', SQLERRM;
+		END $$`,
+		// Computed fields: evaluated from the expression at read time, no column
+		`ALTER TABLE resource_fields ADD COLUMN IF NOT EXISTS expression TEXT`,
+		// can_edit is split into can_set_on_create and can_update; existing grants keep both.
+		// Immutable fields can be set on create but never changed.
+		`ALTER TABLE role_field_permissions ADD COLUMN IF NOT EXISTS can_set_on_create BOOLEAN`,
+		`ALTER TABLE role_field_permissions ADD COLUMN IF NOT EXISTS can_update BOOLEAN`,
+		`UPDATE role_field_permissions SET can_set_on_create = COALESCE(can_edit, FALSE) WHERE can_set_on_create IS NULL`,
+		`UPDATE role_field_permissions SET can_update = COALESCE(can_edit, FALSE) WHERE can_update IS NULL`,
+		`ALTER TABLE role_field_permissions ALTER COLUMN can_set_on_create SET DEFAULT FALSE`,
+		`ALTER TABLE role_field_permissions ALTER COLUMN can_update SET DEFAULT FALSE`,
+		`ALTER TABLE resource_fields ADD COLUMN IF NOT EXISTS immutable BOOLEAN DEFAULT FALSE`,
+		// Four-eyes approval of sensitive field changes
+		`ALTER TABLE resources ADD COLUMN IF NOT EXISTS approver_role_id INTEGER REFERENCES roles(id) ON DELETE SET NULL`,
+		`CREATE INDEX IF NOT EXISTS pending_changes_record_idx ON pending_changes (resource_id, record_id) WHERE status = 'pending'`,
+		// Attachment actions
+		`ALTER TABLE role_resource_permissions ADD COLUMN IF NOT EXISTS can_list_attachments BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE role_resource_permissions ADD COLUMN IF NOT EXISTS can_download_attachments BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE role_resource_permissions ADD COLUMN IF NOT EXISTS can_upload_attachments BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE role_resource_permissions ADD COLUMN IF NOT EXISTS can_delete_attachments BOOLEAN DEFAULT FALSE`,
+		`UPDATE role_resource_permissions SET can_list_attachments = TRUE, can_download_attachments = TRUE,
+		 can_upload_attachments = TRUE, can_delete_attachments = TRUE WHERE role_id = 1`,
+		`CREATE INDEX IF NOT EXISTS attachments_record_idx ON attachments (resource_id, record_id)`,
+		// projects.assigned_to_name, left by the reference migration above: readable by the roles that
+		// can view assigned_to, written by nobody
+		`INSERT INTO resource_fields (resource_id, field_name, data_type, immutable)
+		 SELECT r.id, 'assigned_to_name', 'text', TRUE FROM resources r
+		 WHERE r.name = 'projects' AND EXISTS (
+			SELECT 1 FROM information_schema.columns WHERE table_name = 'projects' AND column_name = 'assigned_to_name'
+		 )
+		 ON CONFLICT (resource_id, field_name) DO NOTHING`,
+		`INSERT INTO role_field_permissions (role_id, resource_field_id, can_view, can_set_on_create, can_update)
+		 SELECT rfp.role_id, name_field.id, rfp.can_view, FALSE, FALSE
+		 FROM role_field_permissions rfp
+		 JOIN resource_fields rf ON rfp.resource_field_id = rf.id AND rf.field_name = 'assigned_to'
+		 JOIN resource_fields name_field ON name_field.resource_id = rf.resource_id AND name_field.field_name = 'assigned_to_name'
+		 JOIN resources r ON rf.resource_id = r.id AND r.name = 'projects'
+		 ON CONFLICT (role_id, resource_field_id) DO NOTHING`,
+		// Text fields restricted to a list of values are enum fields (same TEXT column)
+		`UPDATE resource_fields SET data_type = 'enum'
+		 WHERE data_type = 'text' AND jsonb_typeof(validation->'enum') = 'array' AND jsonb_array_length(validation->'enum') > 0`,
+	}
+
+	for _, query := range migrations {
+		if _, err := DB.Exec(query); err != nil {
+			log.Printf("Error applying migration: %v\nQuery: %s", err, query)
+		}
+	}
+	reportUnassignedProjects()
+
 	seedData()
 }
 
+// reportUnassignedProjects logs the projects whose former assignee name matched no employee, or
+// several, when assigned_to became a reference
+func reportUnassignedProjects() {
+	var exists bool
+	err := DB.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM information_schema.columns WHERE table_name = 'projects' AND column_name = 'assigned_to_name'
+	)`).Scan(&exists)
+	if err != nil || !exists {
+		return
+	}
+	rows, err := DB.Query(`
+		SELECT p.id, p.assigned_to_name, COUNT(e.id)
+		FROM projects p LEFT JOIN employees e ON e.name = p.assigned_to_name
+		WHERE p.assigned_to IS NULL AND COALESCE(p.assigned_to_name, '') <> ''
+		GROUP BY p.id, p.assigned_to_name
+		ORDER BY p.id
+	`)
+	if err != nil {
+		log.Printf("Error checking unassigned projects: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, matches int
+		var name string
+		if err := rows.Scan(&id, &name, &matches); err != nil {
+			log.Printf("Error checking unassigned projects: %v", err)
+			return
+		}
+		reason := "matches no employee"
+		if matches > 1 {
+			reason = fmt.Sprintf("matches %d employees", matches)
+		}
+		log.Printf("Project %d is unassigned: former assignee %q %s; set assigned_to by hand", id, name, reason)
+	}
+}
+
 func seedData() {
 	// Check if Admin role exists
 	var count int
@@ -208,9 +429,10 @@ func seedData() {
 
 		for _, resName := range resourceNames {
 			var resID int
+			isSystem := resName == "roles" || resName == "users"
 			err = DB.QueryRow(
-				"INSERT INTO resources (name, display_name) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET display_name = $2 RETURNING id",
-				resName, resourceDisplayNames[resName],
+				"INSERT INTO resources (name, display_name, is_system) VALUES ($1, $2, $3) ON CONFLICT (name) DO UPDATE SET display_name = $2 RETURNING id",
+				resName, resourceDisplayNames[resName], isSystem,
 			).Scan(&resID)
 			if err != nil {
 				log.Printf("Error seeding resource %s: %v", resName, err)
@@ -222,9 +444,12 @@ func seedData() {
 
 			// Grant full table-level permissions to Admin role
 			_, err = DB.Exec(
-				`INSERT INTO role_resource_permissions (role_id, resource_id, can_view, can_create, can_update, can_delete)
-				 VALUES ($1, $2, TRUE, TRUE, TRUE, TRUE)
-				 ON CONFLICT (role_id, resource_id) DO UPDATE SET can_view = TRUE, can_create = TRUE, can_update = TRUE, can_delete = TRUE`,
+				`INSERT INTO role_resource_permissions (role_id, resource_id, can_view, can_create, can_update, can_delete, can_restore, can_purge, can_export,
+				 can_list_attachments, can_download_attachments, can_upload_attachments, can_delete_attachments)
+				 VALUES ($1, $2, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE)
+				 ON CONFLICT (role_id, resource_id) DO UPDATE SET can_view = TRUE, can_create = TRUE, can_update = TRUE, can_delete = TRUE,
+				 can_restore = TRUE, can_purge = TRUE, can_export = TRUE,
+				 can_list_attachments = TRUE, can_download_attachments = TRUE, can_upload_attachments = TRUE, can_delete_attachments = TRUE`,
 				roleID, resID,
 			)
 			if err != nil {
@@ -243,32 +468,38 @@ func seedData() {
 }
 
 func seedFieldsForResource(resourceID int, resourceName string) {
+	required := `{"required": true}`
+	status := `{"required": true, "enum": ["Open", "In Progress", "Pending"]}`
+	amount := `{"required": true, "min": 0}`
+
 	fieldMap := map[string][]map[string]interface{}{
 		"employees": {
-			{"name": "name", "type": "text", "sensitive": false},
-			{"name": "position", "type": "text", "sensitive": false},
-			{"name": "salary", "type": "number", "sensitive": true},
-			{"name": "department", "type": "text", "sensitive": false},
+			{"name": "name", "type": "text", "sensitive": false, "validation": required},
+			{"name": "position", "type": "text", "sensitive": false, "validation": required},
+			{"name": "salary", "type": "number", "sensitive": true, "validation": amount, "min_group_size": 5},
+			{"name": "department", "type": "text", "sensitive": false, "validation": required},
+			{"name": "tenure_years", "type": "integer", "sensitive": false, "validation": "{}", "expression": "years_since(created_at)"},
 		},
 		"projects": {
-			{"name": "name", "type": "text", "sensitive": false},
-			{"name": "assigned_to", "type": "text", "sensitive": false},
-			{"name": "status", "type": "text", "sensitive": false},
-			{"name": "budget", "type": "number", "sensitive": true},
+			{"name": "name", "type": "text", "sensitive": false, "validation": required},
+			{"name": "assigned_to", "type": "reference", "sensitive": false, "validation": required, "ref_resource": "employees"},
+			{"name": "status", "type": "enum", "sensitive": false, "validation": status},
+			{"name": "budget", "type": "number", "sensitive": true, "validation": amount, "min_group_size": 5},
 		},
 		"orders": {
-			{"name": "customer_name", "type": "text", "sensitive": false},
-			{"name": "amount", "type": "number", "sensitive": false},
-			{"name": "status", "type": "text", "sensitive": false},
-			{"name": "order_date", "type": "text", "sensitive": false},
+			{"name": "customer_name", "type": "text", "sensitive": false, "validation": required, "immutable": true},
+			{"name": "amount", "type": "decimal", "sensitive": false, "validation": amount},
+			{"name": "status", "type": "enum", "sensitive": false, "validation": status},
+			{"name": "order_date", "type": "date", "sensitive": false, "validation": required},
+			{"name": "amount_with_tax", "type": "decimal", "sensitive": false, "validation": "{}", "expression": "round(amount * 1.2, 2)"},
 		},
 		"roles": {
-			{"name": "name", "type": "text", "sensitive": false},
+			{"name": "name", "type": "text", "sensitive": false, "validation": "{}"},
 		},
 		"users": {
-			{"name": "username", "type": "text", "sensitive": false},
-			{"name": "email", "type": "text", "sensitive": true},
-			{"name": "status", "type": "text", "sensitive": false},
+			{"name": "username", "type": "text", "sensitive": false, "validation": "{}"},
+			{"name": "email", "type": "email", "sensitive": true, "validation": "{}"},
+			{"name": "status", "type": "text", "sensitive": false, "validation": "{}"},
 		},
 	}
 
@@ -279,10 +510,10 @@ func seedFieldsForResource(resourceID int, resourceName string) {
 
 	for _, field := range fields {
 		_, err := DB.Exec(
-			`INSERT INTO resource_fields (resource_id, field_name, data_type, is_sensitive)
-			 VALUES ($1, $2, $3, $4)
+			`INSERT INTO resource_fields (resource_id, field_name, data_type, is_sensitive, validation, min_group_size, ref_resource, expression, immutable)
+			 VALUES ($1, $2, $3, $4, $5, COALESCE($6, 0), $7, $8, COALESCE($9, FALSE))
 			 ON CONFLICT (resource_id, field_name) DO NOTHING`,
-			resourceID, field["name"], field["type"], field["sensitive"],
+			resourceID, field["name"], field["type"], field["sensitive"], field["validation"], field["min_group_size"], field["ref_resource"], field["expression"], field["immutable"],
 		)
 		if err != nil {
 			log.Printf("Error seeding field %s for %s: %v", field["name"], resourceName, err)
@@ -302,9 +533,9 @@ func grantFieldPermissionsToAdmin(roleID, resourceID int) {
 		var fieldID int
 		rows.Scan(&fieldID)
 		_, err = DB.Exec(
-			`INSERT INTO role_field_permissions (role_id, resource_field_id, can_view, can_edit)
-			 VALUES ($1, $2, TRUE, TRUE)
-			 ON CONFLICT (role_id, resource_field_id) DO UPDATE SET can_view = TRUE, can_edit = TRUE`,
+			`INSERT INTO role_field_permissions (role_id, resource_field_id, can_view, can_edit, can_set_on_create, can_update)
+			 VALUES ($1, $2, TRUE, TRUE, TRUE, TRUE)
+			 ON CONFLICT (role_id, resource_field_id) DO UPDATE SET can_view = TRUE, can_edit = TRUE, can_set_on_create = TRUE, can_update = TRUE`,
 			roleID, fieldID,
 		)
 		if err != nil {
@@ -0,0 +1,155 @@
+// Package dbtest is a database/sql driver for tests. It answers statements from a script of
+// rules instead of a database and logs them, so code written against config.DB or a
+// history.Querier can be tested without Postgres.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Rule answers the statements containing Match (and whose first arguments are Args, if set),
+// at most Times times if that is set. Queries return Columns and Rows; Exec reports Affected
+// rows. Err fails the statement instead.
+type Rule struct {
+	Match    string
+	Args     []driver.Value
+	Times    int
+	Columns  []string
+	Rows     [][]driver.Value
+	Affected int64
+	Err      error
+
+	used int
+}
+
+// DB is a scripted database. Statements no rule matches return no rows and affect none.
+type DB struct {
+	*sql.DB
+
+	mu    sync.Mutex
+	rules []Rule
+	log   []string
+}
+
+// Open returns a database answering from rules, the first matching rule winning
+func Open(rules ...Rule) *DB {
+	db := &DB{rules: rules}
+	db.DB = sql.OpenDB(connector{db})
+	return db
+}
+
+// Statements returns the statements run so far, including BEGIN, COMMIT and ROLLBACK
+func (db *DB) Statements() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string{}, db.log...)
+}
+
+// Ran reports whether a statement containing text was run
+func (db *DB) Ran(text string) bool {
+	for _, s := range db.Statements() {
+		if strings.Contains(s, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) answer(query string, args []driver.Value) *Rule {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.log = append(db.log, query)
+	for i := range db.rules {
+		r := &db.rules[i]
+		if r.Times > 0 && r.used == r.Times {
+			continue
+		}
+		if strings.Contains(query, r.Match) && len(args) >= len(r.Args) &&
+			(len(r.Args) == 0 || reflect.DeepEqual(args[:len(r.Args)], r.Args)) {
+			r.used++
+			return r
+		}
+	}
+	return &Rule{}
+}
+
+type connector struct{ db *DB }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return &conn{c.db}, nil }
+func (c connector) Driver() driver.Driver                        { return drv{} }
+
+type drv struct{}
+
+func (drv) Open(string) (driver.Conn, error) {
+	return nil, errors.New("dbtest: use dbtest.Open")
+}
+
+type conn struct{ db *DB }
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) { return &stmt{c.db, query}, nil }
+func (c *conn) Close() error                              { return nil }
+func (c *conn) Begin() (driver.Tx, error) {
+	c.db.answer("BEGIN", nil)
+	return tx{c.db}, nil
+}
+
+type tx struct{ db *DB }
+
+func (t tx) Commit() error {
+	t.db.answer("COMMIT", nil)
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.db.answer("ROLLBACK", nil)
+	return nil
+}
+
+type stmt struct {
+	db    *DB
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	r := s.db.answer(s.query, args)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return driver.RowsAffected(r.Affected), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	r := s.db.answer(s.query, args)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return &rows{columns: r.Columns, values: r.Rows}, nil
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
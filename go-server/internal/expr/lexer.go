@@ -0,0 +1,149 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokParam
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Operators are matched longest first
+var operators = []string{"<=", ">=", "!=", "<>", "=", "<", ">", "+", "-", "*", "/"}
+
+func lex(src string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+
+		case c == '\'':
+			// 'single quoted', with '' as an escaped quote
+			start := i
+			i++
+			var sb strings.Builder
+			closed := false
+			for i < len(src) {
+				if src[i] == '\'' {
+					if i+1 < len(src) && src[i+1] == '\'' {
+						sb.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					closed = true
+					break
+				}
+				sb.WriteByte(src[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			tokens = append(tokens, token{tokString, sb.String(), start})
+
+		case c == ':':
+			// :current_user, :user.department
+			start := i
+			i++
+			for i < len(src) && (isIdentChar(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			name := src[start+1 : i]
+			if !validParam(name) {
+				return nil, fmt.Errorf("invalid parameter %q at position %d", src[start:i], start)
+			}
+			tokens = append(tokens, token{tokParam, name, start})
+
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			start := i
+			seenDot := false
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || (src[i] == '.' && !seenDot)) {
+				if src[i] == '.' {
+					seenDot = true
+				}
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i], start})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && isIdentChar(rune(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i], start})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{tokOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(src)})
+	return tokens, nil
+}
+
+func isIdentStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c rune) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// validParam accepts "current_user" and "user.<name>"
+func validParam(name string) bool {
+	if name == "current_user" {
+		return true
+	}
+	attr, ok := strings.CutPrefix(name, "user.")
+	if !ok || attr == "" || !isIdentStart(rune(attr[0])) {
+		return false
+	}
+	for _, c := range attr {
+		if !isIdentChar(c) {
+			return false
+		}
+	}
+	return true
+}
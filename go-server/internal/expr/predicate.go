@@ -0,0 +1,366 @@
+package expr
+
+// Row filter predicates, e.g.
+//
+//	assigned_to = :current_user
+//	department = :user.department AND (status IN ('Open', 'Pending') OR budget < 10000)
+//
+// Predicates are parsed into an AST and compiled to parameterized SQL; field names are
+// resolved by the caller and literals/parameters are always bound, never inlined.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxSourceLength = 1000
+	maxDepth        = 32
+)
+
+// Node is a boolean predicate node
+type Node interface {
+	walk(fn func(Node))
+}
+
+type And struct{ Left, Right Node }
+type Or struct{ Left, Right Node }
+type Not struct{ X Node }
+
+type Compare struct {
+	Field string
+	Op    string
+	Value Operand
+}
+
+type IsNull struct {
+	Field  string
+	Negate bool
+}
+
+type In struct {
+	Field  string
+	Values []Operand
+}
+
+// Operand is a literal value or a :parameter resolved at query time
+type Operand struct {
+	Literal interface{}
+	Param   string
+}
+
+func (n *And) walk(fn func(Node))     { fn(n); n.Left.walk(fn); n.Right.walk(fn) }
+func (n *Or) walk(fn func(Node))      { fn(n); n.Left.walk(fn); n.Right.walk(fn) }
+func (n *Not) walk(fn func(Node))     { fn(n); n.X.walk(fn) }
+func (n *Compare) walk(fn func(Node)) { fn(n) }
+func (n *IsNull) walk(fn func(Node))  { fn(n) }
+func (n *In) walk(fn func(Node))      { fn(n) }
+
+// Predicate is a parsed row filter
+type Predicate struct {
+	Source string
+	Root   Node
+}
+
+// ParsePredicate parses the row filter mini-language
+func ParsePredicate(src string) (*Predicate, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+	if len(src) > maxSourceLength {
+		return nil, fmt.Errorf("expression longer than %d characters", maxSourceLength)
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+	return &Predicate{Source: src, Root: root}, nil
+}
+
+// Fields lists the field names referenced by the predicate
+func (p *Predicate) Fields() []string {
+	seen := map[string]bool{}
+	fields := []string{}
+	add := func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			fields = append(fields, f)
+		}
+	}
+	p.Root.walk(func(n Node) {
+		switch n := n.(type) {
+		case *Compare:
+			add(n.Field)
+		case *IsNull:
+			add(n.Field)
+		case *In:
+			add(n.Field)
+		}
+	})
+	return fields
+}
+
+// SQL compiles the predicate. quote maps a field to a SQL identifier and resolve returns the value
+// bound for a :parameter. Placeholders are numbered from start.
+func (p *Predicate) SQL(quote func(string) string, resolve func(string) (interface{}, error), start int) (string, []interface{}, error) {
+	c := &compiler{quote: quote, resolve: resolve, next: start}
+	sql, err := c.compile(p.Root)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, c.args, nil
+}
+
+type compiler struct {
+	quote   func(string) string
+	resolve func(string) (interface{}, error)
+	args    []interface{}
+	next    int
+}
+
+var sqlComparison = map[string]string{"=": "=", "!=": "<>", "<>": "<>", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+
+func (c *compiler) bind(o Operand) (string, error) {
+	value := o.Literal
+	if o.Param != "" {
+		v, err := c.resolve(o.Param)
+		if err != nil {
+			return "", err
+		}
+		value = v
+	}
+	c.args = append(c.args, value)
+	c.next++
+	return fmt.Sprintf("$%d", c.next-1), nil
+}
+
+func (c *compiler) compile(n Node) (string, error) {
+	switch n := n.(type) {
+	case *And:
+		return c.binary(n.Left, n.Right, "AND")
+	case *Or:
+		return c.binary(n.Left, n.Right, "OR")
+	case *Not:
+		x, err := c.compile(n.X)
+		if err != nil {
+			return "", err
+		}
+		return "(NOT " + x + ")", nil
+	case *Compare:
+		ph, err := c.bind(n.Value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %s", c.quote(n.Field), sqlComparison[n.Op], ph), nil
+	case *IsNull:
+		if n.Negate {
+			return c.quote(n.Field) + " IS NOT NULL", nil
+		}
+		return c.quote(n.Field) + " IS NULL", nil
+	case *In:
+		phs := []string{}
+		for _, v := range n.Values {
+			ph, err := c.bind(v)
+			if err != nil {
+				return "", err
+			}
+			phs = append(phs, ph)
+		}
+		return fmt.Sprintf("%s IN (%s)", c.quote(n.Field), strings.Join(phs, ", ")), nil
+	}
+	return "", fmt.Errorf("unsupported expression")
+}
+
+func (c *compiler) binary(l, r Node, op string) (string, error) {
+	left, err := c.compile(l)
+	if err != nil {
+		return "", err
+	}
+	right, err := c.compile(r)
+	if err != nil {
+		return "", err
+	}
+	return "(" + left + " " + op + " " + right + ")", nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) advance() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr(depth int) (Node, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("expression nested too deeply")
+	}
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd(depth int) (Node, error) {
+	left, err := p.parseNot(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &And{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot(depth int) (Node, error) {
+	if p.keyword("NOT") {
+		if depth > maxDepth {
+			return nil, fmt.Errorf("expression nested too deeply")
+		}
+		x, err := p.parseNot(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &Not{x}, nil
+	}
+	return p.parsePrimary(depth)
+}
+
+func (p *parser) parsePrimary(depth int) (Node, error) {
+	t := p.peek()
+	if t.kind == tokLParen {
+		p.advance()
+		n, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.advance().kind != tokRParen {
+			return nil, fmt.Errorf("missing ) for ( at position %d", t.pos)
+		}
+		return n, nil
+	}
+
+	if t.kind != tokIdent || isKeyword(t.text) {
+		return nil, fmt.Errorf("expected a field name at position %d", t.pos)
+	}
+	field := p.advance().text
+
+	if p.keyword("IS") {
+		negate := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, fmt.Errorf("expected NULL at position %d", p.peek().pos)
+		}
+		return &IsNull{Field: field, Negate: negate}, nil
+	}
+
+	if p.keyword("IN") {
+		if p.advance().kind != tokLParen {
+			return nil, fmt.Errorf("expected ( after IN")
+		}
+		values := []Operand{}
+		for {
+			v, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			next := p.advance()
+			if next.kind == tokRParen {
+				break
+			}
+			if next.kind != tokComma {
+				return nil, fmt.Errorf("expected , or ) at position %d", next.pos)
+			}
+		}
+		return &In{Field: field, Values: values}, nil
+	}
+
+	op := p.advance()
+	if op.kind != tokOp || sqlComparison[op.text] == "" {
+		return nil, fmt.Errorf("expected a comparison operator at position %d", op.pos)
+	}
+	value, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &Compare{Field: field, Op: op.text, Value: value}, nil
+}
+
+func (p *parser) parseOperand() (Operand, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return Operand{}, fmt.Errorf("invalid number %q", t.text)
+		}
+		return Operand{Literal: n}, nil
+	case tokOp:
+		// Negative number literal
+		if t.text == "-" && p.peek().kind == tokNumber {
+			n, err := strconv.ParseFloat(p.advance().text, 64)
+			if err != nil {
+				return Operand{}, err
+			}
+			return Operand{Literal: -n}, nil
+		}
+	case tokString:
+		return Operand{Literal: t.text}, nil
+	case tokParam:
+		return Operand{Param: t.text}, nil
+	case tokIdent:
+		switch strings.ToUpper(t.text) {
+		case "TRUE":
+			return Operand{Literal: true}, nil
+		case "FALSE":
+			return Operand{Literal: false}, nil
+		}
+	}
+	return Operand{}, fmt.Errorf("expected a value at position %d", t.pos)
+}
+
+func isKeyword(word string) bool {
+	switch strings.ToUpper(word) {
+	case "AND", "OR", "NOT", "IS", "NULL", "IN", "TRUE", "FALSE":
+		return true
+	}
+	return false
+}
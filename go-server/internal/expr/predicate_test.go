@@ -0,0 +1,171 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// resolveName binds each parameter to its own name, so tests can see which one went where
+func resolveName(name string) (interface{}, error) {
+	return ":" + name, nil
+}
+
+var (
+	quotedIdent = regexp.MustCompile(`"(?:[^"]|"")*"`)
+	placeholder = regexp.MustCompile(`\$[0-9]+`)
+	// Once quoted identifiers are removed, only keywords, operators and placeholders may remain
+	sqlSkeleton = regexp.MustCompile(`^[A-Z ()<>=,$0-9]*$`)
+)
+
+// assertSQL checks that sql only names the predicate's fields, as quoted identifiers, and that
+// every value is a placeholder with a matching argument
+func assertSQL(t *testing.T, p *Predicate, sql string, args []interface{}, start int) {
+	t.Helper()
+	fields := map[string]bool{}
+	for _, f := range p.Fields() {
+		fields[f] = true
+	}
+	for _, ident := range quotedIdent.FindAllString(sql, -1) {
+		name := strings.ReplaceAll(ident[1:len(ident)-1], `""`, `"`)
+		if !fields[name] {
+			t.Fatalf("identifier %s is not a field of %q: %q", ident, p.Source, sql)
+		}
+	}
+	rest := quotedIdent.ReplaceAllString(sql, "")
+	if !sqlSkeleton.MatchString(rest) {
+		t.Fatalf("unexpected text outside identifiers: %q (from %q)", rest, p.Source)
+	}
+	phs := placeholder.FindAllString(rest, -1)
+	if len(phs) != len(args) {
+		t.Fatalf("%d placeholders for %d arguments in %q", len(phs), len(args), sql)
+	}
+	for i, ph := range phs {
+		if ph != fmt.Sprintf("$%d", start+i) {
+			t.Fatalf("placeholder %d is %s in %q", i, ph, sql)
+		}
+	}
+}
+
+func TestPredicateSQL(t *testing.T) {
+	tests := []struct {
+		src  string
+		sql  string
+		args []interface{}
+	}{
+		{"assigned_to = :current_user", `"assigned_to" = $3`, []interface{}{":current_user"}},
+		{
+			"department = :user.department AND (status IN ('Open', 'Pending') OR budget < -10000)",
+			`("department" = $3 AND ("status" IN ($4, $5) OR "budget" < $6))`,
+			[]interface{}{":user.department", "Open", "Pending", -10000.0},
+		},
+		{"a != 1 or b <> 2", `("a" <> $3 OR "b" <> $4)`, []interface{}{1.0, 2.0}},
+		{"NOT archived IS NOT NULL", `(NOT "archived" IS NOT NULL)`, nil},
+		{"closed_at is null and active = true", `("closed_at" IS NULL AND "active" = $3)`, []interface{}{true}},
+		{"name = 'O''Brien; DROP TABLE users --'", `"name" = $3`, []interface{}{"O'Brien; DROP TABLE users --"}},
+		{"a >= .5 AND b <= 2", `("a" >= $3 AND "b" <= $4)`, []interface{}{0.5, 2.0}},
+	}
+	for _, tt := range tests {
+		p, err := ParsePredicate(tt.src)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.src, err)
+		}
+		sql, args, err := p.SQL(quote, resolveName, 3)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.src, err)
+		}
+		if sql != tt.sql {
+			t.Errorf("%q: got %q, want %q", tt.src, sql, tt.sql)
+		}
+		if len(args) != 0 || len(tt.args) != 0 {
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("%q: got args %#v, want %#v", tt.src, args, tt.args)
+			}
+		}
+		assertSQL(t, p, sql, args, 3)
+	}
+}
+
+func TestParsePredicateErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"status =",
+		"status = 'open",
+		"status = 'open'; DROP TABLE projects",
+		"status = 'open' -- comment",
+		"status = other_field",
+		"status == 'open'",
+		"status LIKE 'o%'",
+		"a = :password",
+		"a = :user.",
+		"a = :user.bad-name",
+		"a IN ()",
+		"a IN (1, 2",
+		"(a = 1",
+		"a = 1)",
+		"AND = 1",
+		"NULL IS NULL",
+		`"quoted" = 1`,
+		"a = 1 OR",
+		"a IS 1",
+		strings.Repeat("(", maxDepth+2) + "a = 1" + strings.Repeat(")", maxDepth+2),
+		strings.Repeat("NOT ", maxDepth+2) + "a = 1",
+		"a = '" + strings.Repeat("x", maxSourceLength) + "'",
+	}
+	for _, src := range tests {
+		if p, err := ParsePredicate(src); err == nil {
+			t.Errorf("%q: expected an error, parsed %#v", src, p.Root)
+		}
+	}
+}
+
+func TestPredicateSQLResolveError(t *testing.T) {
+	p, err := ParsePredicate("a = 1 AND owner = :user.team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("no user")
+	_, _, err = p.SQL(quote, func(string) (interface{}, error) { return nil, failed }, 1)
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the resolver's error", err)
+	}
+}
+
+func TestPredicateFields(t *testing.T) {
+	p, err := ParsePredicate("a = 1 AND (b IS NULL OR a IN (2, 3)) AND NOT c = :current_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Fields(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+// Any source either fails to parse or compiles to SQL naming only its fields, with every value bound
+func FuzzParsePredicate(f *testing.F) {
+	f.Add("assigned_to = :current_user")
+	f.Add("department = :user.department AND (status IN ('Open', 'Pending') OR budget < 10000)")
+	f.Add("name = 'x'' OR 1=1 --'")
+	f.Add("NOT (a IS NOT NULL OR b != -1.5)")
+	f.Add("a = 1; DELETE FROM users")
+
+	f.Fuzz(func(t *testing.T, src string) {
+		p, err := ParsePredicate(src)
+		if err != nil {
+			return
+		}
+		sql, args, err := p.SQL(quote, resolveName, 1)
+		if err != nil {
+			t.Fatalf("%q parsed but did not compile: %v", src, err)
+		}
+		assertSQL(t, p, sql, args, 1)
+	})
+}
@@ -0,0 +1,446 @@
+package expr
+
+// Value expressions for computed fields, e.g.
+//
+//	round(amount * 1.2, 2)
+//	years_since(created_at)
+//	concat(first_name, ' ', last_name)
+//
+// They are evaluated in Go over one record at a time, never compiled to SQL. Only field names,
+// literals, + - * /, parentheses and the functions below are allowed; :parameters are not.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value is a value expression node
+type Value interface {
+	eval(get func(string) interface{}) (interface{}, error)
+}
+
+type fieldRef struct{ name string }
+type literal struct{ value interface{} }
+type negate struct{ x Value }
+
+type arith struct {
+	op          string
+	left, right Value
+}
+
+type call struct {
+	name string
+	fn   function
+	args []Value
+}
+
+// function checks and evaluates a call; max < 0 means any number of arguments from min.
+// Unless nullable, a call with a null argument is null.
+type function struct {
+	min, max int
+	nullable bool
+	apply    func(args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"round":        {min: 1, max: 2, apply: fnRound},
+	"abs":          {min: 1, max: 1, apply: fnAbs},
+	"coalesce":     {min: 1, max: -1, nullable: true, apply: fnCoalesce},
+	"concat":       {min: 1, max: -1, nullable: true, apply: fnConcat},
+	"upper":        {min: 1, max: 1, apply: func(a []interface{}) (interface{}, error) { return strings.ToUpper(text(a[0])), nil }},
+	"lower":        {min: 1, max: 1, apply: func(a []interface{}) (interface{}, error) { return strings.ToLower(text(a[0])), nil }},
+	"days_since":   {min: 1, max: 1, apply: fnDaysSince},
+	"years_since":  {min: 1, max: 1, apply: fnYearsSince},
+	"days_between": {min: 2, max: 2, apply: fnDaysBetween},
+}
+
+// Expression is a parsed value expression
+type Expression struct {
+	Source string
+	Root   Value
+}
+
+// ParseExpression parses the computed field expression language
+func ParseExpression(src string) (*Expression, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+	if len(src) > maxSourceLength {
+		return nil, fmt.Errorf("expression longer than %d characters", maxSourceLength)
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseSum(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+	return &Expression{Source: src, Root: root}, nil
+}
+
+// Fields lists the field names the expression reads
+func (e *Expression) Fields() []string {
+	seen := map[string]bool{}
+	fields := []string{}
+	var visit func(Value)
+	visit = func(v Value) {
+		switch v := v.(type) {
+		case *fieldRef:
+			if !seen[v.name] {
+				seen[v.name] = true
+				fields = append(fields, v.name)
+			}
+		case *negate:
+			visit(v.x)
+		case *arith:
+			visit(v.left)
+			visit(v.right)
+		case *call:
+			for _, a := range v.args {
+				visit(a)
+			}
+		}
+	}
+	visit(e.Root)
+	return fields
+}
+
+// Eval computes the expression for one record; get returns the value of a field. The result is
+// nil, a float64, a string or a bool. Arithmetic on null is null.
+func (e *Expression) Eval(get func(field string) interface{}) (interface{}, error) {
+	return e.Root.eval(get)
+}
+
+func (v *fieldRef) eval(get func(string) interface{}) (interface{}, error) {
+	return get(v.name), nil
+}
+
+func (v *literal) eval(func(string) interface{}) (interface{}, error) {
+	return v.value, nil
+}
+
+func (v *negate) eval(get func(string) interface{}) (interface{}, error) {
+	x, err := v.x.eval(get)
+	if err != nil || x == nil {
+		return nil, err
+	}
+	n, err := number(x)
+	if err != nil {
+		return nil, err
+	}
+	return -n, nil
+}
+
+func (v *arith) eval(get func(string) interface{}) (interface{}, error) {
+	l, err := v.left.eval(get)
+	if err != nil {
+		return nil, err
+	}
+	r, err := v.right.eval(get)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	a, err := number(l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := number(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	}
+	if b == 0 {
+		return nil, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
+func (v *call) eval(get func(string) interface{}) (interface{}, error) {
+	args := make([]interface{}, len(v.args))
+	for i, a := range v.args {
+		x, err := a.eval(get)
+		if err != nil {
+			return nil, err
+		}
+		if x == nil && !v.fn.nullable {
+			return nil, nil
+		}
+		args[i] = x
+	}
+	result, err := v.fn.apply(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", v.name, err)
+	}
+	return result, nil
+}
+
+// number converts a field value or intermediate result; strings are not numbers
+func number(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int64:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	}
+	return 0, fmt.Errorf("expected a number, got %T", v)
+}
+
+// text formats a value for concat, upper and lower
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// date reads a date or datetime value as a calendar day in UTC
+func date(v interface{}) (time.Time, error) {
+	var t time.Time
+	switch d := v.(type) {
+	case time.Time:
+		t = d
+	case string:
+		var err error
+		if t, err = time.Parse("2006-01-02", d); err != nil {
+			if t, err = time.Parse(time.RFC3339, d); err != nil {
+				return time.Time{}, fmt.Errorf("expected a date, got %q", d)
+			}
+		}
+	default:
+		return time.Time{}, fmt.Errorf("expected a date, got %T", v)
+	}
+	y, m, day := t.UTC().Date()
+	return time.Date(y, m, day, 0, 0, 0, 0, time.UTC), nil
+}
+
+func fnRound(args []interface{}) (interface{}, error) {
+	n, err := number(args[0])
+	if err != nil {
+		return nil, err
+	}
+	digits := 0.0
+	if len(args) == 2 {
+		if digits, err = number(args[1]); err != nil {
+			return nil, err
+		}
+		if digits != math.Trunc(digits) || digits < 0 || digits > 10 {
+			return nil, errors.New("digits must be a whole number from 0 to 10")
+		}
+	}
+	scale := math.Pow(10, digits)
+	return math.Round(n*scale) / scale, nil
+}
+
+func fnAbs(args []interface{}) (interface{}, error) {
+	n, err := number(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return math.Abs(n), nil
+}
+
+func fnCoalesce(args []interface{}) (interface{}, error) {
+	for _, a := range args {
+		if a != nil {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+func fnConcat(args []interface{}) (interface{}, error) {
+	var sb strings.Builder
+	for _, a := range args {
+		sb.WriteString(text(a))
+	}
+	return sb.String(), nil
+}
+
+func fnDaysSince(args []interface{}) (interface{}, error) {
+	return fnDaysBetween([]interface{}{args[0], time.Now()})
+}
+
+// fnDaysBetween counts the days from the first date to the second
+func fnDaysBetween(args []interface{}) (interface{}, error) {
+	from, err := date(args[0])
+	if err != nil {
+		return nil, err
+	}
+	to, err := date(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return math.Round(to.Sub(from).Hours() / 24), nil
+}
+
+// fnYearsSince counts the full years since a date, like an age or a tenure
+func fnYearsSince(args []interface{}) (interface{}, error) {
+	from, err := date(args[0])
+	if err != nil {
+		return nil, err
+	}
+	now, _ := date(time.Now())
+	years := now.Year() - from.Year()
+	if now.Month() < from.Month() || (now.Month() == from.Month() && now.Day() < from.Day()) {
+		years--
+	}
+	return float64(years), nil
+}
+
+func (p *parser) parseSum(depth int) (Value, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("expression nested too deeply")
+	}
+	left, err := p.parseProduct(depth)
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokOp && (t.text == "+" || t.text == "-"); t = p.peek() {
+		p.advance()
+		right, err := p.parseProduct(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &arith{op: t.text, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseProduct(depth int) (Value, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokOp && (t.text == "*" || t.text == "/"); t = p.peek() {
+		p.advance()
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &arith{op: t.text, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary(depth int) (Value, error) {
+	if t := p.peek(); t.kind == tokOp && t.text == "-" {
+		if depth > maxDepth {
+			return nil, fmt.Errorf("expression nested too deeply")
+		}
+		p.advance()
+		x, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &negate{x}, nil
+	}
+	return p.parseTerm(depth)
+}
+
+func (p *parser) parseTerm(depth int) (Value, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokLParen:
+		v, err := p.parseSum(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.advance().kind != tokRParen {
+			return nil, fmt.Errorf("missing ) for ( at position %d", t.pos)
+		}
+		return v, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return &literal{n}, nil
+	case tokString:
+		return &literal{t.text}, nil
+	case tokParam:
+		return nil, fmt.Errorf("parameters such as :%s cannot be used in computed fields", t.text)
+	case tokIdent:
+		switch strings.ToUpper(t.text) {
+		case "NULL":
+			return &literal{nil}, nil
+		case "TRUE":
+			return &literal{true}, nil
+		case "FALSE":
+			return &literal{false}, nil
+		}
+		if p.peek().kind == tokLParen {
+			return p.parseCall(t, depth)
+		}
+		if isKeyword(t.text) {
+			return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+		}
+		return &fieldRef{t.text}, nil
+	}
+	return nil, fmt.Errorf("expected a value at position %d", t.pos)
+}
+
+func (p *parser) parseCall(name token, depth int) (Value, error) {
+	fn, ok := functions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	p.advance() // (
+
+	args := []Value{}
+	if p.peek().kind == tokRParen {
+		p.advance()
+	} else {
+		for {
+			arg, err := p.parseSum(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			next := p.advance()
+			if next.kind == tokRParen {
+				break
+			}
+			if next.kind != tokComma {
+				return nil, fmt.Errorf("expected , or ) at position %d", next.pos)
+			}
+		}
+	}
+
+	if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+		return nil, fmt.Errorf("wrong number of arguments to %s at position %d", strings.ToLower(name.text), name.pos)
+	}
+	return &call{name: strings.ToLower(name.text), fn: fn, args: args}, nil
+}
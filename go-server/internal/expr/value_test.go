@@ -0,0 +1,121 @@
+package expr
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	row := map[string]interface{}{
+		"amount":     json.Number("100.50"),
+		"quantity":   int64(3),
+		"first_name": "Ada",
+		"last_name":  "Lovelace",
+		"nothing":    nil,
+		"start":      "2024-01-31",
+		"end":        time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"round(amount * 1.2, 2)", 120.6},
+		{"amount - quantity * 2 / 4", 99.0},
+		{"-(quantity + 1)", -4.0},
+		{"abs(-2.5)", 2.5},
+		{"concat(first_name, ' ', last_name)", "Ada Lovelace"},
+		{"upper(first_name)", "ADA"},
+		{"coalesce(nothing, first_name)", "Ada"},
+		{"days_between(start, end)", 30.0},
+		{"nothing + 1", nil},
+		{"round(nothing)", nil},
+		{"concat(nothing, 'x')", "x"},
+		{"NULL", nil},
+		{"TRUE", true},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpression(tt.src)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.src, err)
+		}
+		got, err := e.Eval(func(f string) interface{} { return row[f] })
+		if err != nil {
+			t.Fatalf("%q: %v", tt.src, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	row := map[string]interface{}{"amount": 10.0, "name": "x", "zero": 0.0}
+	for _, src := range []string{
+		"amount / zero",
+		"name * 2",
+		"round(amount, 11)",
+		"round(amount, 1.5)",
+		"days_since(name)",
+	} {
+		e, err := ParseExpression(src)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		if v, err := e.Eval(func(f string) interface{} { return row[f] }); err == nil {
+			t.Errorf("%q: expected an error, got %#v", src, v)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"amount *",
+		"amount = 1",
+		"amount * :current_user",
+		"unknown(amount)",
+		"round()",
+		"round(amount, 1, 2)",
+		"abs(amount",
+		"amount AND 1",
+		"amount; DROP TABLE orders",
+		strings.Repeat("(", maxDepth+2) + "1" + strings.Repeat(")", maxDepth+2),
+		strings.Repeat("-", maxDepth+2) + "1",
+	} {
+		if _, err := ParseExpression(src); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestExpressionFields(t *testing.T) {
+	e, err := ParseExpression("round(amount * rate, 2) + amount - coalesce(discount, 0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Fields(); !reflect.DeepEqual(got, []string{"amount", "rate", "discount"}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+// Any expression that parses evaluates without panicking, whatever the field values
+func FuzzParseExpression(f *testing.F) {
+	f.Add("round(amount * 1.2, 2)")
+	f.Add("concat(first_name, ' ', last_name)")
+	f.Add("years_since(created_at) / (1 - 1)")
+	f.Add("coalesce(x, 'a') * -amount")
+
+	values := []interface{}{nil, 1.5, int64(-2), json.Number("3.25"), "2024-02-29", "text", true, time.Now()}
+	f.Fuzz(func(t *testing.T, src string) {
+		e, err := ParseExpression(src)
+		if err != nil {
+			return
+		}
+		for _, v := range values {
+			e.Eval(func(string) interface{} { return v })
+		}
+	})
+}
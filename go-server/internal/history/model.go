@@ -0,0 +1,38 @@
+package history
+
+import "time"
+
+// Recorded actions
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+	ActionRevert  = "revert"
+	ActionApprove = "approve" // a pending change applied by its approver
+)
+
+// Entry is one change to one record
+type Entry struct {
+	ID        int               `json:"id"`
+	Action    string            `json:"action"`
+	ActorID   *int              `json:"actor_id"`
+	Actor     string            `json:"actor"`
+	ChangedAt time.Time         `json:"changed_at"`
+	Changes   map[string]Change `json:"changes"`
+
+	// Snapshot is the full row after the change (before it, for deletes); not exposed directly
+	Snapshot map[string]interface{} `json:"-"`
+}
+
+// Change holds the old and new value of one field
+type Change struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// Gone reports whether the record no longer exists after this entry
+func (e *Entry) Gone() bool {
+	return e.Action == ActionDelete || e.Action == ActionPurge
+}
@@ -0,0 +1,253 @@
+package history
+
+// Change history of resource records. Entries are written with the same Querier (usually a
+// transaction) as the data change, so a change and its history entry commit together.
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Querier is satisfied by *sql.DB and *sql.Tx
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type Repository struct{}
+
+// Record stores a change of one record. before is nil for creates and after is nil for
+// hard deletes; the changed fields are computed from the two rows.
+func (r *Repository) Record(q Querier, resourceID, recordID int, action string, actorID int, actor string, before, after map[string]interface{}) error {
+	changes := Diff(before, after)
+	snapshot := after
+	if snapshot == nil {
+		snapshot = before
+	}
+
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	snapshotJSON, err := json.Marshal(normalize(snapshot))
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO record_history (resource_id, record_id, action, actor_id, actor, changes, snapshot)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, resourceID, recordID, action, actorID, actor, changesJSON, snapshotJSON)
+	return err
+}
+
+const entryColumns = `id, action, actor_id, COALESCE(actor, ''), created_at, changes, snapshot`
+
+// List returns the history of a record, newest first
+func (r *Repository) List(q Querier, resourceID, recordID int) ([]Entry, error) {
+	rows, err := q.Query(`
+		SELECT `+entryColumns+`
+		FROM record_history
+		WHERE resource_id = $1 AND record_id = $2
+		ORDER BY id DESC
+	`, resourceID, recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, rows.Err()
+}
+
+// Get returns one entry of a record, or nil if it does not exist
+func (r *Repository) Get(q Querier, resourceID, recordID, entryID int) (*Entry, error) {
+	row := q.QueryRow(`
+		SELECT `+entryColumns+`
+		FROM record_history
+		WHERE resource_id = $1 AND record_id = $2 AND id = $3
+	`, resourceID, recordID, entryID)
+	e, err := scanEntry(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return e, err
+}
+
+// StateAt reconstructs a record as it was at t. current is the row as it is now (nil if it no
+// longer exists) and is used when the record has not changed since t. The result is nil if the
+// record did not exist (or was deleted) at that time.
+func (r *Repository) StateAt(q Querier, resourceID, recordID int, t time.Time, current map[string]interface{}) (map[string]interface{}, error) {
+	// The last change at or before t holds the state directly
+	row := q.QueryRow(`
+		SELECT `+entryColumns+`
+		FROM record_history
+		WHERE resource_id = $1 AND record_id = $2 AND created_at <= $3
+		ORDER BY id DESC LIMIT 1
+	`, resourceID, recordID, t)
+	e, err := scanEntry(row)
+	if err == nil {
+		if e.Gone() {
+			return nil, nil
+		}
+		return e.Snapshot, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// Otherwise undo the first change after t (records that predate history have no create entry)
+	row = q.QueryRow(`
+		SELECT `+entryColumns+`
+		FROM record_history
+		WHERE resource_id = $1 AND record_id = $2 AND created_at > $3
+		ORDER BY id ASC LIMIT 1
+	`, resourceID, recordID, t)
+	e, err = scanEntry(row)
+	if err == sql.ErrNoRows {
+		return normalize(current), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.Action == ActionCreate || e.Action == ActionRestore {
+		return nil, nil // did not exist yet, or was in the trash
+	}
+
+	state := e.Snapshot
+	for field, c := range e.Changes {
+		state[field] = c.Old
+	}
+	return state, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEntry(s scanner) (*Entry, error) {
+	var e Entry
+	var actorID sql.NullInt64
+	var changes, snapshot []byte
+	if err := s.Scan(&e.ID, &e.Action, &actorID, &e.Actor, &e.ChangedAt, &changes, &snapshot); err != nil {
+		return nil, err
+	}
+	if actorID.Valid {
+		id := int(actorID.Int64)
+		e.ActorID = &id
+	}
+	if err := decode(changes, &e.Changes); err != nil {
+		return nil, fmt.Errorf("history entry %d: %w", e.ID, err)
+	}
+	if err := decode(snapshot, &e.Snapshot); err != nil {
+		return nil, fmt.Errorf("history entry %d: %w", e.ID, err)
+	}
+	if e.Changes == nil {
+		e.Changes = map[string]Change{}
+	}
+	if e.Snapshot == nil {
+		e.Snapshot = map[string]interface{}{}
+	}
+	return &e, nil
+}
+
+// decode keeps numbers as json.Number so integers round-trip exactly
+func decode(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// Diff returns the fields whose values differ between two rows (id excluded)
+func Diff(before, after map[string]interface{}) map[string]Change {
+	before, after = normalize(before), normalize(after)
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	delete(keys, "id")
+
+	changes := map[string]Change{}
+	for k := range keys {
+		if !Equal(before[k], after[k]) {
+			changes[k] = Change{Old: before[k], New: after[k]}
+		}
+	}
+	return changes
+}
+
+// Equal compares two field values loosely, so 5, 5.0 and json.Number("5") are the same
+func Equal(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			return x == y
+		}
+	}
+	return fmt.Sprint(normalizeValue(a)) == fmt.Sprint(normalizeValue(b))
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// normalize converts scanned values into their JSON form (times as RFC 3339 strings)
+func normalize(row map[string]interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		out[k] = normalizeValue(v)
+	}
+	return out
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	case json.RawMessage:
+		// JSON fields are compared and stored as values, like snapshots read back from the history
+		var decoded interface{}
+		if err := decode(v, &decoded); err == nil {
+			return decoded
+		}
+		return string(v)
+	}
+	return v
+}
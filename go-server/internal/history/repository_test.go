@@ -0,0 +1,106 @@
+package history
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"server/internal/dbtest"
+	"testing"
+	"time"
+)
+
+func TestEqual(t *testing.T) {
+	same := [][2]interface{}{
+		{nil, nil},
+		{int64(5), 5.0},
+		{json.Number("5.0"), int64(5)},
+		{"a", "a"},
+		{time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), "2024-05-01T10:00:00Z"},
+		{json.RawMessage(`{"a": 1}`), map[string]interface{}{"a": json.Number("1")}},
+	}
+	for _, p := range same {
+		if !Equal(p[0], p[1]) {
+			t.Errorf("%#v and %#v differ", p[0], p[1])
+		}
+	}
+	different := [][2]interface{}{
+		{nil, ""},
+		{int64(0), nil},
+		{int64(5), 5.5},
+		{"5", "5.0"},
+	}
+	for _, p := range different {
+		if Equal(p[0], p[1]) {
+			t.Errorf("%#v and %#v are equal", p[0], p[1])
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{"id": int64(1), "name": "Ada", "amount": json.Number("5"), "note": nil}
+	after := map[string]interface{}{"id": int64(1), "name": "Ada L.", "amount": int64(5), "note": "x"}
+
+	want := map[string]Change{"name": {Old: "Ada", New: "Ada L."}, "note": {Old: nil, New: "x"}}
+	if got := Diff(before, after); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+	if got := Diff(nil, after); len(got) != 3 || got["name"].Old != nil {
+		t.Fatalf("create: got %#v", got)
+	}
+	if got := Diff(before, nil); len(got) != 2 || got["name"].New != nil {
+		t.Fatalf("delete: got %#v", got)
+	}
+}
+
+var entryCols = []string{"id", "action", "actor_id", "actor", "created_at", "changes", "snapshot"}
+
+func entryRow(id int64, action, changes, snapshot string) []driver.Value {
+	return []driver.Value{id, action, int64(1), "admin", time.Now(), []byte(changes), []byte(snapshot)}
+}
+
+func TestStateAt(t *testing.T) {
+	at := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	current := map[string]interface{}{"id": int64(7), "status": "Closed"}
+	tests := []struct {
+		name   string
+		before [][]driver.Value // last entry at or before the time
+		after  [][]driver.Value // first entry after it
+		want   map[string]interface{}
+	}{
+		{
+			name:   "snapshot of the last change",
+			before: [][]driver.Value{entryRow(3, ActionUpdate, `{"status":{"old":"New","new":"Open"}}`, `{"id":7,"status":"Open"}`)},
+			want:   map[string]interface{}{"id": json.Number("7"), "status": "Open"},
+		},
+		{
+			name:   "deleted by then",
+			before: [][]driver.Value{entryRow(4, ActionDelete, `{}`, `{"id":7,"status":"Open"}`)},
+		},
+		{
+			name:  "first change undone",
+			after: [][]driver.Value{entryRow(5, ActionUpdate, `{"status":{"old":"Open","new":"Closed"}}`, `{"id":7,"status":"Closed"}`)},
+			want:  map[string]interface{}{"id": json.Number("7"), "status": "Open"},
+		},
+		{
+			name:  "created later",
+			after: [][]driver.Value{entryRow(1, ActionCreate, `{}`, `{"id":7,"status":"New"}`)},
+		},
+		{
+			name: "unchanged since",
+			want: current,
+		},
+	}
+	for _, tt := range tests {
+		db := dbtest.Open(
+			dbtest.Rule{Match: "created_at <= $3", Columns: entryCols, Rows: tt.before},
+			dbtest.Rule{Match: "created_at > $3", Columns: entryCols, Rows: tt.after},
+		)
+		got, err := (&Repository{}).StateAt(db, 1, 7, at, current)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
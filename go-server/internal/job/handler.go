@@ -0,0 +1,25 @@
+package job
+
+import (
+	"net/http"
+	"server/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	Service *Service
+}
+
+// Get reports the progress of a job and, once finished, its result
+func (h *Handler) Get(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	j, ok := h.Service.Get(c.Param("id"), claims.ID, claims.RoleID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
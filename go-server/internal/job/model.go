@@ -0,0 +1,25 @@
+package job
+
+import "time"
+
+// Job states
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Job is a long-running request executed in the background. Jobs live in memory only and are
+// forgotten some time after they finish.
+type Job struct {
+	ID         string      `json:"id"`
+	Kind       string      `json:"kind"`
+	OwnerID    int         `json:"-"`
+	Status     string      `json:"status"`
+	Total      int         `json:"total"`
+	Processed  int         `json:"processed"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,97 @@
+package job
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// Finished jobs are kept this long so clients can pick up the result
+const retention = time.Hour
+
+// Func does the work of a job. It reports progress as the number of processed items and
+// returns the result that is shown once the job has finished.
+type Func func(progress func(processed int)) (interface{}, error)
+
+type Service struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// Start runs fn in the background and returns the job tracking it
+func (s *Service) Start(kind string, ownerID, total int, fn Func) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	j := &Job{ID: id, Kind: kind, OwnerID: ownerID, Status: StatusRunning, Total: total, CreatedAt: time.Now()}
+
+	s.mu.Lock()
+	if s.jobs == nil {
+		s.jobs = make(map[string]*Job)
+	}
+	s.expire()
+	s.jobs[id] = j
+	snapshot := *j
+	s.mu.Unlock()
+
+	go s.run(j, fn)
+	return &snapshot, nil
+}
+
+func (s *Service) run(j *Job, fn Func) {
+	progress := func(processed int) {
+		s.mu.Lock()
+		j.Processed = processed
+		s.mu.Unlock()
+	}
+
+	result, err := fn(progress)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	j.FinishedAt = &now
+	j.Result = result
+	if err != nil {
+		log.Printf("Job %s (%s) failed: %v", j.ID, j.Kind, err)
+		j.Status = StatusFailed
+		j.Error = err.Error()
+		return
+	}
+	j.Status = StatusSucceeded
+	j.Processed = j.Total
+}
+
+// Get returns a copy of a job, or false if it does not exist or belongs to someone else.
+// Admins (Role 1) may see every job.
+func (s *Service) Get(id string, userID, roleID int) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || (j.OwnerID != userID && roleID != 1) {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// expire drops finished jobs past their retention; the caller holds s.mu
+func (s *Service) expire() {
+	cutoff := time.Now().Add(-retention)
+	for id, j := range s.jobs {
+		if j.FinishedAt != nil && j.FinishedAt.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,7 +1,7 @@
 package middleware
 
 import (
-	"server/internal/config"
+	"server/internal/permission"
 	"server/pkg/utils"
 
 	"github.com/gin-gonic/gin"
@@ -25,21 +25,7 @@ func RBACMiddleware(resource, action string) gin.HandlerFunc {
 		}
 
 		// Check permission using new RBAC schema
-		query := `
-			SELECT CASE 
-				WHEN $3 = 'read' THEN rrp.can_view
-				WHEN $3 = 'create' THEN rrp.can_create
-				WHEN $3 = 'update' THEN rrp.can_update
-				WHEN $3 = 'delete' THEN rrp.can_delete
-				ELSE false
-			END as has_permission
-			FROM role_resource_permissions rrp
-			JOIN resources res ON rrp.resource_id = res.id
-			WHERE rrp.role_id = $1 AND res.name = $2
-		`
-
-		var hasPermission bool
-		err := config.DB.QueryRow(query, claims.RoleID, resource, action).Scan(&hasPermission)
+		hasPermission, err := permission.HasPermission(claims.RoleID, resource, action)
 
 		if err != nil || !hasPermission {
 			c.JSON(403, gin.H{"message": "Access denied"})
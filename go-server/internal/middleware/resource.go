@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"errors"
+	"server/internal/registry"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResourceMiddleware resolves :resource against the registry and rejects unknown or system resources
+func ResourceMiddleware(reg *registry.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := reg.Get(c.Param("resource"))
+		if err != nil && !errors.Is(err, registry.ErrNotFound) {
+			c.JSON(500, gin.H{"message": err.Error()})
+			c.Abort()
+			return
+		}
+		if err != nil || res.IsSystem {
+			c.JSON(404, gin.H{"message": "Resource not found"})
+			c.Abort()
+			return
+		}
+
+		c.Set("resource", res)
+		c.Next()
+	}
+}
@@ -0,0 +1,114 @@
+package permission
+
+import (
+	"database/sql"
+	"server/internal/config"
+)
+
+// Table-level actions
+const (
+	ActionRead    = "read"
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+	ActionExport  = "export"
+
+	// Files attached to records (see internal/attachment)
+	ActionListAttachments     = "list_attachments"
+	ActionDownloadAttachments = "download_attachments"
+	ActionUploadAttachments   = "upload_attachments"
+	ActionDeleteAttachments   = "delete_attachments"
+)
+
+// Actions lists every table-level action in display order
+var Actions = []string{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionRestore, ActionPurge, ActionExport,
+	ActionListAttachments, ActionDownloadAttachments, ActionUploadAttachments, ActionDeleteAttachments}
+
+// Column of role_resource_permissions that grants each action. This is the only place the
+// mapping lives; column names taken from it are safe to splice into SQL.
+var actionColumns = map[string]string{
+	ActionRead:    "can_view",
+	ActionCreate:  "can_create",
+	ActionUpdate:  "can_update",
+	ActionDelete:  "can_delete",
+	ActionRestore: "can_restore",
+	ActionPurge:   "can_purge",
+	ActionExport:  "can_export",
+
+	ActionListAttachments:     "can_list_attachments",
+	ActionDownloadAttachments: "can_download_attachments",
+	ActionUploadAttachments:   "can_upload_attachments",
+	ActionDeleteAttachments:   "can_delete_attachments",
+}
+
+// Column returns the permission column for an action, or "" for an unknown action
+func Column(action string) string {
+	return actionColumns[action]
+}
+
+// HasPermission reports whether a role is granted an action on a resource
+func HasPermission(roleID int, resource, action string) (bool, error) {
+	column := Column(action)
+	if column == "" {
+		return false, nil
+	}
+
+	query := `
+		SELECT COALESCE(rrp.` + column + `, false)
+		FROM role_resource_permissions rrp
+		JOIN resources res ON rrp.resource_id = res.id
+		WHERE rrp.role_id = $1 AND res.name = $2
+	`
+	var allowed bool
+	err := config.DB.QueryRow(query, roleID, resource).Scan(&allowed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return allowed, err
+}
+
+// Grant is one action a role may perform on a resource
+type Grant struct {
+	Resource string
+	Action   string
+}
+
+// GrantedActions lists every action granted to a role, grouped by resource
+func GrantedActions(roleID int) ([]Grant, error) {
+	cols := ""
+	for _, action := range Actions {
+		cols += ", COALESCE(rrp." + actionColumns[action] + ", false)"
+	}
+	rows, err := config.DB.Query(`
+		SELECT res.name`+cols+`
+		FROM role_resource_permissions rrp
+		JOIN resources res ON rrp.resource_id = res.id
+		WHERE rrp.role_id = $1
+		ORDER BY res.name
+	`, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []Grant{}
+	for rows.Next() {
+		var name string
+		flags := make([]bool, len(Actions))
+		dest := []interface{}{&name}
+		for i := range flags {
+			dest = append(dest, &flags[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, ok := range flags {
+			if ok {
+				grants = append(grants, Grant{Resource: name, Action: Actions[i]})
+			}
+		}
+	}
+	return grants, rows.Err()
+}
@@ -21,14 +21,23 @@ type ResourceField struct {
 
 // Table-level permissions
 type RoleResourcePermission struct {
-	ID         int       `json:"id"`
-	RoleID     int       `json:"role_id"`
-	ResourceID int       `json:"resource_id"`
-	CanView    bool      `json:"can_view"`
-	CanCreate  bool      `json:"can_create"`
-	CanUpdate  bool      `json:"can_update"`
-	CanDelete  bool      `json:"can_delete"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         int  `json:"id"`
+	RoleID     int  `json:"role_id"`
+	ResourceID int  `json:"resource_id"`
+	CanView    bool `json:"can_view"`
+	CanCreate  bool `json:"can_create"`
+	CanUpdate  bool `json:"can_update"`
+	CanDelete  bool `json:"can_delete"`
+	CanRestore bool `json:"can_restore"`
+	CanPurge   bool `json:"can_purge"`
+	CanExport  bool `json:"can_export"`
+
+	CanListAttachments     bool `json:"can_list_attachments"`
+	CanDownloadAttachments bool `json:"can_download_attachments"`
+	CanUploadAttachments   bool `json:"can_upload_attachments"`
+	CanDeleteAttachments   bool `json:"can_delete_attachments"`
+
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // Field-level permissions
@@ -38,9 +47,27 @@ type RoleFieldPermission struct {
 	ResourceFieldID int       `json:"resource_field_id"`
 	CanView         bool      `json:"can_view"`
 	CanEdit         bool      `json:"can_edit"`
+	MaskMode        string    `json:"mask_mode"`
 	CreatedAt       time.Time `json:"created_at"`
 }
 
+// Masking modes for fields a role cannot view
+const (
+	MaskOmit    = "omit"    // field is left out of the response (default)
+	MaskRedact  = "redact"  // value replaced by "***"
+	MaskPartial = "partial" // only the last 4 characters are shown
+	MaskBucket  = "bucket"  // numbers shown as a range, e.g. "50k–75k"
+)
+
+// ValidMaskMode reports whether mode is a known masking mode
+func ValidMaskMode(mode string) bool {
+	switch mode {
+	case MaskOmit, MaskRedact, MaskPartial, MaskBucket:
+		return true
+	}
+	return false
+}
+
 // Legacy permission (backward compatibility)
 type Permission struct {
 	ID         int    `json:"id"`
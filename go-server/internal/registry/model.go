@@ -0,0 +1,132 @@
+package registry
+
+// Columns managed by the server
+const (
+	DeletedAtColumn = "deleted_at" // set when a row is moved to the trash (soft delete)
+	VersionColumn   = "version"    // incremented on every update, exposed as the ETag
+)
+
+// Resource is a registered entry of the resources table together with its fields
+type Resource struct {
+	ID             int     `json:"id"`
+	Name           string  `json:"name"`
+	DisplayName    string  `json:"display_name"`
+	IsSystem       bool    `json:"is_system"`
+	SoftDelete     bool    `json:"soft_delete"`
+	RequireIfMatch bool    `json:"require_if_match"`           // updates and deletes without If-Match get 428
+	ApproverRoleID int     `json:"approver_role_id,omitempty"` // changes to sensitive fields wait for this role's approval
+	Fields         []Field `json:"fields"`
+
+	// Columns present on the live table (from information_schema), empty for system resources
+	Columns map[string]bool `json:"-"`
+}
+
+type Field struct {
+	ID           int             `json:"id"`
+	Name         string          `json:"field_name"`
+	DataType     string          `json:"data_type"`
+	IsSensitive  bool            `json:"is_sensitive"`
+	Validation   ValidationRules `json:"validation"`
+	MinGroupSize int             `json:"min_group_size"`         // smallest group an aggregate-only role may see (0 = any)
+	Reference    string          `json:"ref_resource,omitempty"` // target resource of a reference field
+	Expression   string          `json:"expression,omitempty"`   // set for computed fields, which have no column
+	Immutable    bool            `json:"immutable"`              // can be set on create but never changed
+	Transitions  []Transition    `json:"transitions,omitempty"`  // state machine of the field's values, if any
+}
+
+// Transition is an allowed change of a state field from one value to another. From is empty for
+// the initial states new records may start in. Roles are granted transitions one by one.
+type Transition struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Computed reports whether the field is derived from other fields at read time
+func (f *Field) Computed() bool {
+	return f.Expression != ""
+}
+
+// Transition finds the transition of a state field from one value to another
+func (f *Field) Transition(from, to string) (*Transition, bool) {
+	for i := range f.Transitions {
+		if f.Transitions[i].From == from && f.Transitions[i].To == to {
+			return &f.Transitions[i], true
+		}
+	}
+	return nil, false
+}
+
+// HasInitialStates reports whether the state machine restricts the states of new records
+func (f *Field) HasInitialStates() bool {
+	for _, t := range f.Transitions {
+		if t.From == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationRules are stored as JSON in resource_fields.validation and checked before any write.
+// Pattern is matched against the whole value.
+type ValidationRules struct {
+	Required  bool     `json:"required,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	MaxLength *int     `json:"max_length,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+}
+
+// Field looks up a field by name
+func (r *Resource) Field(name string) (*Field, bool) {
+	for i := range r.Fields {
+		if r.Fields[i].Name == name {
+			return &r.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+type CreateResourceRequest struct {
+	Name        string         `json:"name"`
+	DisplayName string         `json:"display_name"`
+	SoftDelete  bool           `json:"soft_delete"`
+	Fields      []FieldRequest `json:"fields"`
+}
+
+// ToggleRequest switches a per-resource setting on or off
+type ToggleRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// TransitionsRequest replaces the state machine of a field; an empty list removes it
+type TransitionsRequest struct {
+	Transitions []TransitionRequest `json:"transitions"`
+}
+
+type TransitionRequest struct {
+	Name string `json:"name"` // defaults to the target state
+	From string `json:"from"` // empty for an initial state
+	To   string `json:"to"`
+}
+
+// ApproverRequest sets the role that approves changes to sensitive fields; 0 turns approval off
+type ApproverRequest struct {
+	RoleID int `json:"role_id"`
+}
+
+type MinGroupSizeRequest struct {
+	MinGroupSize int `json:"min_group_size"`
+}
+
+type FieldRequest struct {
+	Name        string          `json:"field_name"`
+	DataType    string          `json:"data_type"`
+	IsSensitive bool            `json:"is_sensitive"`
+	Validation  ValidationRules `json:"validation"`
+	Reference   string          `json:"ref_resource"` // required for data_type "reference"
+	Expression  string          `json:"expression"`   // makes the field computed (read-only)
+	Immutable   bool            `json:"immutable"`
+}
@@ -0,0 +1,409 @@
+package registry
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"server/internal/config"
+
+	"github.com/lib/pq"
+)
+
+type Repository struct{}
+
+// Postgres column types for resource_fields.data_type. Parsing and filtering of each type
+// live in the resource package (fieldTypes).
+var columnTypes = map[string]string{
+	"text":      "TEXT",
+	"number":    "INTEGER", // original name of integer, kept for existing fields
+	"integer":   "INTEGER",
+	"decimal":   "NUMERIC(18, 4)",
+	"boolean":   "BOOLEAN",
+	"date":      "DATE",
+	"datetime":  "TIMESTAMPTZ",
+	"enum":      "TEXT", // allowed values are validation.enum
+	"email":     "TEXT",
+	"json":      "JSONB",
+	"reference": "INTEGER", // id of a record of Field.Reference
+}
+
+// SearchConfig is the text search configuration of the search indexes. "simple" neither stems
+// nor drops stop words, which suits names and codes better than a language configuration.
+const SearchConfig = "simple"
+
+// SearchVector is the indexed search expression of a text column. Queries must use the exact
+// same expression for Postgres to use the index.
+func SearchVector(column string) string {
+	return fmt.Sprintf("to_tsvector('%s', COALESCE(%s, ''))", SearchConfig, pq.QuoteIdentifier(column))
+}
+
+// IsText reports whether fields of a data type are stored as TEXT (unknown types are)
+func IsText(dataType string) bool {
+	colType, ok := columnTypes[dataType]
+	return !ok || colType == "TEXT"
+}
+
+func (r *Repository) LoadAll() ([]Resource, error) {
+	rows, err := config.DB.Query(`
+		SELECT res.id, res.name, COALESCE(res.display_name, res.name), COALESCE(res.is_system, false),
+		       COALESCE(res.soft_delete, false), COALESCE(res.require_if_match, false), COALESCE(res.approver_role_id, 0),
+		       rf.id, rf.field_name, COALESCE(rf.data_type, 'text'), COALESCE(rf.is_sensitive, false),
+		       COALESCE(rf.validation, '{}'), COALESCE(rf.min_group_size, 0),
+		       COALESCE(rf.ref_resource, ''), COALESCE(rf.expression, ''), COALESCE(rf.immutable, false)
+		FROM resources res
+		LEFT JOIN resource_fields rf ON rf.resource_id = res.id
+		ORDER BY res.id, rf.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resources := []Resource{}
+	for rows.Next() {
+		var res Resource
+		var fieldID sql.NullInt64
+		var fieldName, dataType sql.NullString
+		var sensitive sql.NullBool
+		var validation []byte
+		var minGroupSize sql.NullInt64
+		var reference, expression sql.NullString
+		var immutable sql.NullBool
+		if err := rows.Scan(&res.ID, &res.Name, &res.DisplayName, &res.IsSystem, &res.SoftDelete, &res.RequireIfMatch, &res.ApproverRoleID, &fieldID, &fieldName, &dataType, &sensitive, &validation, &minGroupSize, &reference, &expression, &immutable); err != nil {
+			return nil, err
+		}
+
+		if len(resources) == 0 || resources[len(resources)-1].ID != res.ID {
+			res.Fields = []Field{}
+			resources = append(resources, res)
+		}
+		if fieldID.Valid {
+			field := Field{
+				ID:           int(fieldID.Int64),
+				Name:         fieldName.String,
+				DataType:     dataType.String,
+				IsSensitive:  sensitive.Bool,
+				MinGroupSize: int(minGroupSize.Int64),
+				Reference:    reference.String,
+				Expression:   expression.String,
+				Immutable:    immutable.Bool,
+			}
+			if err := json.Unmarshal(validation, &field.Validation); err != nil {
+				return nil, fmt.Errorf("invalid validation rules on %s.%s: %w", res.Name, field.Name, err)
+			}
+			last := &resources[len(resources)-1]
+			last.Fields = append(last.Fields, field)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	transitions, err := r.loadTransitions()
+	if err != nil {
+		return nil, err
+	}
+	for i := range resources {
+		for j := range resources[i].Fields {
+			resources[i].Fields[j].Transitions = transitions[resources[i].Fields[j].ID]
+		}
+	}
+
+	columns, err := r.loadColumns()
+	if err != nil {
+		return nil, err
+	}
+	for i := range resources {
+		resources[i].Columns = columns[resources[i].Name]
+		if resources[i].Columns == nil {
+			resources[i].Columns = map[string]bool{}
+		}
+	}
+	return resources, nil
+}
+
+// loadTransitions reads the state machines, keyed by field id
+func (r *Repository) loadTransitions() (map[int][]Transition, error) {
+	rows, err := config.DB.Query(`SELECT resource_field_id, id, name, from_state, to_state FROM field_transitions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := map[int][]Transition{}
+	for rows.Next() {
+		var fieldID int
+		var t Transition
+		if err := rows.Scan(&fieldID, &t.ID, &t.Name, &t.From, &t.To); err != nil {
+			return nil, err
+		}
+		transitions[fieldID] = append(transitions[fieldID], t)
+	}
+	return transitions, rows.Err()
+}
+
+// loadColumns reads table -> column names from information_schema for the current schema
+func (r *Repository) loadColumns() (map[string]map[string]bool, error) {
+	rows, err := config.DB.Query(`
+		SELECT table_name, column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema()
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]map[string]bool{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		if columns[table] == nil {
+			columns[table] = map[string]bool{}
+		}
+		columns[table][column] = true
+	}
+	return columns, rows.Err()
+}
+
+// CreateResource inserts the resource and its fields and grants the Admin role full access
+func (r *Repository) CreateResource(req CreateResourceRequest) (int, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(
+		"INSERT INTO resources (name, display_name, soft_delete) VALUES ($1, $2, $3) RETURNING id",
+		req.Name, req.DisplayName, req.SoftDelete,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO role_resource_permissions (role_id, resource_id, can_view, can_create, can_update, can_delete, can_restore, can_purge, can_export,
+		 can_list_attachments, can_download_attachments, can_upload_attachments, can_delete_attachments)
+		 VALUES (1, $1, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE)
+		 ON CONFLICT (role_id, resource_id) DO NOTHING`,
+		id,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, f := range req.Fields {
+		if err := insertField(tx, id, f); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+func (r *Repository) AddField(resourceID int, f FieldRequest) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertField(tx, resourceID, f); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertField(tx *sql.Tx, resourceID int, f FieldRequest) error {
+	validation, err := json.Marshal(f.Validation)
+	if err != nil {
+		return err
+	}
+
+	var fieldID int
+	err = tx.QueryRow(
+		`INSERT INTO resource_fields (resource_id, field_name, data_type, is_sensitive, validation, ref_resource, expression, immutable)
+		 VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8) RETURNING id`,
+		resourceID, f.Name, f.DataType, f.IsSensitive, validation, f.Reference, f.Expression, f.Immutable,
+	).Scan(&fieldID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO role_field_permissions (role_id, resource_field_id, can_view, can_edit, can_set_on_create, can_update)
+		 VALUES (1, $1, TRUE, TRUE, TRUE, TRUE)
+		 ON CONFLICT (role_id, resource_field_id) DO NOTHING`,
+		fieldID,
+	)
+	return err
+}
+
+func (r *Repository) SetSoftDelete(resourceID int, enabled bool) error {
+	_, err := config.DB.Exec("UPDATE resources SET soft_delete = $1 WHERE id = $2", enabled, resourceID)
+	return err
+}
+
+func (r *Repository) SetRequireIfMatch(resourceID int, enabled bool) error {
+	_, err := config.DB.Exec("UPDATE resources SET require_if_match = $1 WHERE id = $2", enabled, resourceID)
+	return err
+}
+
+// SetApprover sets the approver role of a resource; 0 clears it
+func (r *Repository) SetApprover(resourceID, roleID int) error {
+	_, err := config.DB.Exec("UPDATE resources SET approver_role_id = NULLIF($1, 0) WHERE id = $2", roleID, resourceID)
+	return err
+}
+
+func (r *Repository) RoleExists(roleID int) (bool, error) {
+	var exists bool
+	err := config.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE id = $1)", roleID).Scan(&exists)
+	return exists, err
+}
+
+// CanViewSensitive reports whether a role may read a resource and view all of its sensitive fields
+func (r *Repository) CanViewSensitive(roleID, resourceID int) (bool, error) {
+	if roleID == 1 {
+		return true, nil
+	}
+	var ok bool
+	err := config.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM role_resource_permissions
+			WHERE role_id = $1 AND resource_id = $2 AND can_view
+		) AND NOT EXISTS (
+			SELECT 1 FROM resource_fields rf
+			LEFT JOIN role_field_permissions rfp ON rfp.resource_field_id = rf.id AND rfp.role_id = $1
+			WHERE rf.resource_id = $2 AND rf.is_sensitive AND NOT COALESCE(rfp.can_view, false)
+		)
+	`, roleID, resourceID).Scan(&ok)
+	return ok, err
+}
+
+func (r *Repository) UpdateValidation(fieldID int, rules ValidationRules) error {
+	validation, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	_, err = config.DB.Exec("UPDATE resource_fields SET validation = $1 WHERE id = $2", validation, fieldID)
+	return err
+}
+
+func (r *Repository) SetMinGroupSize(fieldID, size int) error {
+	_, err := config.DB.Exec("UPDATE resource_fields SET min_group_size = $1 WHERE id = $2", size, fieldID)
+	return err
+}
+
+// SetTransitions replaces the transitions of a field. Transitions that are kept (same from and to)
+// keep their id and with it the roles granted them.
+func (r *Repository) SetTransitions(fieldID int, transitions []TransitionRequest) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	keep := []int{}
+	for _, t := range transitions {
+		var id int
+		err := tx.QueryRow(
+			`INSERT INTO field_transitions (resource_field_id, name, from_state, to_state) VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (resource_field_id, from_state, to_state) DO UPDATE SET name = EXCLUDED.name
+			 RETURNING id`,
+			fieldID, t.Name, t.From, t.To,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		keep = append(keep, id)
+	}
+	_, err = tx.Exec(`DELETE FROM field_transitions WHERE resource_field_id = $1 AND NOT (id = ANY($2))`, fieldID, pq.Array(keep))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) SetImmutable(fieldID int, immutable bool) error {
+	_, err := config.DB.Exec("UPDATE resource_fields SET immutable = $1 WHERE id = $2", immutable, fieldID)
+	return err
+}
+
+// TableExists reports whether any table with this name exists in the current schema
+func (r *Repository) TableExists(name string) (bool, error) {
+	var exists bool
+	err := config.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1)",
+		name,
+	).Scan(&exists)
+	return exists, err
+}
+
+// EnsureTable creates the backing table for a resource and adds any missing columns.
+// Existing columns are never dropped or retyped.
+func (r *Repository) EnsureTable(res *Resource) error {
+	table := pq.QuoteIdentifier(res.Name)
+
+	_, err := config.DB.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id SERIAL PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`, table))
+	if err != nil {
+		return err
+	}
+
+	// Row version for optimistic concurrency; tables created before it start at 1
+	_, err = config.DB.Exec(fmt.Sprintf(
+		"ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s INTEGER NOT NULL DEFAULT 1", table, pq.QuoteIdentifier(VersionColumn),
+	))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range res.Fields {
+		if f.Computed() {
+			continue // evaluated at read time, nothing is stored
+		}
+		colType, ok := columnTypes[f.DataType]
+		if !ok {
+			colType = "TEXT"
+		}
+		if f.Reference != "" {
+			// Only applies when the column is created; the target's rows cannot be deleted while referenced
+			colType += fmt.Sprintf(" REFERENCES %s (%s)", pq.QuoteIdentifier(f.Reference), pq.QuoteIdentifier("id"))
+		}
+		_, err := config.DB.Exec(fmt.Sprintf(
+			"ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+			table, pq.QuoteIdentifier(f.Name), colType,
+		))
+		if err != nil {
+			return err
+		}
+
+		// Full-text index for global search, one per field so each role searches only what it can view
+		if IsText(f.DataType) {
+			_, err := config.DB.Exec(fmt.Sprintf(
+				"CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (%s)",
+				pq.QuoteIdentifier(res.Name+"_"+f.Name+"_search_idx"), table, SearchVector(f.Name),
+			))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Once added, deleted_at is kept even if soft delete is turned off so trashed rows stay hidden
+	if res.SoftDelete {
+		_, err := config.DB.Exec(fmt.Sprintf(
+			"ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s TIMESTAMP", table, pq.QuoteIdentifier(DeletedAtColumn),
+		))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
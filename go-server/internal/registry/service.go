@@ -0,0 +1,499 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"server/internal/expr"
+	"sync"
+	"time"
+)
+
+const cacheTTL = 30 * time.Second
+
+var identifierPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// Table names owned by the application itself; they can never be registered as resources
+var reservedNames = map[string]bool{
+	"roles": true, "users": true, "resources": true, "resource_fields": true,
+	"role_resource_permissions": true, "role_field_permissions": true, "permissions": true,
+	"role_row_filters": true, "record_history": true, "field_transitions": true,
+	"role_transition_permissions": true, "pending_changes": true, "attachments": true,
+}
+
+// Columns every resource table gets automatically (deleted_at once soft delete is enabled)
+var reservedFields = map[string]bool{"id": true, "created_at": true, DeletedAtColumn: true, VersionColumn: true}
+
+// Data types a computed field may declare for its result
+var computedTypes = map[string]bool{"text": true, "number": true, "integer": true, "decimal": true, "boolean": true, "date": true}
+
+var (
+	ErrInvalidName   = errors.New("names must start with a lowercase letter and contain only lowercase letters, digits and underscores")
+	ErrReservedName  = errors.New("name is reserved")
+	ErrExists        = errors.New("already exists")
+	ErrNotFound      = errors.New("resource not found")
+	ErrFieldNotFound = errors.New("field not found")
+	ErrInvalidRules  = errors.New("invalid validation rules")
+	ErrInvalidType   = errors.New("unsupported data type")
+	ErrInvalidSize   = errors.New("min_group_size must be between 0 and 1000")
+	ErrInvalidRef    = errors.New("reference fields need ref_resource naming a registered, non-system resource")
+	ErrInvalidExpr   = errors.New("invalid expression")
+	ErrInvalidState  = errors.New("invalid transition")
+	ErrInvalidRole   = errors.New("role not found")
+	ErrApproverRole  = errors.New("approver role must be able to read the resource and view its sensitive fields")
+)
+
+type Service struct {
+	Repo *Repository
+
+	mu        sync.RWMutex
+	resources map[string]*Resource
+	loadedAt  time.Time
+}
+
+// Get returns a registered resource by name, reloading the cache when it is stale
+func (s *Service) Get(name string) (*Resource, error) {
+	if err := s.ensureFresh(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res, ok := s.resources[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return res, nil
+}
+
+func (s *Service) List() ([]*Resource, error) {
+	if err := s.ensureFresh(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]*Resource, 0, len(s.resources))
+	for _, res := range s.resources {
+		list = append(list, res)
+	}
+	return list, nil
+}
+
+// Invalidate drops the cache so the next lookup reloads from the database
+func (s *Service) Invalidate() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+func (s *Service) ensureFresh() error {
+	s.mu.RLock()
+	fresh := s.resources != nil && time.Since(s.loadedAt) < cacheTTL
+	s.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	list, err := s.Repo.LoadAll()
+	if err != nil {
+		return err
+	}
+
+	resources := make(map[string]*Resource, len(list))
+	for i := range list {
+		resources[list[i].Name] = &list[i]
+	}
+
+	s.mu.Lock()
+	s.resources = resources
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// SyncAll creates or alters the backing table of every non-system resource
+func (s *Service) SyncAll() error {
+	s.Invalidate()
+	list, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for _, res := range list {
+		if res.IsSystem {
+			continue
+		}
+		if err := s.Repo.EnsureTable(res); err != nil {
+			log.Printf("Error syncing table for resource %s: %v", res.Name, err)
+		}
+	}
+
+	// Pick up the columns that were just created
+	s.Invalidate()
+	return nil
+}
+
+func (s *Service) Register(req CreateResourceRequest) (*Resource, error) {
+	if !identifierPattern.MatchString(req.Name) {
+		return nil, ErrInvalidName
+	}
+	if reservedNames[req.Name] {
+		return nil, fmt.Errorf("%q: %w", req.Name, ErrReservedName)
+	}
+	if _, err := s.Get(req.Name); err == nil {
+		return nil, fmt.Errorf("resource %q %w", req.Name, ErrExists)
+	}
+	// Refuse to adopt a table that is not managed through the registry
+	if exists, err := s.Repo.TableExists(req.Name); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("table %q %w", req.Name, ErrExists)
+	}
+
+	stored := map[string]bool{}
+	for _, f := range req.Fields {
+		if f.Expression == "" {
+			stored[f.Name] = true
+		}
+	}
+	seen := map[string]bool{}
+	for i := range req.Fields {
+		if err := validateField(&req.Fields[i]); err != nil {
+			return nil, err
+		}
+		if err := s.checkReference(req.Fields[i], req.Name); err != nil {
+			return nil, err
+		}
+		if err := checkExpression(req.Fields[i], stored); err != nil {
+			return nil, err
+		}
+		if seen[req.Fields[i].Name] {
+			return nil, fmt.Errorf("field %q %w", req.Fields[i].Name, ErrExists)
+		}
+		seen[req.Fields[i].Name] = true
+	}
+	if req.DisplayName == "" {
+		req.DisplayName = req.Name
+	}
+
+	if _, err := s.Repo.CreateResource(req); err != nil {
+		return nil, err
+	}
+	return s.sync(req.Name)
+}
+
+func (s *Service) AddField(resourceName string, req FieldRequest) (*Resource, error) {
+	res, err := s.Get(resourceName)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateField(&req); err != nil {
+		return nil, err
+	}
+	if err := s.checkReference(req, res.Name); err != nil {
+		return nil, err
+	}
+	stored := map[string]bool{}
+	for _, f := range res.Fields {
+		if !f.Computed() {
+			stored[f.Name] = true
+		}
+	}
+	if err := checkExpression(req, stored); err != nil {
+		return nil, err
+	}
+	if _, ok := res.Field(req.Name); ok {
+		return nil, fmt.Errorf("field %q %w", req.Name, ErrExists)
+	}
+
+	if err := s.Repo.AddField(res.ID, req); err != nil {
+		return nil, err
+	}
+	return s.sync(resourceName)
+}
+
+// sync reloads a resource after a metadata change and applies it to its table
+func (s *Service) sync(name string) (*Resource, error) {
+	s.Invalidate()
+	res, err := s.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsSystem {
+		return res, nil
+	}
+	if err := s.Repo.EnsureTable(res); err != nil {
+		return nil, err
+	}
+
+	// Reload again so the cached column list reflects the altered table
+	s.Invalidate()
+	return s.Get(name)
+}
+
+// SetSoftDelete turns soft delete on or off for a resource, adding deleted_at when needed
+func (s *Service) SetSoftDelete(resourceName string, enabled bool) (*Resource, error) {
+	res, err := s.Get(resourceName)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsSystem {
+		return nil, fmt.Errorf("%q: %w", resourceName, ErrReservedName)
+	}
+
+	if err := s.Repo.SetSoftDelete(res.ID, enabled); err != nil {
+		return nil, err
+	}
+	return s.sync(resourceName)
+}
+
+// SetRequireIfMatch makes If-Match mandatory (or optional again) for writes to a resource
+func (s *Service) SetRequireIfMatch(resourceName string, enabled bool) (*Resource, error) {
+	res, err := s.Get(resourceName)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsSystem {
+		return nil, fmt.Errorf("%q: %w", resourceName, ErrReservedName)
+	}
+
+	if err := s.Repo.SetRequireIfMatch(res.ID, enabled); err != nil {
+		return nil, err
+	}
+	s.Invalidate()
+	return s.Get(resourceName)
+}
+
+// SetApprover turns on four-eyes approval for the sensitive fields of a resource: their changes
+// are held until a user of the role (other than the requester) approves them. 0 turns it off.
+func (s *Service) SetApprover(resourceName string, roleID int) (*Resource, error) {
+	res, err := s.Get(resourceName)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsSystem {
+		return nil, fmt.Errorf("%q: %w", resourceName, ErrReservedName)
+	}
+	if roleID != 0 {
+		exists, err := s.Repo.RoleExists(roleID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: %d", ErrInvalidRole, roleID)
+		}
+		// Approvers review old and new values, so they must be allowed to see them
+		canReview, err := s.Repo.CanViewSensitive(roleID, res.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !canReview {
+			return nil, fmt.Errorf("%w: role %d", ErrApproverRole, roleID)
+		}
+	}
+
+	if err := s.Repo.SetApprover(res.ID, roleID); err != nil {
+		return nil, err
+	}
+	s.Invalidate()
+	return s.Get(resourceName)
+}
+
+func (s *Service) SetValidation(resourceName, fieldName string, rules ValidationRules) (*Resource, error) {
+	res, err := s.Get(resourceName)
+	if err != nil {
+		return nil, err
+	}
+	field, ok := res.Field(fieldName)
+	if !ok {
+		return nil, ErrFieldNotFound
+	}
+	if err := checkRules(fieldName, rules); err != nil {
+		return nil, err
+	}
+	if field.Computed() && hasRules(rules) {
+		return nil, fmt.Errorf("field %q: %w: computed fields are not written", fieldName, ErrInvalidRules)
+	}
+
+	if err := s.Repo.UpdateValidation(field.ID, rules); err != nil {
+		return nil, err
+	}
+	s.Invalidate()
+	return s.Get(resourceName)
+}
+
+// SetMinGroupSize sets the smallest group a role with aggregate-only access to a field may see
+func (s *Service) SetMinGroupSize(resourceName, fieldName string, size int) (*Resource, error) {
+	if size < 0 || size > 1000 {
+		return nil, ErrInvalidSize
+	}
+	res, err := s.Get(resourceName)
+	if err != nil {
+		return nil, err
+	}
+	field, ok := res.Field(fieldName)
+	if !ok {
+		return nil, ErrFieldNotFound
+	}
+
+	if err := s.Repo.SetMinGroupSize(field.ID, size); err != nil {
+		return nil, err
+	}
+	s.Invalidate()
+	return s.Get(resourceName)
+}
+
+// SetImmutable makes a field write-once: it can be set when a record is created but not changed
+func (s *Service) SetImmutable(resourceName, fieldName string, immutable bool) (*Resource, error) {
+	res, err := s.Get(resourceName)
+	if err != nil {
+		return nil, err
+	}
+	field, ok := res.Field(fieldName)
+	if !ok {
+		return nil, ErrFieldNotFound
+	}
+	if field.Computed() && immutable {
+		return nil, fmt.Errorf("field %q: %w: computed fields are not written", fieldName, ErrInvalidRules)
+	}
+
+	if err := s.Repo.SetImmutable(field.ID, immutable); err != nil {
+		return nil, err
+	}
+	s.Invalidate()
+	return s.Get(resourceName)
+}
+
+// SetTransitions replaces the state machine of a text or enum field. States must be allowed
+// values of an enum field; a transition from "" names a state new records may start in.
+func (s *Service) SetTransitions(resourceName, fieldName string, transitions []TransitionRequest) (*Resource, error) {
+	res, err := s.Get(resourceName)
+	if err != nil {
+		return nil, err
+	}
+	field, ok := res.Field(fieldName)
+	if !ok {
+		return nil, ErrFieldNotFound
+	}
+	if field.Computed() || (field.DataType != "text" && field.DataType != "enum") {
+		return nil, fmt.Errorf("field %q: %w: only text and enum fields have states", fieldName, ErrInvalidState)
+	}
+
+	allowed := map[string]bool{}
+	for _, v := range field.Validation.Enum {
+		allowed[v] = true
+	}
+	seen := map[[2]string]bool{}
+	for i := range transitions {
+		t := &transitions[i]
+		if t.To == "" || t.From == t.To {
+			return nil, fmt.Errorf("field %q: %w from %q to %q", fieldName, ErrInvalidState, t.From, t.To)
+		}
+		for _, state := range []string{t.From, t.To} {
+			if state != "" && len(allowed) > 0 && !allowed[state] {
+				return nil, fmt.Errorf("field %q: %w: %q is not an allowed value", fieldName, ErrInvalidState, state)
+			}
+		}
+		if seen[[2]string{t.From, t.To}] {
+			return nil, fmt.Errorf("field %q: %w: duplicate transition from %q to %q", fieldName, ErrInvalidState, t.From, t.To)
+		}
+		seen[[2]string{t.From, t.To}] = true
+		if t.Name == "" {
+			t.Name = t.To
+		}
+	}
+
+	if err := s.Repo.SetTransitions(field.ID, transitions); err != nil {
+		return nil, err
+	}
+	s.Invalidate()
+	return s.Get(resourceName)
+}
+
+// checkRules rejects rule sets that could never be satisfied or cannot be evaluated
+func checkRules(field string, rules ValidationRules) error {
+	if rules.Min != nil && rules.Max != nil && *rules.Min > *rules.Max {
+		return fmt.Errorf("field %q: %w: min is greater than max", field, ErrInvalidRules)
+	}
+	if rules.MaxLength != nil && *rules.MaxLength < 1 {
+		return fmt.Errorf("field %q: %w: max_length must be positive", field, ErrInvalidRules)
+	}
+	if rules.Pattern != "" {
+		if _, err := regexp.Compile(rules.Pattern); err != nil {
+			return fmt.Errorf("field %q: %w: %v", field, ErrInvalidRules, err)
+		}
+	}
+	return nil
+}
+
+// checkReference makes sure a reference field points at a resource the data API serves;
+// self is the resource the field belongs to, which may reference itself
+func (s *Service) checkReference(f FieldRequest, self string) error {
+	if f.DataType != "reference" {
+		if f.Reference != "" {
+			return fmt.Errorf("field %q: %w", f.Name, ErrInvalidRef)
+		}
+		return nil
+	}
+	if f.Reference == self {
+		return nil
+	}
+	target, err := s.Get(f.Reference)
+	if errors.Is(err, ErrNotFound) || (err == nil && target.IsSystem) {
+		return fmt.Errorf("field %q: %w", f.Name, ErrInvalidRef)
+	}
+	return err
+}
+
+// checkExpression parses the expression of a computed field and makes sure it only reads stored
+// fields of the same resource, or its id and created_at. Computed fields cannot read each other.
+func checkExpression(f FieldRequest, stored map[string]bool) error {
+	if f.Expression == "" {
+		return nil
+	}
+	e, err := expr.ParseExpression(f.Expression)
+	if err != nil {
+		return fmt.Errorf("field %q: %w: %v", f.Name, ErrInvalidExpr, err)
+	}
+	for _, name := range e.Fields() {
+		if !stored[name] && name != "id" && name != "created_at" {
+			return fmt.Errorf("field %q: %w: %q is not a stored field of the resource", f.Name, ErrInvalidExpr, name)
+		}
+	}
+	return nil
+}
+
+// hasRules reports whether any validation rule is set
+func hasRules(rules ValidationRules) bool {
+	return rules.Required || rules.Min != nil || rules.Max != nil || rules.MaxLength != nil || rules.Pattern != "" || len(rules.Enum) > 0
+}
+
+func validateField(f *FieldRequest) error {
+	if !identifierPattern.MatchString(f.Name) {
+		return fmt.Errorf("field %q: %w", f.Name, ErrInvalidName)
+	}
+	if reservedFields[f.Name] {
+		return fmt.Errorf("field %q: %w", f.Name, ErrReservedName)
+	}
+	if f.DataType == "" {
+		f.DataType = "text"
+	}
+	if _, ok := columnTypes[f.DataType]; !ok {
+		return fmt.Errorf("field %q: %w %q", f.Name, ErrInvalidType, f.DataType)
+	}
+	if f.Expression != "" {
+		if !computedTypes[f.DataType] {
+			return fmt.Errorf("field %q: %w %q for a computed field", f.Name, ErrInvalidType, f.DataType)
+		}
+		if hasRules(f.Validation) || f.Immutable {
+			return fmt.Errorf("field %q: %w: computed fields are not written", f.Name, ErrInvalidRules)
+		}
+		return nil
+	}
+	if f.DataType == "enum" && len(f.Validation.Enum) == 0 {
+		return fmt.Errorf("field %q: %w: enum fields need validation.enum", f.Name, ErrInvalidRules)
+	}
+	return checkRules(f.Name, f.Validation)
+}
@@ -0,0 +1,338 @@
+package resource
+
+// Aggregates for reports: GET /api/data/:resource/aggregate
+//
+//	?metrics=count,sum:amount,avg:amount&group_by=status&filter[order_date][gte]=2024-01-01
+//
+// Grouping and filtering need view permission on the field. A metric over a field needs view
+// permission or aggregate-only access (role_field_permissions.can_aggregate). Aggregate-only
+// fields allow count, sum and avg but not min or max, which return one record's value, over all
+// the rows the caller can read grouped by at most one field: comparing two filters (lte and lt)
+// or two groupings (department, then department and position) would single out the rows in
+// between. Groups smaller than the field's min_group_size are left out, and so are the next
+// smallest ones while fewer rows than that are left out, so the total less the groups shown does
+// not reveal a small group either.
+
+import (
+	"fmt"
+	"net/url"
+	"server/internal/config"
+	"server/pkg/utils"
+	"sort"
+	"strings"
+)
+
+const maxAggregateGroups = 1000
+
+// groupSizeColumn counts the rows of each group when small groups are left out; field names
+// cannot start with an underscore
+const groupSizeColumn = "_group_size"
+
+// Aggregate functions and the data types they accept
+var aggregateTypes = map[string]map[string]bool{
+	"sum": {"number": true, "integer": true, "decimal": true},
+	"avg": {"number": true, "integer": true, "decimal": true},
+	"min": {"number": true, "integer": true, "decimal": true, "date": true, "datetime": true},
+	"max": {"number": true, "integer": true, "decimal": true, "date": true, "datetime": true},
+}
+
+// Aggregate functions allowed on aggregate-only fields; min and max return a single record's value
+var anonymousAggregates = map[string]bool{"sum": true, "avg": true}
+
+// Metric is one aggregate; Field is empty for count
+type Metric struct {
+	Func  string
+	Field string
+}
+
+// Name is the key of the metric in each result group, e.g. "count" or "sum_amount"
+func (m Metric) Name() string {
+	if m.Field == "" {
+		return m.Func
+	}
+	return m.Func + "_" + m.Field
+}
+
+type AggregateQuery struct {
+	Metrics []Metric
+	GroupBy []string
+	Filters *ListQuery // filter[...] and sort, as in lists
+}
+
+// AggregateResult holds one row per group, ordered by the group fields unless sorted
+type AggregateResult struct {
+	Groups       []map[string]interface{} `json:"groups"`
+	MinGroupSize int                      `json:"min_group_size,omitempty"` // groups below it were left out
+	Truncated    bool                     `json:"truncated,omitempty"`      // more than maxAggregateGroups groups
+}
+
+// ParseAggregateQuery reads metrics, group_by and the list filters. Without metrics it counts rows.
+func ParseAggregateQuery(values url.Values) (*AggregateQuery, error) {
+	lq, err := ParseListQuery(values)
+	if err != nil {
+		return nil, err
+	}
+	q := &AggregateQuery{Filters: lq}
+
+	if m := values.Get("metrics"); m != "" {
+		for _, part := range strings.Split(m, ",") {
+			fn, field, _ := strings.Cut(strings.TrimSpace(part), ":")
+			switch {
+			case fn == "count" && field == "":
+			case aggregateTypes[fn] != nil && field != "":
+			default:
+				return nil, &QueryError{Message: fmt.Sprintf("invalid metric %q (use count, sum:field, avg:field, min:field or max:field)", part)}
+			}
+			q.Metrics = append(q.Metrics, Metric{Func: fn, Field: field})
+		}
+	} else {
+		q.Metrics = []Metric{{Func: "count"}}
+	}
+
+	if g := values.Get("group_by"); g != "" {
+		for _, part := range strings.Split(g, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				return nil, &QueryError{Message: "invalid group_by parameter"}
+			}
+			q.GroupBy = append(q.GroupBy, part)
+		}
+	}
+	return q, nil
+}
+
+// checkAnonymous rejects the queries that could isolate rows by difference when a metric covers
+// a field the caller cannot view: any filter, and grouping by more than one field
+func (q *AggregateQuery) checkAnonymous() error {
+	if len(q.Filters.Filters) > 0 {
+		return fmt.Errorf("%w: filters are not allowed when aggregating fields you cannot view", ErrPermissionDenied)
+	}
+	if len(q.GroupBy) > 1 {
+		return fmt.Errorf("%w: group by at most one field when aggregating fields you cannot view", ErrPermissionDenied)
+	}
+	return nil
+}
+
+// suppressGroups leaves out the groups with fewer than minSize rows (sizes[i] for groups[i]). If
+// that leaves out fewer than minSize rows in all, the next smallest groups go too, until enough
+// rows are left out or no group is left. The order of the remaining groups is kept.
+func suppressGroups(groups []map[string]interface{}, sizes []int64, minSize int) []map[string]interface{} {
+	hidden := make([]bool, len(groups))
+	var hiddenRows int64
+	for i, n := range sizes {
+		if n < int64(minSize) {
+			hidden[i] = true
+			hiddenRows += n
+		}
+	}
+	if hiddenRows > 0 && hiddenRows < int64(minSize) {
+		shown := []int{}
+		for i := range groups {
+			if !hidden[i] {
+				shown = append(shown, i)
+			}
+		}
+		sort.SliceStable(shown, func(a, b int) bool { return sizes[shown[a]] < sizes[shown[b]] })
+		for _, i := range shown {
+			if hiddenRows >= int64(minSize) {
+				break
+			}
+			hidden[i] = true
+			hiddenRows += sizes[i]
+		}
+	}
+
+	kept := []map[string]interface{}{}
+	for i, g := range groups {
+		if !hidden[i] {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
+
+// aggregateOnly returns the fields a role may aggregate but not view
+func (r *Repository) aggregateOnly(roleID int, resource string) (map[string]bool, error) {
+	fields := map[string]bool{}
+	if roleID == 1 {
+		return fields, nil
+	}
+	rows, err := config.DB.Query(`
+		SELECT rf.field_name
+		FROM role_field_permissions rfp
+		JOIN resource_fields rf ON rfp.resource_field_id = rf.id
+		JOIN resources res ON rf.resource_id = res.id
+		WHERE rfp.role_id = $1 AND res.name = $2 AND rfp.can_aggregate AND NOT COALESCE(rfp.can_view, false)
+	`, roleID, resource)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var field string
+		if err := rows.Scan(&field); err != nil {
+			return nil, err
+		}
+		fields[field] = true
+	}
+	return fields, rows.Err()
+}
+
+// Aggregate computes the metrics per group over the live rows the caller can read
+func (r *Repository) Aggregate(resource string, user *utils.Claims, q *AggregateQuery) (*AggregateResult, error) {
+	viewFields, _, _, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+	aggOnly, err := r.aggregateOnly(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := r.schemaFor(resource)
+	if err != nil {
+		return nil, err
+	}
+	types := schema.types
+
+	// Filters follow the list rules; sorting is checked below against the result columns
+	lq := *q.Filters
+	lq.Sort, lq.Fields = nil, nil
+	if err := lq.validate(types, viewFields); err != nil {
+		return nil, err
+	}
+
+	result := &AggregateResult{Groups: []map[string]interface{}{}}
+	outputs := map[string]bool{}
+	groupCols := make([]string, len(q.GroupBy))
+	for i, f := range q.GroupBy {
+		if _, ok := types[f]; !ok {
+			return nil, &QueryError{Message: fmt.Sprintf("unknown field %q", f)}
+		}
+		if !canView(viewFields, f) {
+			return nil, fmt.Errorf("%w: cannot group by field %q", ErrPermissionDenied, f)
+		}
+		if outputs[f] {
+			return nil, &QueryError{Message: fmt.Sprintf("duplicate group_by field %q", f)}
+		}
+		outputs[f] = true
+		groupCols[i] = quoteIdent(f)
+	}
+
+	cols := append([]string{}, groupCols...)
+	anonymous := false
+	for _, m := range q.Metrics {
+		if outputs[m.Name()] {
+			return nil, &QueryError{Message: fmt.Sprintf("duplicate metric %q", m.Name())}
+		}
+		outputs[m.Name()] = true
+		if m.Field == "" {
+			cols = append(cols, `COUNT(*) AS "count"`)
+			continue
+		}
+
+		dataType, ok := types[m.Field]
+		if !ok {
+			return nil, &QueryError{Message: fmt.Sprintf("unknown field %q", m.Field)}
+		}
+		if !canView(viewFields, m.Field) && !aggOnly[m.Field] {
+			return nil, fmt.Errorf("%w: cannot aggregate field %q", ErrPermissionDenied, m.Field)
+		}
+		if !aggregateTypes[m.Func][dataType] {
+			return nil, &QueryError{Message: fmt.Sprintf("%s is not supported on %s field %q", m.Func, dataType, m.Field)}
+		}
+		if !canView(viewFields, m.Field) {
+			anonymous = true
+			if !anonymousAggregates[m.Func] {
+				return nil, fmt.Errorf("%w: only count, sum and avg are allowed on field %q", ErrPermissionDenied, m.Field)
+			}
+			if f, ok := schema.resource.Field(m.Field); ok && f.MinGroupSize > result.MinGroupSize {
+				result.MinGroupSize = f.MinGroupSize
+			}
+		}
+
+		cols = append(cols, fmt.Sprintf("%s(%s) AS %s", strings.ToUpper(m.Func), quoteIdent(m.Field), quoteIdent(m.Name())))
+	}
+	if anonymous {
+		if err := q.checkAnonymous(); err != nil {
+			return nil, err
+		}
+	}
+
+	order := []string{}
+	for _, s := range q.Filters.Sort {
+		if !outputs[s.Field] {
+			return nil, &QueryError{Message: fmt.Sprintf("sort: %q is neither a group_by field nor a metric", s.Field)}
+		}
+		dir := "ASC"
+		if s.Desc {
+			dir = "DESC"
+		}
+		order = append(order, quoteIdent(s.Field)+" "+dir+" NULLS LAST")
+	}
+	order = append(order, groupCols...)
+
+	where, args, err := lq.buildWhere(types, 1)
+	if err != nil {
+		return nil, err
+	}
+	scope, scopeArgs, err := r.rowScope(schema, user, ScopeRead, len(args)+1)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, scopeArgs...)
+
+	// Small groups are left out once every group is known, see suppressGroups
+	suppress := result.MinGroupSize > 0
+	if suppress {
+		cols = append(cols, "COUNT(*) AS "+quoteIdent(groupSizeColumn))
+	}
+
+	conds := []string{}
+	for _, c := range []string{schema.liveOnly(), where, scope} {
+		if c != "" {
+			conds = append(conds, c)
+		}
+	}
+
+	stmt := "SELECT " + strings.Join(cols, ", ") + " FROM " + schema.table
+	if len(conds) > 0 {
+		stmt += " WHERE " + strings.Join(conds, " AND ")
+	}
+	if len(groupCols) > 0 {
+		stmt += " GROUP BY " + strings.Join(groupCols, ", ")
+	}
+	if len(order) > 0 {
+		stmt += " ORDER BY " + strings.Join(order, ", ")
+	}
+	if !suppress {
+		stmt += fmt.Sprintf(" LIMIT %d", maxAggregateGroups+1)
+	}
+
+	rows, err := config.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	sizes := []int64{}
+	err = scanRows(rows, func(row map[string]interface{}) error {
+		if suppress {
+			n, _ := row[groupSizeColumn].(int64)
+			sizes = append(sizes, n)
+			delete(row, groupSizeColumn)
+		}
+		result.Groups = append(result.Groups, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Also applies without group_by: a total over too few rows yields no result
+	if suppress {
+		result.Groups = suppressGroups(result.Groups, sizes, result.MinGroupSize)
+	}
+	if len(result.Groups) > maxAggregateGroups {
+		result.Groups = result.Groups[:maxAggregateGroups]
+		result.Truncated = true
+	}
+	return result, nil
+}
@@ -0,0 +1,135 @@
+package resource
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/url"
+	"reflect"
+	"server/internal/dbtest"
+	"server/internal/registry"
+	"server/pkg/utils"
+	"testing"
+)
+
+// employeeRules scripts employees with a salary that role 2 can only aggregate
+func employeeRules() []dbtest.Rule {
+	employees := registry.Resource{ID: 1, Name: "employees", Fields: []registry.Field{
+		{ID: 1, Name: "name", DataType: "text"},
+		{ID: 2, Name: "department", DataType: "text"},
+		{ID: 3, Name: "position", DataType: "text"},
+		{ID: 4, Name: "hired_on", DataType: "date"},
+		{ID: 5, Name: "salary", DataType: "decimal", IsSensitive: true, MinGroupSize: 5},
+	}}
+	return append(registryRules(employees),
+		dbtest.Rule{Match: "rfp.can_aggregate", Columns: []string{"field_name"}, Rows: [][]driver.Value{{"salary"}}},
+		dbtest.Rule{Match: "FROM role_field_permissions rfp", Columns: []string{"field_name", "can_view", "can_set_on_create", "can_update", "mask_mode"},
+			Rows: [][]driver.Value{
+				{"name", true, false, false, "omit"},
+				{"department", true, false, false, "omit"},
+				{"position", true, false, false, "omit"},
+				{"hired_on", true, false, false, "omit"},
+				{"salary", false, false, false, "omit"},
+			}},
+	)
+}
+
+// When a metric covers a field the caller can only aggregate, the queries whose difference
+// would isolate rows are refused: two filters that differ by the rows in between, and a grouping
+// that splits the groups of another
+func TestAggregateRefusesDifferencing(t *testing.T) {
+	tests := []struct {
+		query   string
+		refused bool
+	}{
+		{"metrics=avg:salary&filter[hired_on][lte]=2024-03-01", true},
+		{"metrics=avg:salary&filter[hired_on][lt]=2024-03-01", true},
+		{"metrics=count,sum:salary&group_by=department", false},
+		{"metrics=count,sum:salary&group_by=department,position", true},
+		{"metrics=avg:salary", false},
+		{"metrics=avg:salary&sort=-avg_salary&group_by=position", false},
+	}
+	for _, tt := range tests {
+		db := useDB(t, employeeRules()...)
+		values, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := ParseAggregateQuery(values)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		_, err = testRepository().Aggregate("employees", &utils.Claims{ID: 5, RoleID: 2}, q)
+		ran := db.Ran(`FROM "employees"`)
+		if tt.refused && (!errors.Is(err, ErrPermissionDenied) || ran) {
+			t.Errorf("%s: got %v (ran: %v), want ErrPermissionDenied", tt.query, err, ran)
+		}
+		if !tt.refused && (err != nil || !ran) {
+			t.Errorf("%s: %v", tt.query, err)
+		}
+	}
+}
+
+func TestSuppressGroups(t *testing.T) {
+	group := func(name string) map[string]interface{} { return map[string]interface{}{"department": name} }
+	names := func(groups []map[string]interface{}) []string {
+		out := []string{}
+		for _, g := range groups {
+			out = append(out, g["department"].(string))
+		}
+		return out
+	}
+	groups := []map[string]interface{}{group("a"), group("b"), group("c"), group("d")}
+
+	tests := []struct {
+		name  string
+		sizes []int64
+		want  []string
+	}{
+		{"all large enough", []int64{5, 6, 7, 8}, []string{"a", "b", "c", "d"}},
+		{"enough rows left out", []int64{5, 2, 3, 8}, []string{"a", "d"}},
+		// b alone is the total less the groups shown: the smallest other group goes too
+		{"one small group", []int64{6, 1, 9, 5}, []string{"a", "c"}},
+		{"ties keep order", []int64{5, 1, 5, 9}, []string{"c", "d"}},
+		{"too few rows overall", []int64{1, 2, 1, 0}, []string{}},
+		{"empty groups", []int64{0, 5, 5, 5}, []string{"b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		if got := names(suppressGroups(groups, tt.sizes, 5)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := suppressGroups(groups[:1], []int64{4}, 5); len(got) != 0 {
+		t.Errorf("total below the minimum: got %v", got)
+	}
+}
+
+func TestAggregateSuppressesSmallGroups(t *testing.T) {
+	groups := dbtest.Rule{Match: `FROM "employees"`, Columns: []string{"department", "sum_salary", "_group_size"},
+		Rows: [][]driver.Value{
+			{"Sales", []byte("400000"), int64(6)},
+			{"Legal", []byte("90000"), int64(1)},
+			{"IT", []byte("500000"), int64(7)},
+			{"HR", []byte("300000"), int64(5)},
+		}}
+	db := useDB(t, append(employeeRules(), groups)...)
+	q, err := ParseAggregateQuery(url.Values{"metrics": {"sum:salary"}, "group_by": {"department"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := testRepository().Aggregate("employees", &utils.Claims{ID: 5, RoleID: 2}, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.MinGroupSize != 5 || len(result.Groups) != 2 {
+		t.Fatalf("got %+v", result)
+	}
+	for _, g := range result.Groups {
+		if _, ok := g["_group_size"]; ok || g["department"] == "Legal" || g["department"] == "HR" {
+			t.Fatalf("got group %v", g)
+		}
+	}
+	if db.Ran("HAVING") || db.Ran("LIMIT") {
+		t.Fatal("groups left out before all were known")
+	}
+}
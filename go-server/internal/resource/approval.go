@@ -0,0 +1,380 @@
+package resource
+
+// Four-eyes approval (resources.approver_role_id). On a resource with an approver role, an update
+// of a sensitive field such as employees.salary is not applied but held in pending_changes; the
+// rest of the update goes through as usual. A user of the approver role (or an admin) other than
+// the requester approves or rejects it, provided they can read the record and view every field
+// the change sets. Pending changes are shown on a record to users who can view the fields they
+// change.
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"server/internal/config"
+	"server/internal/history"
+	"server/internal/registry"
+	"server/pkg/utils"
+	"strconv"
+	"time"
+)
+
+const (
+	ChangePending  = "pending"
+	ChangeApproved = "approved"
+	ChangeRejected = "rejected"
+)
+
+// PendingChange is an update of sensitive fields waiting for (or past) review
+type PendingChange struct {
+	ID          int                    `json:"id"`
+	RecordID    int                    `json:"record_id"`
+	Changes     map[string]interface{} `json:"changes"`  // requested values
+	Previous    map[string]interface{} `json:"previous"` // values when the change was requested
+	Status      string                 `json:"status"`
+	RequestedBy int                    `json:"requested_by"`
+	Requester   string                 `json:"requester"`
+	Reviewer    string                 `json:"reviewer,omitempty"`
+	ReviewedAt  *time.Time             `json:"reviewed_at,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+}
+
+// protected reports whether changes to a field wait for approval
+func (s *tableSchema) protected(field string) bool {
+	if s.resource.ApproverRoleID == 0 {
+		return false
+	}
+	f, ok := s.resource.Field(field)
+	return ok && f.IsSensitive
+}
+
+// protectedValues copies the values of an update that need approval, as sent
+func (s *tableSchema) protectedValues(data map[string]interface{}) map[string]interface{} {
+	held := map[string]interface{}{}
+	for k, v := range data {
+		if s.protected(k) {
+			held[k] = v
+		}
+	}
+	return held
+}
+
+// canApprove reports whether a user may review the pending changes of a resource
+func (s *tableSchema) canApprove(user *utils.Claims) bool {
+	return s.resource.ApproverRoleID != 0 && (user.RoleID == 1 || user.RoleID == s.resource.ApproverRoleID)
+}
+
+// sameValue compares a parsed request value with a stored one, numbers by value
+func sameValue(dataType string, parsed, stored interface{}) bool {
+	if !typeOf(dataType).text {
+		if a, ok := toNumber(parsed); ok {
+			if b, ok := toNumber(stored); ok {
+				return a == b
+			}
+		}
+	}
+	return history.Equal(parsed, stored)
+}
+
+// hold removes the fields of held from a validated update and stores those that would change
+// the record as a pending change. The record must be live and within the caller's update scope,
+// and match the precondition. It returns the pending change id (0 if nothing changes) and the
+// record's version.
+func (r *Repository) hold(tx *sql.Tx, w *writer, id string, data, held map[string]interface{}, pre *Precondition) (int, int, error) {
+	schema := w.schema
+	recordID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, 0, ErrNotFound
+	}
+	current, err := fetchRow(tx, schema, id, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	if current == nil {
+		return 0, 0, ErrNotFound
+	}
+
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE "id" = $1`, schema.table)
+	if live := schema.liveOnly(); live != "" {
+		query += " AND " + live
+	}
+	scope, args, err := r.scope(w, ScopeUpdate, 2)
+	if err != nil {
+		return 0, 0, err
+	}
+	if scope != "" {
+		query += " AND " + scope
+	}
+	var writable bool
+	if err := tx.QueryRow(query+")", append([]interface{}{id}, args...)...).Scan(&writable); err != nil {
+		return 0, 0, err
+	}
+	if !writable {
+		return 0, 0, ErrNotFound
+	}
+	version, versioned := VersionOf(current)
+	if versioned && pre != nil && !pre.Matches(version) {
+		return 0, 0, r.preconditionFailed(tx, w, id, version, current)
+	}
+
+	changes := map[string]interface{}{}
+	previous := map[string]interface{}{}
+	proposed := map[string]interface{}{}
+	for k, v := range current {
+		proposed[k] = v
+	}
+	for k, v := range held {
+		f, _ := schema.resource.Field(k)
+		if !sameValue(f.DataType, data[k], current[k]) {
+			changes[k] = v
+			previous[k] = current[k]
+			proposed[k] = data[k]
+		}
+		delete(data, k)
+	}
+	if len(changes) == 0 {
+		return 0, version, nil
+	}
+	// State changes need the requester's transition grants, not the approver's; see applyChange
+	if err := r.checkTransitions(tx, w, current, proposed); err != nil {
+		return 0, 0, err
+	}
+
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return 0, 0, err
+	}
+	previousJSON, err := json.Marshal(previous)
+	if err != nil {
+		return 0, 0, err
+	}
+	var changeID int
+	err = tx.QueryRow(`
+		INSERT INTO pending_changes (resource_id, record_id, changes, previous, requested_by, requester)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, schema.resource.ID, recordID, changesJSON, previousJSON, w.user.ID, w.user.Username).Scan(&changeID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return changeID, version, nil
+}
+
+const pendingColumns = `id, record_id, changes, previous, status, COALESCE(requested_by, 0), COALESCE(requester, ''),
+	COALESCE(reviewer, ''), reviewed_at, created_at`
+
+func scanPendingChange(scan func(dest ...interface{}) error) (*PendingChange, error) {
+	var c PendingChange
+	var changes, previous []byte
+	var reviewedAt sql.NullTime
+	err := scan(&c.ID, &c.RecordID, &changes, &previous, &c.Status, &c.RequestedBy, &c.Requester, &c.Reviewer, &reviewedAt, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if reviewedAt.Valid {
+		c.ReviewedAt = &reviewedAt.Time
+	}
+	for _, v := range []struct {
+		raw []byte
+		dst *map[string]interface{}
+	}{{changes, &c.Changes}, {previous, &c.Previous}} {
+		dec := json.NewDecoder(bytes.NewReader(v.raw))
+		dec.UseNumber()
+		if err := dec.Decode(v.dst); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// PendingChanges lists the pending changes of a resource, or of one record if recordID is not 0,
+// oldest first. Only records in the caller's read scope and fields they can view are included;
+// changes to none of them are left out.
+func (r *Repository) PendingChanges(resource string, recordID int, user *utils.Claims) ([]PendingChange, error) {
+	schema, err := r.schemaFor(resource)
+	if err != nil {
+		return nil, err
+	}
+	viewFields, _, _, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + pendingColumns + ` FROM pending_changes WHERE resource_id = $1 AND status = $2`
+	args := []interface{}{schema.resource.ID, ChangePending}
+	if recordID != 0 {
+		query += ` AND record_id = $3`
+		args = append(args, recordID)
+	}
+	scope, scopeArgs, err := r.rowScope(schema, user, ScopeRead, len(args)+1)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" {
+		query += fmt.Sprintf(` AND record_id IN (SELECT "id" FROM %s WHERE %s)`, schema.table, scope)
+		args = append(args, scopeArgs...)
+	}
+	rows, err := config.DB.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []PendingChange{}
+	for rows.Next() {
+		c, err := scanPendingChange(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		if c.visibleTo(viewFields) {
+			changes = append(changes, *c)
+		}
+	}
+	return changes, rows.Err()
+}
+
+// visibleTo removes the fields the caller cannot view and reports whether any are left
+func (c *PendingChange) visibleTo(viewFields map[string]bool) bool {
+	for k := range c.Changes {
+		if !canView(viewFields, k) {
+			delete(c.Changes, k)
+			delete(c.Previous, k)
+		}
+	}
+	return len(c.Changes) > 0
+}
+
+// checkReviewable reports ErrNotFound if the change's record exists outside the caller's read
+// scope or sets no field the caller can view, and ErrPermissionDenied if it sets some they cannot
+func (r *Repository) checkReviewable(tx *sql.Tx, w *writer, change *PendingChange, viewFields map[string]bool) error {
+	schema := w.schema
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE "id" = $1)`, schema.table)
+	scope, args, err := r.scope(w, ScopeRead, 2)
+	if err != nil {
+		return err
+	}
+	if scope != "" {
+		query += fmt.Sprintf(`, EXISTS (SELECT 1 FROM %s WHERE "id" = $1 AND %s)`, schema.table, scope)
+	} else {
+		query += ", true"
+	}
+	var exists, readable bool
+	if err := tx.QueryRow(query, append([]interface{}{change.RecordID}, args...)...).Scan(&exists, &readable); err != nil {
+		return err
+	}
+	// A record purged since the request reveals nothing; approving its change is stale
+	if exists && !readable {
+		return ErrNotFound
+	}
+
+	hidden := []string{}
+	for _, k := range sortedKeys(change.Changes) {
+		if !canView(viewFields, k) {
+			hidden = append(hidden, k)
+		}
+	}
+	if len(hidden) == len(change.Changes) {
+		return ErrNotFound // not listed to the caller either
+	}
+	if len(hidden) > 0 {
+		return fmt.Errorf("%w: cannot view field %q", ErrPermissionDenied, hidden[0])
+	}
+	return nil
+}
+
+// ReviewChange approves or rejects a pending change. Approving applies it, unless the record or
+// one of its fields has changed since the request; requesters cannot approve their own changes.
+// The caller must be able to read the record and view the changed fields.
+func (r *Repository) ReviewChange(resource string, changeID int, approve bool, user *utils.Claims) (*PendingChange, error) {
+	w, err := r.newWriter(resource, user)
+	if err != nil {
+		return nil, err
+	}
+	if !w.schema.canApprove(user) {
+		return nil, ErrPermissionDenied
+	}
+	viewFields, _, _, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var change *PendingChange
+	err = inTx(func(tx *sql.Tx) error {
+		row := tx.QueryRow(`SELECT `+pendingColumns+` FROM pending_changes WHERE id = $1 AND resource_id = $2 FOR UPDATE`,
+			changeID, w.schema.resource.ID)
+		change, err = scanPendingChange(row.Scan)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.checkReviewable(tx, w, change, viewFields); err != nil {
+			return err
+		}
+		if change.Status != ChangePending {
+			return ErrChangeReviewed
+		}
+
+		change.Status = ChangeRejected
+		if approve {
+			if change.RequestedBy == user.ID {
+				return fmt.Errorf("%w: changes cannot be approved by their requester", ErrPermissionDenied)
+			}
+			if err := r.applyChange(tx, w, change); err != nil {
+				return err
+			}
+			change.Status = ChangeApproved
+		}
+
+		var reviewedAt time.Time
+		err = tx.QueryRow(`
+			UPDATE pending_changes SET status = $1, reviewed_by = $2, reviewer = $3, reviewed_at = CURRENT_TIMESTAMP
+			WHERE id = $4
+			RETURNING reviewed_at
+		`, change.Status, user.ID, user.Username, change.ID).Scan(&reviewedAt)
+		change.Reviewer, change.ReviewedAt = user.Username, &reviewedAt
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	change.visibleTo(viewFields)
+	return change, nil
+}
+
+// applyChange writes an approved change. Field permissions and state transitions were checked
+// against the requester's grants when it was requested; the values are validated again against
+// the current rules.
+func (r *Repository) applyChange(tx *sql.Tx, w *writer, change *PendingChange) error {
+	schema := w.schema
+	id := strconv.Itoa(change.RecordID)
+	current, err := fetchRow(tx, schema, id, true)
+	if err != nil {
+		return err
+	}
+	if current == nil || (schema.hasTrash && current[registry.DeletedAtColumn] != nil) {
+		return ErrChangeStale
+	}
+	data := map[string]interface{}{}
+	for k, v := range change.Changes {
+		if !schema.writable[k] || !history.Equal(change.Previous[k], current[k]) {
+			return ErrChangeStale
+		}
+		data[k] = v
+	}
+
+	if err := validateWrite(schema.resource, data, false); err != nil {
+		return err
+	}
+	if err := r.checkReferences(tx, w, data); err != nil {
+		return err
+	}
+	query, args, err := buildUpdate(schema, id, data)
+	if err != nil {
+		return err
+	}
+	_, err = r.execRecorded(tx, w, history.ActionApprove, query, args, id, nil)
+	return err
+}
@@ -0,0 +1,139 @@
+package resource
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"server/internal/config"
+	"server/internal/dbtest"
+	"server/internal/registry"
+	"server/pkg/utils"
+	"testing"
+)
+
+func TestSameValue(t *testing.T) {
+	tests := []struct {
+		dataType       string
+		parsed, stored interface{}
+		want           bool
+	}{
+		{"number", int64(5), json.Number("5.00"), true},
+		{"decimal", "62000.50", json.Number("62000.5"), true},
+		{"decimal", "62000.50", json.Number("62000.51"), false},
+		{"text", "5", "5.0", false},
+		{"text", "Open", "Open", true},
+		{"number", nil, json.Number("0"), false},
+		{"number", nil, nil, true},
+	}
+	for _, tt := range tests {
+		if got := sameValue(tt.dataType, tt.parsed, tt.stored); got != tt.want {
+			t.Errorf("%s %#v vs %#v: got %v", tt.dataType, tt.parsed, tt.stored, got)
+		}
+	}
+}
+
+func TestPendingChangeVisibleTo(t *testing.T) {
+	change := func() *PendingChange {
+		return &PendingChange{
+			Changes:  map[string]interface{}{"salary": 70000.0, "title": "Lead"},
+			Previous: map[string]interface{}{"salary": 60000.0, "title": "Dev"},
+		}
+	}
+	c := change()
+	if !c.visibleTo(nil) || len(c.Changes) != 2 {
+		t.Fatalf("admin: got %v", c.Changes)
+	}
+	c = change()
+	if !c.visibleTo(map[string]bool{"title": true}) {
+		t.Fatal("change with a viewable field hidden")
+	}
+	if want := map[string]interface{}{"title": "Lead"}; !reflect.DeepEqual(c.Changes, want) || len(c.Previous) != 1 {
+		t.Fatalf("got %v / %v", c.Changes, c.Previous)
+	}
+	if c = change(); c.visibleTo(map[string]bool{"name": true}) {
+		t.Fatalf("change without viewable fields listed: %v", c.Changes)
+	}
+}
+
+// A held change of a state field needs the requester's transition grant, checked when it is
+// requested: the approver's grants do not apply when it is approved
+func TestHoldChecksRequesterTransitions(t *testing.T) {
+	res := registry.Resource{ID: 1, Name: "orders", ApproverRoleID: 3, Fields: []registry.Field{
+		{ID: 1, Name: "status", DataType: "text", IsSensitive: true},
+	}}
+	transitions := dbtest.Rule{Match: "FROM field_transitions", Columns: []string{"resource_field_id", "id", "name", "from_state", "to_state"},
+		Rows: [][]driver.Value{{int64(1), int64(10), "close", "Open", "Closed"}}}
+	row := dbtest.Rule{Match: `SELECT * FROM "orders"`, Columns: []string{"id", "status", "version"},
+		Rows: [][]driver.Value{{int64(7), "Open", int64(2)}}}
+	writable := dbtest.Rule{Match: "SELECT EXISTS", Columns: []string{"exists"}, Rows: [][]driver.Value{{true}}}
+	insert := dbtest.Rule{Match: "INSERT INTO pending_changes", Columns: []string{"id"}, Rows: [][]driver.Value{{int64(4)}}}
+
+	for _, granted := range []bool{false, true} {
+		db := useDB(t, append(registryRules(res), transitions, row, writable, insert)...)
+		r := testRepository()
+		schema, err := r.schemaFor("orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &writer{schema: schema, user: &utils.Claims{ID: 5, RoleID: 2}, filters: map[string]*rowFilter{},
+			grants: map[int]bool{10: granted}}
+
+		tx, err := config.DB.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := map[string]interface{}{"status": "Closed"}
+		changeID, _, err := r.hold(tx, w, "7", data, schema.protectedValues(data), nil)
+		tx.Rollback()
+
+		if !granted {
+			if !errors.Is(err, ErrPermissionDenied) {
+				t.Fatalf("without the grant: got %v, want ErrPermissionDenied", err)
+			}
+			if db.Ran("INSERT INTO pending_changes") {
+				t.Fatal("change held without the requester's grant")
+			}
+			continue
+		}
+		if err != nil || changeID != 4 {
+			t.Fatalf("with the grant: got change %d, %v", changeID, err)
+		}
+	}
+}
+
+// Approving applies the change whatever the approver's own transition grants
+func TestApplyChangeIgnoresApproverTransitions(t *testing.T) {
+	res := registry.Resource{ID: 1, Name: "orders", ApproverRoleID: 3, Fields: []registry.Field{
+		{ID: 1, Name: "status", DataType: "text", IsSensitive: true},
+	}}
+	transitions := dbtest.Rule{Match: "FROM field_transitions", Columns: []string{"resource_field_id", "id", "name", "from_state", "to_state"},
+		Rows: [][]driver.Value{{int64(1), int64(10), "close", "Open", "Closed"}}}
+	// The row is read before the update (twice) and after it
+	before := dbtest.Rule{Match: `SELECT * FROM "orders"`, Times: 2, Columns: []string{"id", "status", "version"},
+		Rows: [][]driver.Value{{int64(7), "Open", int64(2)}}}
+	after := dbtest.Rule{Match: `SELECT * FROM "orders"`, Columns: []string{"id", "status", "version"},
+		Rows: [][]driver.Value{{int64(7), "Closed", int64(3)}}}
+	update := dbtest.Rule{Match: `UPDATE "orders"`, Affected: 1}
+
+	db := useDB(t, append(registryRules(res), transitions, before, after, update)...)
+	r := testRepository()
+	schema, err := r.schemaFor("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	approver := &writer{schema: schema, user: &utils.Claims{ID: 6, RoleID: 3}, filters: map[string]*rowFilter{}, grants: map[int]bool{}}
+	change := &PendingChange{RecordID: 7, Changes: map[string]interface{}{"status": "Closed"}, Previous: map[string]interface{}{"status": "Open"}}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := r.applyChange(tx, approver, change); err != nil {
+		t.Fatalf("approval denied: %v", err)
+	}
+	if !db.Ran("INSERT INTO record_history") {
+		t.Fatal("approved change not recorded")
+	}
+}
@@ -0,0 +1,171 @@
+package resource
+
+// Batched writes. All operations of a batch run in one transaction, each inside its own
+// savepoint so a failing operation can be reported without aborting the transaction. In atomic
+// mode (the default) any failure rolls the whole batch back; otherwise the successful
+// operations are committed.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"server/internal/config"
+	"server/internal/permission"
+	"server/pkg/utils"
+	"strconv"
+)
+
+const maxBatchSize = 1000
+
+// Batch operation kinds
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+var batchActions = map[string]string{
+	BatchCreate: permission.ActionCreate,
+	BatchUpdate: permission.ActionUpdate,
+	BatchDelete: permission.ActionDelete,
+}
+
+// BatchRequest is the body of POST /api/data/:resource/_batch
+type BatchRequest struct {
+	Operations      []BatchOperation `json:"operations"`
+	ContinueOnError bool             `json:"continue_on_error"`
+}
+
+// BatchOperation is one create, update or delete. Version works like an If-Match header.
+type BatchOperation struct {
+	Op      string                 `json:"op"`
+	ID      json.Number            `json:"id,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Version *int                   `json:"version,omitempty"`
+}
+
+// BatchResult is the outcome of one operation; Err is turned into a status by the handler
+type BatchResult struct {
+	Index         int
+	Op            string
+	ID            int
+	Version       int
+	IgnoredFields []string
+	PendingChange int
+	Err           error
+}
+
+// actions validates the shape of every operation and returns the table-level actions needed
+func (req *BatchRequest) actions() ([]string, error) {
+	if len(req.Operations) == 0 {
+		return nil, &QueryError{Message: "operations must not be empty"}
+	}
+	if len(req.Operations) > maxBatchSize {
+		return nil, &QueryError{Message: fmt.Sprintf("a batch may contain at most %d operations", maxBatchSize)}
+	}
+
+	seen := map[string]bool{}
+	actions := []string{}
+	for i, op := range req.Operations {
+		action, ok := batchActions[op.Op]
+		if !ok {
+			return nil, &QueryError{Message: fmt.Sprintf("operations[%d]: op must be create, update or delete", i)}
+		}
+		if op.Op != BatchCreate && op.ID == "" {
+			return nil, &QueryError{Message: fmt.Sprintf("operations[%d]: id is required for %s", i, op.Op)}
+		}
+		if !seen[action] {
+			seen[action] = true
+			actions = append(actions, action)
+		}
+	}
+	return actions, nil
+}
+
+// Batch executes the operations and reports whether they were committed. Permissions, editable
+// fields and row filters are loaded once for the whole batch.
+func (r *Repository) Batch(resource string, req *BatchRequest, user *utils.Claims, strict bool) ([]BatchResult, bool, error) {
+	w, err := r.newWriter(resource, user)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	results := make([]BatchResult, len(req.Operations))
+	failed := false
+	for i, op := range req.Operations {
+		results[i] = BatchResult{Index: i, Op: op.Op}
+		// Later operations still run after a failure so every error is reported at once
+		results[i].Err, err = inSavepoint(tx, func() error {
+			return r.batchOp(tx, w, op, &results[i], strict)
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		failed = failed || results[i].Err != nil
+	}
+
+	if failed && !req.ContinueOnError {
+		for i := range results {
+			if results[i].Err == nil {
+				rolledBack := BatchResult{Index: i, Op: results[i].Op, Err: ErrRolledBack}
+				if rolledBack.Op != BatchCreate {
+					rolledBack.ID = results[i].ID // the id of a rolled back create was never used
+				}
+				results[i] = rolledBack
+			}
+		}
+		return results, false, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	r.removeFiles(w)
+	return results, true, nil
+}
+
+// inSavepoint runs fn so that its failure undoes only its own changes and leaves tx usable.
+// It returns fn's error, and an error of its own if the savepoint could not be managed.
+func inSavepoint(tx *sql.Tx, fn func() error) (error, error) {
+	if _, err := tx.Exec("SAVEPOINT batch_op"); err != nil {
+		return nil, err
+	}
+	if opErr := fn(); opErr != nil {
+		_, err := tx.Exec("ROLLBACK TO SAVEPOINT batch_op")
+		return opErr, err
+	}
+	_, err := tx.Exec("RELEASE SAVEPOINT batch_op")
+	return nil, err
+}
+
+func (r *Repository) batchOp(tx *sql.Tx, w *writer, op BatchOperation, result *BatchResult, strict bool) error {
+	var pre *Precondition
+	if op.Version != nil {
+		pre = &Precondition{Versions: []int{*op.Version}}
+	}
+	id := op.ID.String()
+	result.ID, _ = strconv.Atoi(id)
+
+	switch op.Op {
+	case BatchCreate:
+		res, err := r.create(tx, w, op.Data, strict)
+		if err != nil {
+			return err
+		}
+		result.ID, result.Version, result.IgnoredFields = res.ID, res.Version, res.IgnoredFields
+		return nil
+
+	case BatchUpdate:
+		res, err := r.updateRecord(tx, w, id, op.Data, strict, pre)
+		if res != nil {
+			result.Version, result.IgnoredFields, result.PendingChange = res.Version, res.IgnoredFields, res.PendingChange
+		}
+		return err
+	}
+	return r.delete(tx, w, id, pre)
+}
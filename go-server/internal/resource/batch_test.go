@@ -0,0 +1,88 @@
+package resource
+
+import (
+	"errors"
+	"reflect"
+	"server/internal/dbtest"
+	"strings"
+	"testing"
+)
+
+func TestBatchActions(t *testing.T) {
+	req := &BatchRequest{Operations: []BatchOperation{
+		{Op: BatchUpdate, ID: "1"}, {Op: BatchCreate}, {Op: BatchUpdate, ID: "2"}, {Op: BatchDelete, ID: "3"},
+	}}
+	actions, err := req.actions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"update", "create", "delete"}; !reflect.DeepEqual(actions, want) {
+		t.Fatalf("got %v, want %v", actions, want)
+	}
+
+	invalid := map[string][]BatchOperation{
+		"empty":             {},
+		"unknown op":        {{Op: "upsert"}},
+		"update without id": {{Op: BatchUpdate}},
+		"delete without id": {{Op: BatchCreate}, {Op: BatchDelete}},
+		"too many":          make([]BatchOperation, maxBatchSize+1),
+	}
+	for name, ops := range invalid {
+		var queryErr *QueryError
+		if _, err := (&BatchRequest{Operations: ops}).actions(); !errors.As(err, &queryErr) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+// A failed operation is undone up to its savepoint; the transaction goes on
+func TestInSavepoint(t *testing.T) {
+	db := dbtest.Open()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	failure := errors.New("invalid")
+	for _, want := range []error{nil, failure} {
+		opErr, err := inSavepoint(tx, func() error {
+			_, err := tx.Exec("UPDATE orders SET amount = 1")
+			if err != nil {
+				return err
+			}
+			return want
+		})
+		if err != nil || opErr != want {
+			t.Fatalf("got %v, %v; want %v", opErr, err, want)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"BEGIN",
+		"SAVEPOINT batch_op", "UPDATE orders SET amount = 1", "RELEASE SAVEPOINT batch_op",
+		"SAVEPOINT batch_op", "UPDATE orders SET amount = 1", "ROLLBACK TO SAVEPOINT batch_op",
+		"COMMIT",
+	}
+	if got := db.Statements(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+// When the savepoint cannot be set the operation does not run
+func TestInSavepointError(t *testing.T) {
+	db := dbtest.Open(dbtest.Rule{Match: "SAVEPOINT", Err: errors.New("connection lost")})
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ran := false
+	if _, err := inSavepoint(tx, func() error { ran = true; return nil }); err == nil || ran {
+		t.Fatalf("got %v, ran %v", err, ran)
+	}
+}
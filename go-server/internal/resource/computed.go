@@ -0,0 +1,167 @@
+package resource
+
+// Computed fields (resource_fields.expression) have no column: they are evaluated per record at
+// read time from the stored fields they read, e.g. orders.amount_with_tax = round(amount * 1.2, 2).
+// A role sees one only with view permission on the computed field itself and on every field its
+// expression reads. They cannot be filtered, sorted or written.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"server/internal/expr"
+	"strconv"
+	"time"
+)
+
+type computedField struct {
+	name     string
+	dataType string
+	expr     *expr.Expression
+	deps     []string // fields the expression reads, all present in the schema
+}
+
+// loadComputed parses the computed fields of the schema's resource. Fields whose expression no
+// longer parses or reads a column missing from the table are left out.
+func (s *tableSchema) loadComputed() {
+	s.computed = map[string]*computedField{}
+	for _, f := range s.resource.Fields {
+		if !f.Computed() {
+			continue
+		}
+		e, err := expr.ParseExpression(f.Expression)
+		if err != nil {
+			continue
+		}
+		deps := e.Fields()
+		complete := true
+		for _, d := range deps {
+			_, ok := s.types[d]
+			complete = complete && ok
+		}
+		if complete {
+			s.computed[f.Name] = &computedField{name: f.Name, dataType: f.DataType, expr: e, deps: deps}
+		}
+	}
+}
+
+// canViewComputed requires view permission on the field and on everything it reads
+func canViewComputed(viewFields map[string]bool, c *computedField) bool {
+	if !canView(viewFields, c.name) {
+		return false
+	}
+	for _, d := range c.deps {
+		if !canView(viewFields, d) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitComputed separates the computed fields of a fieldset from its columns. Without a
+// fieldset it returns every computed field the role may see.
+func (s *tableSchema) splitComputed(fields []string, viewFields map[string]bool) ([]string, []*computedField, error) {
+	if len(fields) == 0 {
+		computed := []*computedField{}
+		for _, f := range s.resource.Fields {
+			if c, ok := s.computed[f.Name]; ok && canViewComputed(viewFields, c) {
+				computed = append(computed, c)
+			}
+		}
+		return nil, computed, nil
+	}
+
+	columns := []string{}
+	computed := []*computedField{}
+	for _, f := range fields {
+		c, ok := s.computed[f]
+		if !ok {
+			columns = append(columns, f)
+			continue
+		}
+		if !canViewComputed(viewFields, c) {
+			return nil, nil, fmt.Errorf("%w: cannot select on field %q", ErrPermissionDenied, f)
+		}
+		computed = append(computed, c)
+	}
+	return columns, computed, nil
+}
+
+// checkNotComputed rejects filters and sorts on computed fields, which have no column
+func (s *tableSchema) checkNotComputed(q *ListQuery) error {
+	for _, f := range q.Filters {
+		if _, ok := s.computed[f.Field]; ok {
+			return &QueryError{Message: fmt.Sprintf("cannot filter on computed field %q", f.Field)}
+		}
+	}
+	for _, o := range q.Sort {
+		if _, ok := s.computed[o.Field]; ok {
+			return &QueryError{Message: fmt.Sprintf("cannot sort on computed field %q", o.Field)}
+		}
+	}
+	return nil
+}
+
+// readOnly reports writes to computed fields
+func (s *tableSchema) readOnly(data map[string]interface{}) error {
+	errs := []FieldError{}
+	for _, k := range sortedKeys(data) {
+		if _, ok := s.computed[k]; ok {
+			errs = append(errs, FieldError{k, "read_only", "is computed and cannot be written"})
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// addComputed evaluates fields on a full row and adds them to its projection out
+func addComputed(out, row map[string]interface{}, fields []*computedField) {
+	for _, c := range fields {
+		out[c.name] = c.value(row)
+	}
+}
+
+// value evaluates the field on one row. Errors such as a division by zero yield null.
+func (c *computedField) value(row map[string]interface{}) interface{} {
+	v, err := c.expr.Eval(func(field string) interface{} { return row[field] })
+	if err != nil || v == nil {
+		return nil
+	}
+
+	n, isNumber := v.(float64)
+	if isNumber && (math.IsNaN(n) || math.IsInf(n, 0)) {
+		return nil
+	}
+	switch c.dataType {
+	case "number", "integer":
+		if isNumber {
+			return int64(math.Round(n))
+		}
+	case "decimal":
+		if isNumber {
+			// Same scale as stored decimals, so float noise (0.30000000000000004) does not show
+			return json.Number(strconv.FormatFloat(math.Round(n*1e4)/1e4, 'f', -1, 64))
+		}
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	case "date":
+		if t, ok := v.(time.Time); ok {
+			return t.Format("2006-01-02")
+		}
+		if s, ok := v.(string); ok {
+			if d, err := parseDate(s); err == nil {
+				return d
+			}
+		}
+	default:
+		if isNumber {
+			return strconv.FormatFloat(n, 'f', -1, 64)
+		}
+		return fmt.Sprint(v)
+	}
+	return nil // the result does not fit the declared type
+}
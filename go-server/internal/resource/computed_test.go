@@ -0,0 +1,90 @@
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"server/internal/registry"
+	"testing"
+)
+
+func computedSchema() *tableSchema {
+	s := testSchema()
+	s.resource = &registry.Resource{Name: "orders", Fields: []registry.Field{
+		{Name: "customer_name", DataType: "text"},
+		{Name: "amount", DataType: "number"},
+		{Name: "with_tax", DataType: "decimal", Expression: "amount * 1.2"},
+		{Name: "rounded", DataType: "integer", Expression: "amount / 3"},
+		{Name: "label", DataType: "text", Expression: "concat(upper(customer_name), ': ', amount)"},
+		{Name: "ratio", DataType: "decimal", Expression: "amount / 0"},
+		{Name: "flag", DataType: "boolean", Expression: "amount"},
+		{Name: "broken", DataType: "text", Expression: "missing_field + 1"},
+	}}
+	s.loadComputed()
+	return s
+}
+
+func TestComputedValues(t *testing.T) {
+	s := computedSchema()
+	if _, ok := s.computed["broken"]; ok {
+		t.Fatal("field reading an unknown column was loaded")
+	}
+
+	row := map[string]interface{}{"id": int64(1), "customer_name": "acme", "amount": json.Number("10.25")}
+	_, computed, err := s.splitComputed(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	addComputed(out, row, computed)
+	want := map[string]interface{}{
+		"with_tax": json.Number("12.3"),
+		"rounded":  int64(3),
+		"label":    "ACME: 10.25",
+		"ratio":    nil, // division by zero
+		"flag":     nil, // not a boolean
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("got %#v, want %#v", out, want)
+	}
+}
+
+// A computed field is only visible with view permission on it and on every field it reads
+func TestSplitComputed(t *testing.T) {
+	s := computedSchema()
+	view := map[string]bool{"with_tax": true, "label": true, "amount": true}
+
+	_, computed, err := s.splitComputed(nil, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(computed) != 1 || computed[0].name != "with_tax" {
+		t.Fatalf("got %v", computed)
+	}
+
+	columns, computed, err := s.splitComputed([]string{"amount", "with_tax"}, view)
+	if err != nil || !reflect.DeepEqual(columns, []string{"amount"}) || len(computed) != 1 {
+		t.Fatalf("got %v %v %v", columns, computed, err)
+	}
+	if _, _, err := s.splitComputed([]string{"label"}, view); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("label reads customer_name: got %v", err)
+	}
+}
+
+func TestComputedFieldsAreReadOnly(t *testing.T) {
+	s := computedSchema()
+	err := s.readOnly(map[string]interface{}{"with_tax": 1, "amount": 2})
+	if got := fieldCodes(t, err); !reflect.DeepEqual(got, []string{"with_tax:read_only"}) {
+		t.Fatalf("got %v", got)
+	}
+
+	var queryErr *QueryError
+	q := &ListQuery{Filters: []Filter{{Field: "label", Op: "eq", Value: "x"}}}
+	if err := s.checkNotComputed(q); !errors.As(err, &queryErr) {
+		t.Fatalf("filter on a computed field: got %v", err)
+	}
+	q = &ListQuery{Sort: []SortField{{Field: "with_tax", Desc: true}}}
+	if err := s.checkNotComputed(q); !errors.As(err, &queryErr) {
+		t.Fatalf("sort on a computed field: got %v", err)
+	}
+}
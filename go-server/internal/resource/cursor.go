@@ -0,0 +1,117 @@
+package resource
+
+// Keyset pagination. A cursor carries the sort key values of the last row of a page,
+// signed so clients cannot forge positions or swap the sort order under an existing cursor.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"server/pkg/utils"
+	"strings"
+	"time"
+)
+
+type cursor struct {
+	Order  string        `json:"o"`
+	Values []interface{} `json:"v"`
+}
+
+func encodeCursor(order []SortField, row map[string]interface{}) (string, error) {
+	c := cursor{Order: orderKey(order)}
+	for _, s := range order {
+		v := row[s.Field]
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339Nano)
+		}
+		c.Values = append(c.Values, v)
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return utils.SignPayload(payload), nil
+}
+
+func decodeCursor(token string, order []SortField) (*cursor, error) {
+	payload, err := utils.VerifyPayload(token)
+	if err != nil {
+		return nil, &QueryError{Message: "invalid cursor"}
+	}
+
+	var c cursor
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil {
+		return nil, &QueryError{Message: "invalid cursor"}
+	}
+	if c.Order != orderKey(order) || len(c.Values) != len(order) {
+		return nil, &QueryError{Message: "cursor does not match the requested sort order"}
+	}
+
+	for i, v := range c.Values {
+		if n, ok := v.(json.Number); ok {
+			c.Values[i] = n.String()
+		}
+	}
+	return &c, nil
+}
+
+// buildKeyset returns a condition selecting rows strictly after the cursor position.
+// NULLs sort last in ascending order and first in descending order (Postgres defaults).
+func (c *cursor) buildKeyset(order []SortField, start int) (string, []interface{}) {
+	args := []interface{}{}
+	n := start
+	ors := []string{}
+
+	for i, s := range order {
+		v := c.Values[i]
+		if !s.Desc && v == nil {
+			continue // nothing sorts after NULL ascending
+		}
+
+		col := quoteIdent(s.Field)
+		ands := []string{}
+		for j := 0; j < i; j++ {
+			if c.Values[j] == nil {
+				ands = append(ands, fmt.Sprintf("%s IS NULL", quoteIdent(order[j].Field)))
+			} else {
+				ands = append(ands, fmt.Sprintf("%s = $%d", quoteIdent(order[j].Field), n))
+				args = append(args, c.Values[j])
+				n++
+			}
+		}
+
+		switch {
+		case !s.Desc:
+			ands = append(ands, fmt.Sprintf("(%s > $%d OR %s IS NULL)", col, n, col))
+			args = append(args, v)
+			n++
+		case v == nil:
+			ands = append(ands, fmt.Sprintf("%s IS NOT NULL", col))
+		default:
+			ands = append(ands, fmt.Sprintf("%s < $%d", col, n))
+			args = append(args, v)
+			n++
+		}
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	if len(ors) == 0 {
+		return "FALSE", args
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+func orderKey(order []SortField) string {
+	parts := []string{}
+	for _, s := range order {
+		if s.Desc {
+			parts = append(parts, "-"+s.Field)
+		} else {
+			parts = append(parts, s.Field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,220 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var keysetToken = regexp.MustCompile(`\(|\)|"(?:[^"]|"")*"|\$[0-9]+|[A-Za-z_][A-Za-z0-9_]*|[<>=]`)
+
+const (
+	sqlFalse = iota
+	sqlTrue
+	sqlNull
+)
+
+// keysetEval evaluates a buildKeyset condition on a row with SQL's three-valued logic
+type keysetEval struct {
+	t      *testing.T
+	tokens []string
+	args   []interface{}
+	start  int // number of the first placeholder
+	row    map[string]interface{}
+}
+
+func evalKeyset(t *testing.T, cond string, args []interface{}, start int, row map[string]interface{}) bool {
+	t.Helper()
+	e := &keysetEval{t: t, tokens: keysetToken.FindAllString(cond, -1), args: args, start: start, row: row}
+	v := e.or()
+	if len(e.tokens) > 0 {
+		t.Fatalf("unexpected %q in %q", e.tokens, cond)
+	}
+	return v == sqlTrue
+}
+
+func (e *keysetEval) next() string {
+	if len(e.tokens) == 0 {
+		e.t.Fatal("unexpected end of condition")
+	}
+	tok := e.tokens[0]
+	e.tokens = e.tokens[1:]
+	return tok
+}
+
+func (e *keysetEval) accept(tok string) bool {
+	if len(e.tokens) > 0 && e.tokens[0] == tok {
+		e.tokens = e.tokens[1:]
+		return true
+	}
+	return false
+}
+
+func (e *keysetEval) or() int {
+	v := e.and()
+	for e.accept("OR") {
+		w := e.and()
+		switch {
+		case v == sqlTrue || w == sqlTrue:
+			v = sqlTrue
+		case v == sqlNull || w == sqlNull:
+			v = sqlNull
+		}
+	}
+	return v
+}
+
+func (e *keysetEval) and() int {
+	v := e.term()
+	for e.accept("AND") {
+		w := e.term()
+		switch {
+		case v == sqlFalse || w == sqlFalse:
+			v = sqlFalse
+		case v == sqlNull || w == sqlNull:
+			v = sqlNull
+		}
+	}
+	return v
+}
+
+func (e *keysetEval) term() int {
+	tok := e.next()
+	switch tok {
+	case "(":
+		v := e.or()
+		if e.next() != ")" {
+			e.t.Fatal("unbalanced parentheses")
+		}
+		return v
+	case "FALSE":
+		return sqlFalse
+	}
+
+	value := e.row[strings.Trim(tok, `"`)]
+	op := e.next()
+	if op == "IS" {
+		negate := e.accept("NOT")
+		if e.next() != "NULL" {
+			e.t.Fatal("expected NULL")
+		}
+		if (value == nil) != negate {
+			return sqlTrue
+		}
+		return sqlFalse
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(e.next(), "$"))
+	if err != nil {
+		e.t.Fatalf("expected a placeholder after %s %s", tok, op)
+	}
+	if value == nil {
+		return sqlNull
+	}
+	a, b := value.(int), e.args[n-e.start].(int)
+	if map[string]bool{"=": a == b, "<": a < b, ">": a > b}[op] {
+		return sqlTrue
+	}
+	return sqlFalse
+}
+
+// compareRows orders rows like Postgres: NULLS LAST ascending, NULLS FIRST descending
+func compareRows(order []SortField, a, b map[string]interface{}) int {
+	for _, s := range order {
+		x, y := a[s.Field], b[s.Field]
+		c := 0
+		switch {
+		case x == nil && y == nil:
+		case x == nil:
+			c = 1
+		case y == nil:
+			c = -1
+		default:
+			c = x.(int) - y.(int)
+		}
+		if s.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Every row is a possible cursor position; the keyset must select exactly the rows after it
+func TestBuildKeysetMatchesSortOrder(t *testing.T) {
+	values := []interface{}{nil, 1, 2}
+	rows := []map[string]interface{}{}
+	id := 0
+	for _, a := range values {
+		for _, b := range values {
+			for k := 0; k < 2; k++ {
+				id++
+				rows = append(rows, map[string]interface{}{"id": id, "amount": a, "status": b})
+			}
+		}
+	}
+
+	for _, sortParam := range []string{"", "amount", "-amount", "amount,status", "-amount,status", "amount,-status", "-amount,-status", "-status,-id", "status,id,amount"} {
+		q, err := ParseListQuery(map[string][]string{"sort": {sortParam}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		order := q.orderFields()
+
+		sorted := append([]map[string]interface{}{}, rows...)
+		sort.Slice(sorted, func(i, j int) bool { return compareRows(order, sorted[i], sorted[j]) < 0 })
+
+		for pos, last := range sorted {
+			c := &cursor{}
+			for _, s := range order {
+				c.Values = append(c.Values, last[s.Field])
+			}
+			cond, args := c.buildKeyset(order, 3)
+			for i, row := range sorted {
+				if got, want := evalKeyset(t, cond, args, 3, row), i > pos; got != want {
+					t.Fatalf("sort=%q after id %d: row id %d selected=%v, want %v (%s %v)",
+						sortParam, last["id"], row["id"], got, want, cond, args)
+				}
+			}
+		}
+	}
+}
+
+func TestBuildKeysetQuotesColumns(t *testing.T) {
+	order := []SortField{{Field: "customer_name"}, {Field: "amount", Desc: true}, {Field: "id"}}
+	c := &cursor{Values: []interface{}{"x'); DROP TABLE orders; --", nil, "5"}}
+	cond, args := c.buildKeyset(order, 1)
+	assertIdentifiers(t, cond, allowedColumns(testSchema()))
+	if len(args) != strings.Count(cond, "$") {
+		t.Fatalf("%d args for %q", len(args), cond)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	order := []SortField{{Field: "amount", Desc: true}, {Field: "id"}}
+	token, err := encodeCursor(order, map[string]interface{}{"amount": 12, "id": 7, "status": "open"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := decodeCursor(token, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(c.Values) != "[12 7]" {
+		t.Fatalf("got %v", c.Values)
+	}
+
+	var queryErr *QueryError
+	if _, err := decodeCursor(token, []SortField{{Field: "amount"}, {Field: "id"}}); !errors.As(err, &queryErr) {
+		t.Fatalf("cursor accepted for another sort order: %v", err)
+	}
+	if _, err := decodeCursor(token+"x", order); !errors.As(err, &queryErr) {
+		t.Fatalf("tampered cursor accepted: %v", err)
+	}
+}
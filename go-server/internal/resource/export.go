@@ -0,0 +1,334 @@
+package resource
+
+// Export of list results as CSV, XLSX or JSON. An export runs the same plan as the list
+// endpoint (filters, sort, field and row permissions, masking) over every matching row.
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"server/internal/config"
+	"server/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats (csv is shared with imports)
+const (
+	FormatXLSX = "xlsx"
+	FormatJSON = "json"
+)
+
+const exportProgressEvery = 500
+
+// ExportContentTypes maps each export format to its MIME type
+var ExportContentTypes = map[string]string{
+	FormatCSV:  "text/csv; charset=utf-8",
+	FormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	FormatJSON: "application/json",
+}
+
+// Export is a planned export. Rows is the number of rows it will produce.
+type Export struct {
+	Columns  []string
+	Rows     int
+	Progress func(written int) // optional, called every exportProgressEvery rows
+
+	r     *Repository
+	plan  *listPlan
+	after string
+	limit int
+}
+
+// PlanExport validates q like GetAll and counts the rows an export of it would contain
+func (r *Repository) PlanExport(resource string, user *utils.Claims, q *ListQuery) (*Export, error) {
+	plan, err := r.planList(resource, user, q, false)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := r.schemaFor(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Export{Columns: exportColumns(schema, plan, q.Fields), r: r, plan: plan, after: q.After, limit: q.Limit}
+	if err := config.DB.QueryRow("SELECT COUNT(*)"+plan.from, plan.args...).Scan(&e.Rows); err != nil {
+		return nil, err
+	}
+	if e.limit > 0 && e.limit < e.Rows {
+		e.Rows = e.limit
+	}
+	return e, nil
+}
+
+// exportColumns orders the exported columns: id, the requested (or all visible and masked)
+// fields in registry order, then created_at. The version column is left out.
+func exportColumns(schema *tableSchema, plan *listPlan, fields []string) []string {
+	cols := []string{"id"}
+	if len(fields) > 0 {
+		for _, f := range fields {
+			if f != "id" {
+				cols = append(cols, f)
+			}
+		}
+		return cols
+	}
+
+	computed := map[string]bool{}
+	for _, c := range plan.computed {
+		computed[c.name] = true
+	}
+	for _, f := range schema.resource.Fields {
+		if _, ok := schema.types[f.Name]; !ok && !computed[f.Name] {
+			continue
+		}
+		if _, masked := plan.masks[f.Name]; canView(plan.viewFields, f.Name) || masked || computed[f.Name] {
+			cols = append(cols, f.Name)
+		}
+	}
+	if _, ok := schema.types["created_at"]; ok {
+		cols = append(cols, "created_at")
+	}
+	return cols
+}
+
+// WriteTo writes the export in a format and returns the number of rows written
+func (e *Export) WriteTo(format string, out io.Writer) (int, error) {
+	var w exportWriter
+	switch format {
+	case FormatCSV:
+		w = &csvExport{w: csv.NewWriter(out)}
+	case FormatJSON:
+		w = &jsonExport{out: out, enc: json.NewEncoder(out)}
+	case FormatXLSX:
+		w = &xlsxExport{zw: zip.NewWriter(out)}
+	default:
+		return 0, &QueryError{Message: "format must be csv, xlsx or json"}
+	}
+
+	if err := w.header(e.Columns); err != nil {
+		return 0, err
+	}
+	n := 0
+	err := e.r.query(e.plan, e.after, e.limit, func(row map[string]interface{}) error {
+		row = e.plan.project(row)
+		values := make([]interface{}, len(e.Columns))
+		for i, col := range e.Columns {
+			values[i] = row[col]
+		}
+		n++
+		if e.Progress != nil && n%exportProgressEvery == 0 {
+			e.Progress(n)
+		}
+		return w.row(e.Columns, values)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, w.close()
+}
+
+type exportWriter interface {
+	header(cols []string) error
+	row(cols []string, values []interface{}) error
+	close() error
+}
+
+// exportText renders a value for text formats; times use RFC 3339
+func exportText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []byte:
+		return string(v)
+	case json.RawMessage:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
+
+type csvExport struct {
+	w *csv.Writer
+}
+
+func (c *csvExport) header(cols []string) error {
+	return c.w.Write(cols)
+}
+
+func (c *csvExport) row(_ []string, values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		s := exportText(v)
+		// Text starting like a formula would be evaluated by spreadsheet programs
+		if _, isText := v.(string); isText && s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+			s = "'" + s
+		}
+		record[i] = s
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvExport) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonExport writes an array of objects without holding the rows in memory
+type jsonExport struct {
+	out     io.Writer
+	enc     *json.Encoder
+	started bool
+}
+
+func (j *jsonExport) header([]string) error {
+	_, err := io.WriteString(j.out, "[")
+	return err
+}
+
+func (j *jsonExport) row(cols []string, values []interface{}) error {
+	if j.started {
+		if _, err := io.WriteString(j.out, ","); err != nil {
+			return err
+		}
+	}
+	j.started = true
+	obj := make(map[string]interface{}, len(cols))
+	for i, col := range cols {
+		obj[col] = values[i]
+	}
+	return j.enc.Encode(obj)
+}
+
+func (j *jsonExport) close() error {
+	_, err := io.WriteString(j.out, "]\n")
+	return err
+}
+
+// xlsxExport writes a single-sheet workbook. The package parts are fixed apart from the sheet,
+// which is streamed row by row with inline strings, so no shared string table is needed.
+type xlsxExport struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	rowNo int
+}
+
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func (x *xlsxExport) header(cols []string) error {
+	for _, part := range xlsxParts {
+		f, err := x.zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := x.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = sheet
+	_, err = io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(cols))
+	for i, c := range cols {
+		values[i] = c
+	}
+	return x.row(cols, values)
+}
+
+func (x *xlsxExport) row(_ []string, values []interface{}) error {
+	x.rowNo++
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, x.rowNo)
+	for i, v := range values {
+		ref := xlsxColumn(i) + strconv.Itoa(x.rowNo)
+		switch n := v.(type) {
+		case nil:
+			continue
+		case int64:
+			fmt.Fprintf(&b, `<c r="%s"><v>%d</v></c>`, ref, n)
+		case float64:
+			fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(n, 'f', -1, 64))
+		case json.Number:
+			fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, n)
+		case bool:
+			v := 0
+			if n {
+				v = 1
+			}
+			fmt.Fprintf(&b, `<c r="%s" t="b"><v>%d</v></c>`, ref, v)
+		default:
+			fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xmlEscape(exportText(v)))
+		}
+	}
+	b.WriteString("</row>")
+	_, err := io.WriteString(x.sheet, b.String())
+	return err
+}
+
+func (x *xlsxExport) close() error {
+	if _, err := io.WriteString(x.sheet, "</sheetData></worksheet>"); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// xlsxColumn converts a 0-based index to a column name (0 -> A, 26 -> AA)
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xmlEscape escapes text content and drops characters XML 1.0 cannot represent
+func xmlEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '&':
+			b.WriteString("&amp;")
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>':
+			b.WriteString("&gt;")
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r':
+			// not allowed in XML
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,29 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+)
+
+func TestCSVExportEscapesFormulas(t *testing.T) {
+	var out bytes.Buffer
+	c := &csvExport{w: csv.NewWriter(&out)}
+	values := []interface{}{"=HYPERLINK(\"x\")", "+1", "-2", "@SUM(A1)", "\t=1", "\r=1", "plain", int64(-5), ""}
+	if err := c.row(nil, values); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := csv.NewReader(&out).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"'=HYPERLINK(\"x\")", "'+1", "'-2", "'@SUM(A1)", "'\t=1", "'\r=1", "plain", "-5", ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
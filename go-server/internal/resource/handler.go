@@ -1,14 +1,44 @@
 package resource
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"server/internal/job"
+	"server/internal/registry"
 	"server/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
+const (
+	ndjsonContentType = "application/x-ndjson"
+	streamFlushEvery  = 100
+)
+
+const (
+	maxImportBytes = 20 << 20
+	importSyncRows = 500 // larger imports run as background jobs
+
+	exportSyncRows = 10000 // larger exports are written to a file in the background
+	exportLinkTTL  = 15 * time.Minute
+	exportLinkKind = "export"
+)
+
+// exportDir holds background exports until their download link expires
+var exportDir = filepath.Join(os.TempDir(), "rbac-exports")
+
 type Handler struct {
 	Service *Service
+	Jobs    *job.Service
 }
 
 func (h *Handler) GetAll(c *gin.Context) {
@@ -16,13 +46,125 @@ func (h *Handler) GetAll(c *gin.Context) {
 	claims := user.(*utils.Claims)
 
 	resource := c.Param("resource")
-	data, err := h.Service.GetAll(resource, claims.RoleID)
+	q, err := ParseListQuery(c.Request.URL.Query())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), ndjsonContentType) {
+		if len(q.Expand) > 0 {
+			respondError(c, &QueryError{Message: "expand is not supported when streaming"})
+			return
+		}
+		h.stream(c, resource, claims, q)
+		return
+	}
+
+	page, err := h.Service.GetAll(resource, claims, q)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := h.Service.Expand(resource, claims, page.Data, q.Expand); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// The body stays a plain array; pagination metadata travels in headers
+	if page.NextCursor != "" {
+		c.Header("X-Next-Cursor", page.NextCursor)
+	}
+	if page.Total != nil {
+		c.Header("X-Total-Count", strconv.Itoa(*page.Total))
+	}
+	c.JSON(http.StatusOK, page.Data)
+}
+
+// stream writes one JSON object per line as rows are scanned
+func (h *Handler) stream(c *gin.Context, resource string, user *utils.Claims, q *ListQuery) {
+	enc := json.NewEncoder(c.Writer)
+	started := false
+	count := 0
+
+	err := h.Service.Stream(resource, user, q, func(row map[string]interface{}) error {
+		if !started {
+			c.Header("Content-Type", ndjsonContentType)
+			c.Status(http.StatusOK)
+			started = true
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+		count++
+		if count%streamFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+
 	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		if !started {
+			respondError(c, err)
+			return
+		}
+		// Headers are already sent; all we can do is cut the stream short
+		log.Printf("Streaming %s aborted after %d rows: %v", resource, count, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, data)
+	if !started {
+		c.Header("Content-Type", ndjsonContentType)
+		c.Status(http.StatusOK)
+	}
+	c.Writer.Flush()
+}
+
+func (h *Handler) GetOne(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	resource := c.Param("resource")
+	id := c.Param("id")
+	q, err := ParseListQuery(c.Request.URL.Query())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	var record *Record
+	if asOf := c.Query("as_of"); asOf != "" {
+		t, perr := parseFilterValue("datetime", asOf)
+		if perr != nil {
+			respondError(c, &QueryError{Message: "as_of: " + perr.Error()})
+			return
+		}
+		record, err = h.Service.GetAsOf(resource, id, claims, q.Fields, t.(time.Time))
+	} else {
+		record, err = h.Service.GetByID(resource, id, claims, q.Fields)
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := h.Service.Expand(resource, claims, []map[string]interface{}{record.Data}, q.Expand); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Historical states are not the current representation, so they carry no ETag. Expanded
+	// records change without this record's version, so those reads are never 304.
+	if version, ok := VersionOf(record.Data); ok && c.Query("as_of") == "" {
+		etag := ReadETag(version, strconv.Itoa(claims.RoleID), strings.Join(q.Fields, ","), strings.Join(q.Expand, ","))
+		c.Header("ETag", etag)
+		p := ParsePrecondition(c.GetHeader("If-None-Match"))
+		if p != nil && len(q.Expand) == 0 && p.MatchesTag(etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, record)
 }
 
 func (h *Handler) Create(c *gin.Context) {
@@ -33,13 +175,22 @@ func (h *Handler) Create(c *gin.Context) {
 	var data map[string]interface{}
 	c.ShouldBindJSON(&data)
 
-	id, err := h.Service.Create(resource, data, claims.RoleID)
+	strict := h.strictWrites(c)
+	result, err := h.Service.Create(resource, data, claims, strict)
 	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"id": id, "message": "Created"})
+	resp := gin.H{"id": result.ID, "message": "Created"}
+	if result.Version > 0 {
+		resp["version"] = result.Version
+		c.Header("ETag", ETag(result.Version))
+	}
+	if !strict {
+		resp["ignored_fields"] = result.IgnoredFields
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 func (h *Handler) Update(c *gin.Context) {
@@ -51,13 +202,31 @@ func (h *Handler) Update(c *gin.Context) {
 	var data map[string]interface{}
 	c.ShouldBindJSON(&data)
 
-	err := h.Service.Update(resource, id, data, claims.RoleID)
+	strict := h.strictWrites(c)
+	pre := ParsePrecondition(c.GetHeader("If-Match"))
+	result, err := h.Service.Update(resource, id, data, claims, strict, pre)
+	if errors.Is(err, ErrNothingToUpdate) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error(), "ignored_fields": result.IgnoredFields})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Updated"})
+	resp := gin.H{"message": "Updated"}
+	if result.Version > 0 {
+		resp["version"] = result.Version
+		c.Header("ETag", ETag(result.Version))
+	}
+	if !strict {
+		resp["ignored_fields"] = result.IgnoredFields
+	}
+	if result.PendingChange > 0 {
+		// Changes to sensitive fields are applied only once approved
+		resp["pending_change_id"] = result.PendingChange
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 func (h *Handler) Delete(c *gin.Context) {
@@ -67,11 +236,584 @@ func (h *Handler) Delete(c *gin.Context) {
 	resource := c.Param("resource")
 	id := c.Param("id")
 
-	err := h.Service.Delete(resource, id, claims.RoleID)
+	err := h.Service.Delete(resource, id, claims, ParsePrecondition(c.GetHeader("If-Match")))
 	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
 }
+
+// Batch runs create/update/delete operations in one transaction. The response lists the outcome
+// of every operation in request order; committed tells whether any of it was applied.
+func (h *Handler) Batch(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	var req BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid batch: " + err.Error()})
+		return
+	}
+
+	strict := h.strictWrites(c)
+	results, committed, err := h.Service.Batch(c.Param("resource"), &req, claims, strict)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	out := make([]gin.H, len(results))
+	failed := 0
+	status := http.StatusOK
+	for i, res := range results {
+		item := gin.H{"index": res.Index, "op": res.Op, "status": http.StatusOK}
+		if res.ID != 0 {
+			item["id"] = res.ID
+		}
+		if res.Err != nil {
+			code, body := errorResponse(res.Err)
+			if errors.Is(res.Err, ErrRolledBack) {
+				code = http.StatusFailedDependency
+			} else {
+				failed++
+				if status == http.StatusOK {
+					status = code // an atomic batch fails with the status of its first error
+				}
+			}
+			for k, v := range body {
+				item[k] = v
+			}
+			item["status"] = code
+		} else {
+			if res.Version > 0 {
+				item["version"] = res.Version
+			}
+			if !strict {
+				item["ignored_fields"] = res.IgnoredFields
+			}
+			if res.PendingChange > 0 {
+				item["pending_change_id"] = res.PendingChange
+			}
+		}
+		out[i] = item
+	}
+
+	if committed {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{"committed": committed, "failed": failed, "results": out})
+}
+
+// Import loads a CSV or NDJSON file, sent as the request body or as the multipart field "file".
+// Small files are imported right away; larger ones start a job whose report is polled at
+// /api/jobs/:id.
+func (h *Handler) Import(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+	resource := c.Param("resource")
+
+	opts := ImportOptions{
+		Format:          c.Query("format"),
+		Key:             c.Query("key"),
+		Mapping:         c.QueryMap("mapping"),
+		DryRun:          c.Query("dry_run") == "true",
+		ContinueOnError: c.Query("continue_on_error") == "true",
+		Strict:          h.strictWrites(c),
+	}
+	if err := h.Service.AuthorizeImport(resource, claims, opts); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+	body := io.Reader(c.Request.Body)
+	name := ""
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "file is required"})
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer f.Close()
+		body, name = f, fh.Filename
+	}
+	if opts.Format == "" {
+		opts.Format = importFormat(c.ContentType(), name)
+	}
+
+	src, err := ParseImport(opts.Format, body)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if len(src.Rows) <= importSyncRows {
+		result, err := h.Service.Import(resource, src, opts, claims, nil)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, importReport(result))
+		return
+	}
+
+	j, err := h.Jobs.Start("import", claims.ID, len(src.Rows), func(progress func(int)) (interface{}, error) {
+		result, err := h.Service.Import(resource, src, opts, claims, progress)
+		if err != nil {
+			return nil, err
+		}
+		return importReport(result), nil
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("Location", "/api/jobs/"+j.ID)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Import started", "job_id": j.ID, "total": j.Total})
+}
+
+// importFormat guesses the format from the content type or file name
+func importFormat(contentType, filename string) string {
+	filename = strings.ToLower(filename)
+	switch {
+	case contentType == "text/csv", strings.HasSuffix(filename, ".csv"):
+		return FormatCSV
+	case contentType == ndjsonContentType, strings.HasSuffix(filename, ".ndjson"), strings.HasSuffix(filename, ".jsonl"):
+		return FormatNDJSON
+	}
+	return ""
+}
+
+// importReport is the per-row report of an import; errors carry the status and body the row
+// would have got as a single request
+func importReport(res *ImportResult) gin.H {
+	errs := make([]gin.H, len(res.Errors))
+	for i, rowErr := range res.Errors {
+		code, body := errorResponse(rowErr.Err)
+		item := gin.H{"line": rowErr.Line, "status": code}
+		for k, v := range body {
+			item[k] = v
+		}
+		errs[i] = item
+	}
+	return gin.H{
+		"dry_run":         res.DryRun,
+		"committed":       res.Committed,
+		"total":           res.Total,
+		"created":         res.Created,
+		"updated":         res.Updated,
+		"failed":          len(res.Errors),
+		"ignored_columns": res.IgnoredColumns,
+		"errors":          errs,
+	}
+}
+
+// Export downloads the rows matching the list query parameters as csv, xlsx or json.
+// Small exports are sent right away; larger ones start a job whose result holds a signed
+// download link valid for exportLinkTTL.
+func (h *Handler) Export(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+	resource := c.Param("resource")
+
+	format := c.DefaultQuery("format", FormatCSV)
+	if _, ok := ExportContentTypes[format]; !ok {
+		respondError(c, &QueryError{Message: "format must be csv, xlsx or json"})
+		return
+	}
+	q, err := ParseListQuery(c.Request.URL.Query())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	export, err := h.Service.PlanExport(resource, claims, q)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	filename := resource + "." + format
+
+	if export.Rows <= exportSyncRows {
+		c.Header("Content-Type", ExportContentTypes[format])
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Status(http.StatusOK)
+		if n, err := export.WriteTo(format, c.Writer); err != nil {
+			// Headers are already sent; all we can do is cut the download short
+			log.Printf("Export of %s aborted after %d rows: %v", resource, n, err)
+		}
+		return
+	}
+
+	j, err := h.Jobs.Start("export", claims.ID, export.Rows, func(progress func(int)) (interface{}, error) {
+		export.Progress = progress
+		return writeExportFile(export, format, filename)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("Location", "/api/jobs/"+j.ID)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Export started", "job_id": j.ID, "total": j.Total})
+}
+
+// exportLink is the payload of a signed download link. Kind keeps tokens signed for other
+// purposes, such as cursors and attachment links, from being accepted.
+type exportLink struct {
+	Kind    string `json:"k"`
+	File    string `json:"f"`
+	Name    string `json:"n"`
+	Format  string `json:"t"`
+	Expires int64  `json:"e"`
+}
+
+// writeExportFile writes a background export and returns the job result with its download link
+func writeExportFile(export *Export, format, filename string) (gin.H, error) {
+	if err := os.MkdirAll(exportDir, 0o700); err != nil {
+		return nil, err
+	}
+	removeExpiredExports()
+
+	f, err := os.CreateTemp(exportDir, "export-*."+format)
+	if err != nil {
+		return nil, err
+	}
+	n, err := export.WriteTo(format, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	expires := time.Now().Add(exportLinkTTL)
+	payload, err := json.Marshal(exportLink{Kind: exportLinkKind, File: filepath.Base(f.Name()), Name: filename, Format: format, Expires: expires.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"rows":         n,
+		"download_url": "/api/exports/" + utils.SignPayload(payload),
+		"expires_at":   expires.UTC(),
+	}, nil
+}
+
+// removeExpiredExports deletes files whose links can no longer be valid
+func removeExpiredExports() {
+	entries, err := os.ReadDir(exportDir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-exportLinkTTL - time.Minute)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(exportDir, e.Name()))
+		}
+	}
+}
+
+// DownloadExport serves a background export. The signed link is the only credential, so it
+// works from a plain browser download.
+func (h *Handler) DownloadExport(c *gin.Context) {
+	payload, err := utils.VerifyPayload(c.Param("token"))
+	var link exportLink
+	if err == nil {
+		err = json.Unmarshal(payload, &link)
+	}
+	if err != nil || link.Kind != exportLinkKind || link.File == "" || link.File != filepath.Base(link.File) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "export not found"})
+		return
+	}
+	if time.Now().Unix() > link.Expires {
+		c.JSON(http.StatusGone, gin.H{"message": "download link has expired"})
+		return
+	}
+
+	path := filepath.Join(exportDir, link.File)
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "export not found"})
+		return
+	}
+	c.Header("Content-Type", ExportContentTypes[link.Format])
+	c.FileAttachment(path, link.Name)
+}
+
+// Lookup lists id/label pairs for dropdowns (?label=name&q=<prefix>&limit=20)
+func (h *Handler) Lookup(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			respondError(c, &QueryError{Message: "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	items, err := h.Service.Lookup(c.Param("resource"), claims, c.Query("label"), c.Query("q"), limit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// Aggregate returns count/sum/avg/min/max per group (?metrics=count,sum:amount&group_by=status)
+func (h *Handler) Aggregate(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	q, err := ParseAggregateQuery(c.Request.URL.Query())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	result, err := h.Service.Aggregate(c.Param("resource"), claims, q)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Search looks for ?q= in every resource the caller can read (?limit= hits per resource)
+func (h *Handler) Search(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			respondError(c, &QueryError{Message: "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	groups, err := h.Service.Search(c.Query("q"), claims, limit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// GetTrash lists soft-deleted records; same query parameters and headers as GetAll
+func (h *Handler) GetTrash(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	resource := c.Param("resource")
+	q, err := ParseListQuery(c.Request.URL.Query())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	page, err := h.Service.GetTrash(resource, claims, q)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if page.NextCursor != "" {
+		c.Header("X-Next-Cursor", page.NextCursor)
+	}
+	if page.Total != nil {
+		c.Header("X-Total-Count", strconv.Itoa(*page.Total))
+	}
+	c.JSON(http.StatusOK, page.Data)
+}
+
+func (h *Handler) Restore(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	err := h.Service.Restore(c.Param("resource"), c.Param("id"), claims)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Restored"})
+}
+
+func (h *Handler) Purge(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	err := h.Service.Purge(c.Param("resource"), c.Param("id"), claims)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Purged"})
+}
+
+func (h *Handler) GetHistory(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	entries, err := h.Service.GetHistory(c.Param("resource"), c.Param("id"), claims)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+func (h *Handler) Transitions(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	transitions, err := h.Service.Transitions(c.Param("resource"), c.Param("id"), claims)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, transitions)
+}
+
+func (h *Handler) Revert(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	var req RevertRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.HistoryID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "history_id is required"})
+		return
+	}
+
+	pre := ParsePrecondition(c.GetHeader("If-Match"))
+	result, err := h.Service.Revert(c.Param("resource"), c.Param("id"), req.HistoryID, claims, pre)
+	if errors.Is(err, ErrNothingToUpdate) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "record already matches that version"})
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	resp := gin.H{"message": "Reverted"}
+	if result.Version > 0 {
+		resp["version"] = result.Version
+		c.Header("ETag", ETag(result.Version))
+	}
+	if result.PendingChange > 0 {
+		resp["pending_change_id"] = result.PendingChange
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) PendingChanges(c *gin.Context) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	changes, err := h.Service.PendingChanges(c.Param("resource"), claims)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+func (h *Handler) ApproveChange(c *gin.Context) {
+	h.reviewChange(c, true)
+}
+
+func (h *Handler) RejectChange(c *gin.Context) {
+	h.reviewChange(c, false)
+}
+
+func (h *Handler) reviewChange(c *gin.Context, approve bool) {
+	user, _ := c.Get("user")
+	claims := user.(*utils.Claims)
+
+	change, err := h.Service.ReviewChange(c.Param("resource"), c.Param("change_id"), approve, claims)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, change)
+}
+
+// strictWrites resolves the write mode: ?mode= or X-Write-Mode overrides the server default
+func (h *Handler) strictWrites(c *gin.Context) bool {
+	mode := c.Query("mode")
+	if mode == "" {
+		mode = c.GetHeader("X-Write-Mode")
+	}
+	switch mode {
+	case "strict":
+		return true
+	case "lenient":
+		return false
+	default:
+		return !h.Service.LenientWrites
+	}
+}
+
+// respondError maps service errors to HTTP status codes
+func respondError(c *gin.Context, err error) {
+	var staleErr *PreconditionFailedError
+	if errors.As(err, &staleErr) {
+		c.Header("ETag", ETag(staleErr.Version))
+	}
+	c.JSON(errorResponse(err))
+}
+
+// errorResponse returns the status and body for an error; batches report them per operation
+func errorResponse(err error) (int, gin.H) {
+	var queryErr *QueryError
+	var unknownErr *UnknownFieldError
+	var validationErr *ValidationError
+	var pqErr *pq.Error
+	var rejectedErr *RejectedFieldsError
+	var staleErr *PreconditionFailedError
+	switch {
+	case errors.As(err, &rejectedErr):
+		return http.StatusForbidden, gin.H{"message": err.Error(), "rejected_fields": rejectedErr.Fields}
+	case errors.Is(err, ErrPermissionDenied):
+		return http.StatusForbidden, gin.H{"message": err.Error()}
+	case errors.As(err, &queryErr), errors.Is(err, ErrNoTrash), errors.Is(err, ErrNothingToUpdate):
+		return http.StatusBadRequest, gin.H{"message": err.Error()}
+	case errors.As(err, &unknownErr):
+		return http.StatusBadRequest, gin.H{"message": err.Error(), "fields": unknownErr.Fields}
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity, gin.H{"message": err.Error(), "errors": validationErr.Errors}
+	case errors.As(err, &staleErr):
+		// The client gets the current state so it can merge and retry with the new version
+		return http.StatusPreconditionFailed, gin.H{"message": err.Error(), "version": staleErr.Version, "current": staleErr.Current}
+	case errors.Is(err, ErrChangeReviewed), errors.Is(err, ErrChangeStale):
+		return http.StatusConflict, gin.H{"message": err.Error()}
+	case errors.Is(err, ErrPreconditionRequired):
+		return http.StatusPreconditionRequired, gin.H{"message": err.Error()}
+	case errors.Is(err, ErrNotFound), errors.Is(err, registry.ErrNotFound):
+		return http.StatusNotFound, gin.H{"message": err.Error()}
+	case errors.As(err, &pqErr) && (pqErr.Code.Class() == "22" || pqErr.Code.Class() == "23"):
+		// Data exceptions and constraint violations are the client's input, not a server fault
+		return http.StatusUnprocessableEntity, gin.H{"message": pqErr.Message}
+	default:
+		log.Printf("Resource request failed: %v", err)
+		return http.StatusInternalServerError, gin.H{"message": "internal server error"}
+	}
+}
@@ -0,0 +1,343 @@
+package resource
+
+// Bulk import from CSV or NDJSON. Source columns are mapped to resource fields, and every row
+// becomes a create (or, with a natural key, an update of the record holding that key). Rows run
+// in one transaction with the same savepoints as batches; a dry run rolls everything back and
+// only reports what would have happened.
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"server/internal/config"
+	"server/pkg/utils"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Import formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// ImportOptions control how rows are mapped and written
+type ImportOptions struct {
+	Format          string
+	Key             string            // field identifying existing records for upsert; "" always creates
+	Mapping         map[string]string // source column -> field; other columns are matched by name
+	DryRun          bool
+	ContinueOnError bool
+	Strict          bool
+}
+
+// ImportRow is one parsed data row; Line is its line number in the source
+type ImportRow struct {
+	Line int
+	Data map[string]interface{}
+}
+
+// ImportSource is a parsed file
+type ImportSource struct {
+	Columns []string
+	Rows    []ImportRow
+}
+
+// ImportResult summarizes an import; row errors are turned into statuses by the handler
+type ImportResult struct {
+	DryRun         bool
+	Committed      bool
+	Total          int
+	Created        int
+	Updated        int
+	IgnoredColumns []string
+	Errors         []ImportRowError
+}
+
+type ImportRowError struct {
+	Line int
+	Err  error
+}
+
+// ParseImport reads a CSV file (header row first) or NDJSON (one object per line)
+func ParseImport(format string, r io.Reader) (*ImportSource, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(r)
+	case FormatNDJSON:
+		return parseNDJSON(r)
+	}
+	return nil, &QueryError{Message: "format must be csv or ndjson"}
+}
+
+func parseCSV(r io.Reader) (*ImportSource, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // short rows are padded, long rows reported below
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, &QueryError{Message: "the file is empty"}
+	}
+	if err != nil {
+		return nil, &QueryError{Message: "invalid CSV: " + err.Error()}
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff") // spreadsheet exports often start with a BOM
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	src := &ImportSource{Columns: header}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &QueryError{Message: "invalid CSV: " + err.Error()}
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) > len(header) {
+			return nil, &QueryError{Message: fmt.Sprintf("line %d has more values than the header", line)}
+		}
+
+		// Empty cells are left out, so they keep the stored value (or the column default)
+		data := map[string]interface{}{}
+		for i, v := range record {
+			if v != "" {
+				data[header[i]] = v
+			}
+		}
+		src.Rows = append(src.Rows, ImportRow{Line: line, Data: data})
+	}
+	return src, nil
+}
+
+func parseNDJSON(r io.Reader) (*ImportSource, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	src := &ImportSource{}
+	seen := map[string]bool{}
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal(text, &data); err != nil || data == nil {
+			return nil, &QueryError{Message: fmt.Sprintf("line %d is not a JSON object", line)}
+		}
+		for k := range data {
+			if !seen[k] {
+				seen[k] = true
+				src.Columns = append(src.Columns, k)
+			}
+		}
+		src.Rows = append(src.Rows, ImportRow{Line: line, Data: data})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &QueryError{Message: "invalid NDJSON: " + err.Error()}
+	}
+	sort.Strings(src.Columns)
+	return src, nil
+}
+
+// columnMapping resolves source columns to fields: explicit mappings first, then the field
+// whose name matches the column ("Start Date" matches start_date). Unmatched columns are ignored.
+func columnMapping(schema *tableSchema, columns []string, explicit map[string]string) (map[string]string, []string, error) {
+	taken := map[string]bool{}
+	for col, field := range explicit {
+		if !schema.writable[field] && field != "id" {
+			return nil, nil, &QueryError{Message: fmt.Sprintf("mapping for %q: unknown field %q", col, field)}
+		}
+		taken[field] = true
+	}
+
+	mapping := map[string]string{}
+	ignored := []string{}
+	for _, col := range columns {
+		if field, ok := explicit[col]; ok {
+			mapping[col] = field
+			continue
+		}
+		name := strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(col))
+		if (schema.writable[name] || name == "id") && !taken[name] {
+			mapping[col] = name
+			continue
+		}
+		ignored = append(ignored, col)
+	}
+	return mapping, ignored, nil
+}
+
+// Import writes (or, in a dry run, validates) the rows of src. progress is called after each row.
+func (r *Repository) Import(resource string, src *ImportSource, opts ImportOptions, user *utils.Claims, progress func(int)) (*ImportResult, error) {
+	w, err := r.newWriter(resource, user)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Key != "" && opts.Key != "id" && !w.schema.writable[opts.Key] {
+		return nil, &QueryError{Message: fmt.Sprintf("key: unknown field %q", opts.Key)}
+	}
+	// Whether a key value matches a record must not reveal values the caller cannot see
+	viewFields, _, _, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Key != "" && !canView(viewFields, opts.Key) {
+		return nil, fmt.Errorf("%w: cannot use field %q as key", ErrPermissionDenied, opts.Key)
+	}
+
+	mapping, ignored, err := columnMapping(w.schema, src.Columns, opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	// Check the mapped fields once so a file with a column the role can never write fails before
+	// any row (strict), or the column is dropped for every row (lenient). With a key, rows may
+	// update records too, so either write permission will do here; each row is checked again.
+	rejected := []string{}
+	for _, field := range mapping {
+		if field != "id" && !w.canWrite(field, true) && (opts.Key == "" || !w.canWrite(field, false)) {
+			rejected = append(rejected, field)
+		}
+	}
+	sort.Strings(rejected)
+	if opts.Strict && len(rejected) > 0 {
+		return nil, &RejectedFieldsError{Fields: rejected}
+	}
+	for col, field := range mapping {
+		for _, f := range rejected {
+			if f == field && field != opts.Key { // the key is still needed to find records
+				delete(mapping, col)
+				ignored = append(ignored, col)
+			}
+		}
+	}
+	sort.Strings(ignored)
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{DryRun: opts.DryRun, Total: len(src.Rows), IgnoredColumns: ignored, Errors: []ImportRowError{}}
+	for i, row := range src.Rows {
+		data := map[string]interface{}{}
+		for col, v := range row.Data {
+			if field, ok := mapping[col]; ok {
+				data[field] = v
+			}
+		}
+
+		var created bool
+		rowErr, err := inSavepoint(tx, func() error {
+			var err error
+			created, err = r.importRow(tx, w, data, opts)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case rowErr != nil:
+			result.Errors = append(result.Errors, ImportRowError{Line: row.Line, Err: rowErr})
+		case created:
+			result.Created++
+		default:
+			result.Updated++
+		}
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+
+	if opts.DryRun || (len(result.Errors) > 0 && !opts.ContinueOnError) {
+		return result, nil // deferred rollback
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	result.Committed = true
+	return result, nil
+}
+
+// importRow creates the row, or updates the record whose key matches. It reports whether a
+// record was created.
+func (r *Repository) importRow(tx *sql.Tx, w *writer, data map[string]interface{}, opts ImportOptions) (bool, error) {
+	keyValue, hasKey := data[opts.Key]
+	delete(data, "id") // ids only identify records, they are never written
+	if opts.Key == "" || !hasKey || isEmpty(keyValue) {
+		_, err := r.create(tx, w, data, opts.Strict)
+		return true, err
+	}
+
+	id, err := r.findByKey(tx, w, opts.Key, keyValue)
+	if err != nil {
+		return false, err
+	}
+	if id == "" {
+		_, err := r.create(tx, w, data, opts.Strict)
+		return true, err
+	}
+	if len(data) == 0 {
+		return false, nil // nothing but the key: the record is already there
+	}
+	_, err = r.updateRecord(tx, w, id, data, opts.Strict, nil)
+	return false, err
+}
+
+// findByKey returns the id of the live record holding a key value, or "" if there is none.
+// Only records the caller can read and update are matched, so others look like no match.
+// Keys need not be unique in the table, but an import can only update one record per row.
+func (r *Repository) findByKey(tx *sql.Tx, w *writer, key string, value interface{}) (string, error) {
+	schema := w.schema
+	query := fmt.Sprintf(`SELECT "id" FROM %s WHERE %s = $1`, schema.table, quoteIdent(key))
+	if live := schema.liveOnly(); live != "" {
+		query += " AND " + live
+	}
+	args := []interface{}{value}
+	for _, action := range []string{ScopeRead, ScopeUpdate} {
+		scope, scopeArgs, err := r.scope(w, action, len(args)+1)
+		if err != nil {
+			return "", err
+		}
+		if scope != "" {
+			query += " AND " + scope
+			args = append(args, scopeArgs...)
+		}
+	}
+	rows, err := tx.Query(query+" LIMIT 2", args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	switch len(ids) {
+	case 0:
+		return "", nil
+	case 1:
+		return strconv.Itoa(ids[0]), nil
+	}
+	return "", &QueryError{Message: fmt.Sprintf("%s %v matches more than one record", key, value)}
+}
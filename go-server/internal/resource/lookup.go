@@ -0,0 +1,119 @@
+package resource
+
+// Lookups for dropdowns and typeahead: GET /api/lookup/:resource?label=name&q=al&limit=20
+// returns [{"id": 1, "label": "Alice"}]. The caller needs read permission on the resource and
+// view permission on the label field, and only rows in their read scope are listed.
+
+import (
+	"fmt"
+	"server/internal/config"
+	"server/pkg/utils"
+	"strings"
+)
+
+const (
+	defaultLookupLimit = 20
+	maxLookupLimit     = 100
+	defaultLookupLabel = "name"
+)
+
+// System resources that can be looked up although the data API does not serve them, with the
+// condition their rows must meet
+var systemLookups = map[string]string{
+	"users": `"status" = 'Active'`, // invited and declined users cannot be picked
+}
+
+type LookupItem struct {
+	ID    int         `json:"id"`
+	Label interface{} `json:"label"`
+}
+
+// lookupSchema is schemaFor, extended to the system resources in systemLookups. Their tables
+// are not inspected, so the registered fields are trusted to exist.
+func (r *Repository) lookupSchema(resource string) (*tableSchema, string, error) {
+	res, err := r.Registry.Get(resource)
+	if err != nil {
+		return nil, "", err
+	}
+	cond, ok := systemLookups[resource]
+	if !res.IsSystem || !ok {
+		schema, err := r.schemaFor(resource)
+		return schema, "", err
+	}
+
+	s := &tableSchema{resource: res, table: quoteIdent(res.Name), types: map[string]string{"id": "number"}, writable: map[string]bool{}}
+	for _, f := range res.Fields {
+		s.types[f.Name] = f.DataType
+	}
+	return s, cond, nil
+}
+
+// Lookup lists id/label pairs ordered by label; prefix matches the start of the label, ignoring case
+func (r *Repository) Lookup(resource string, user *utils.Claims, label, prefix string, limit int) ([]LookupItem, error) {
+	if label == "" {
+		label = defaultLookupLabel
+	}
+	if limit <= 0 {
+		limit = defaultLookupLimit
+	}
+	if limit > maxLookupLimit {
+		limit = maxLookupLimit
+	}
+
+	schema, cond, err := r.lookupSchema(resource)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := schema.types[label]; !ok || label == "id" {
+		return nil, &QueryError{Message: fmt.Sprintf("unknown label field %q", label)}
+	}
+	viewFields, _, _, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+	if !canView(viewFields, label) {
+		return nil, fmt.Errorf("%w: cannot view field %q", ErrPermissionDenied, label)
+	}
+
+	conds := []string{}
+	args := []interface{}{}
+	for _, c := range []string{cond, schema.liveOnly()} {
+		if c != "" {
+			conds = append(conds, c)
+		}
+	}
+	col := quoteIdent(label)
+	if prefix != "" {
+		args = append(args, escapeLike(prefix)+"%")
+		conds = append(conds, fmt.Sprintf("%s::text ILIKE $%d", col, len(args)))
+	}
+	scope, scopeArgs, err := r.rowScope(schema, user, ScopeRead, len(args)+1)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" {
+		conds = append(conds, scope)
+		args = append(args, scopeArgs...)
+	}
+
+	query := fmt.Sprintf(`SELECT "id", %s AS "label" FROM %s`, col, schema.table)
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += fmt.Sprintf(` ORDER BY %s NULLS LAST, "id" LIMIT %d`, col, limit)
+
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	items := []LookupItem{}
+	err = scanRows(rows, func(row map[string]interface{}) error {
+		id, _ := toRecordID(row["id"])
+		items = append(items, LookupItem{ID: int(id), Label: row["label"]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,67 @@
+package resource
+
+// Masking of fields a role cannot view. The mode comes from role_field_permissions.mask_mode;
+// masked values are computed here so the raw value never leaves the repository.
+
+import (
+	"fmt"
+	"math"
+	"server/internal/permission"
+	"strings"
+)
+
+const redacted = "***"
+
+// maskValue applies a masking mode to one value. NULL stays NULL so clients can tell "empty" apart.
+func maskValue(mode string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	switch mode {
+	case permission.MaskPartial:
+		s := []rune(exportText(v))
+		if len(s) <= 4 {
+			return redacted
+		}
+		return redacted + string(s[len(s)-4:])
+
+	case permission.MaskBucket:
+		n, ok := toNumber(v)
+		if !ok {
+			return redacted
+		}
+		return bucket(n)
+	}
+	return redacted
+}
+
+// bucket formats the range n falls into. The width scales with the magnitude of n so that
+// a salary of 62000 becomes "50k–75k" and an amount of 120 becomes "0–250".
+func bucket(n float64) string {
+	abs := math.Abs(n)
+	width := 10.0
+	if abs >= 10 {
+		digits := math.Floor(math.Log10(abs)) + 1
+		width = 25 * math.Pow(10, digits-2)
+	}
+
+	low := math.Floor(n/width) * width
+	return formatAmount(low) + "–" + formatAmount(low+width)
+}
+
+func formatAmount(n float64) string {
+	abs := math.Abs(n)
+	switch {
+	case abs >= 1e6:
+		return trimZeros(n/1e6) + "M"
+	case abs >= 1e3:
+		return trimZeros(n/1e3) + "k"
+	}
+	return trimZeros(n)
+}
+
+func trimZeros(n float64) string {
+	s := fmt.Sprintf("%.1f", n)
+	return strings.TrimSuffix(s, ".0")
+}
@@ -0,0 +1,54 @@
+package resource
+
+import (
+	"encoding/json"
+	"server/internal/permission"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestMaskValue(t *testing.T) {
+	tests := []struct {
+		mode string
+		in   interface{}
+		want interface{}
+	}{
+		{permission.MaskRedact, "secret", redacted},
+		{permission.MaskRedact, 62000.0, redacted},
+		{permission.MaskRedact, nil, nil},
+		{permission.MaskPartial, "4111111111111111", "***1111"},
+		{permission.MaskPartial, "Zoë Müller", "***ller"},
+		{permission.MaskPartial, "1234", redacted},
+		{permission.MaskPartial, int64(123456), "***3456"},
+		{permission.MaskPartial, nil, nil},
+		{permission.MaskBucket, 62000.0, "50k–75k"},
+		{permission.MaskBucket, json.Number("62000.0000"), "50k–75k"},
+		{permission.MaskBucket, int64(120), "0–250"},
+		{permission.MaskBucket, 7.0, "0–10"},
+		{permission.MaskBucket, -7.0, "-10–0"},
+		{permission.MaskBucket, 1250000.0, "0–2.5M"},
+		{permission.MaskBucket, 99.0, "75–100"},
+		{permission.MaskBucket, "not a number", redacted},
+		{"unknown", "secret", redacted},
+	}
+	for _, tt := range tests {
+		if got := maskValue(tt.mode, tt.in); got != tt.want {
+			t.Errorf("%s %#v: got %#v, want %#v", tt.mode, tt.in, got, tt.want)
+		}
+	}
+}
+
+// Property: a partial mask reveals at most the last 4 characters
+func TestMaskPartialProperty(t *testing.T) {
+	partial := func(s string) bool {
+		got := maskValue(permission.MaskPartial, s).(string)
+		revealed := strings.TrimPrefix(got, redacted)
+		runes := []rune(s)
+		return strings.HasPrefix(got, redacted) && len([]rune(revealed)) <= 4 &&
+			(len(runes) <= 4 || revealed == string(runes[len(runes)-4:]))
+	}
+	if err := quick.Check(partial, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNotFound         = errors.New("record not found")
+	ErrNoTrash          = errors.New("soft delete is not enabled for this resource")
+	ErrNothingToUpdate  = errors.New("no writable fields in request")
+	ErrRolledBack       = errors.New("not applied: another operation in the batch failed")
+	ErrChangeReviewed   = errors.New("change has already been reviewed")
+	ErrChangeStale      = errors.New("record has changed since the change was requested")
+)
+
+// QueryError reports a malformed list query (unknown field, bad operator, unparsable value)
+type QueryError struct {
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return e.Message
+}
+
+// UnknownFieldError reports request keys that are not columns of the resource
+type UnknownFieldError struct {
+	Fields []string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("unknown fields: %s", strings.Join(e.Fields, ", "))
+}
+
+// RejectedFieldsError is returned in strict write mode when the body contains fields
+// the caller may not write (not editable for the role, or not a column at all)
+type RejectedFieldsError struct {
+	Fields []string
+}
+
+func (e *RejectedFieldsError) Error() string {
+	return fmt.Sprintf("permission denied: cannot write fields: %s", strings.Join(e.Fields, ", "))
+}
+
+func (e *RejectedFieldsError) Unwrap() error {
+	return ErrPermissionDenied
+}
+
+// WriteResult reports the outcome of a create or update
+type WriteResult struct {
+	ID            int
+	Version       int      // 0 if the resource is not versioned
+	IgnoredFields []string // only populated in lenient mode
+	PendingChange int      // id of the change held for approval, 0 if none
+}
+
+// Page is one page of a list response
+type Page struct {
+	Data       []map[string]interface{}
+	NextCursor string
+	Total      *int
+}
+
+// Record is a single-record response, with the fields the caller may change
+type Record struct {
+	Data           map[string]interface{} `json:"data"`
+	EditableFields []string               `json:"editable_fields"`
+	PendingChanges []PendingChange        `json:"pending_changes,omitempty"` // sensitive field changes awaiting approval
+}
+
+// RevertRequest selects the history entry whose version a record is reverted to
+type RevertRequest struct {
+	HistoryID int `json:"history_id"`
+}
@@ -0,0 +1,291 @@
+package resource
+
+// List query language for GET /api/data/:resource
+//
+//	?filter[status]=open&filter[budget][gte]=1000&sort=-created_at&fields=name,status
+//	?limit=50&after=<cursor>&count=true&expand=assigned_to
+//
+// Field names are checked against resource_fields and the caller's view permissions
+// before any SQL is built; values are always passed as parameters.
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxInValues = 100
+
+	DefaultPageSize = 100
+	MaxPageSize     = 500
+)
+
+// Columns every resource table has, regardless of resource_fields
+var systemFields = map[string]string{
+	"id":         "number",
+	"created_at": "datetime",
+	"version":    "number",
+}
+
+var sqlOperators = map[string]string{
+	"eq":  "=",
+	"ne":  "<>",
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+type Filter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+type ListQuery struct {
+	Filters []Filter
+	Sort    []SortField
+	Fields  []string
+	Expand  []string // reference fields to replace by the referenced record
+
+	Limit     int    // 0 means "not specified"
+	After     string // opaque cursor from a previous page
+	WithCount bool
+}
+
+// ParseListQuery extracts filter[...], sort and fields from the request query string.
+// It only checks syntax; field names and operators are validated against metadata later.
+func ParseListQuery(values url.Values) (*ListQuery, error) {
+	q := &ListQuery{}
+
+	for key, vals := range values {
+		if !strings.HasPrefix(key, "filter[") {
+			continue
+		}
+		field, op, err := parseFilterKey(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vals {
+			q.Filters = append(q.Filters, Filter{Field: field, Op: op, Value: v})
+		}
+	}
+
+	if s := values.Get("sort"); s != "" {
+		for _, part := range strings.Split(s, ",") {
+			part = strings.TrimSpace(part)
+			desc := strings.HasPrefix(part, "-")
+			part = strings.TrimPrefix(part, "-")
+			if part == "" {
+				return nil, &QueryError{Message: "invalid sort parameter"}
+			}
+			q.Sort = append(q.Sort, SortField{Field: part, Desc: desc})
+		}
+	}
+
+	if f := values.Get("fields"); f != "" {
+		for _, part := range strings.Split(f, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				return nil, &QueryError{Message: "invalid fields parameter"}
+			}
+			q.Fields = append(q.Fields, part)
+		}
+	}
+
+	if e := values.Get("expand"); e != "" {
+		for _, part := range strings.Split(e, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				return nil, &QueryError{Message: "invalid expand parameter"}
+			}
+			q.Expand = append(q.Expand, part)
+		}
+	}
+
+	if l := values.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			return nil, &QueryError{Message: "limit must be a positive integer"}
+		}
+		q.Limit = limit
+	}
+	q.After = values.Get("after")
+	q.WithCount = values.Get("count") == "true"
+
+	return q, nil
+}
+
+// pageSize applies the default and the server-enforced maximum
+func (q *ListQuery) pageSize() int {
+	if q.Limit == 0 {
+		return DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return q.Limit
+}
+
+// parseFilterKey splits "filter[budget][gte]" into ("budget", "gte"); the operator defaults to eq
+func parseFilterKey(key string) (string, string, error) {
+	rest := strings.TrimPrefix(key, "filter[")
+	end := strings.Index(rest, "]")
+	if end <= 0 {
+		return "", "", &QueryError{Message: fmt.Sprintf("invalid filter parameter %q", key)}
+	}
+	field := rest[:end]
+	rest = rest[end+1:]
+
+	if rest == "" {
+		return field, "eq", nil
+	}
+	if !strings.HasPrefix(rest, "[") || !strings.HasSuffix(rest, "]") || len(rest) < 3 {
+		return "", "", &QueryError{Message: fmt.Sprintf("invalid filter parameter %q", key)}
+	}
+	return field, rest[1 : len(rest)-1], nil
+}
+
+// validate checks that every referenced field exists and is viewable by the caller
+func (q *ListQuery) validate(types map[string]string, viewFields map[string]bool) error {
+	check := func(field, usage string) error {
+		if _, ok := types[field]; !ok {
+			return &QueryError{Message: fmt.Sprintf("unknown field %q", field)}
+		}
+		if !canView(viewFields, field) {
+			return fmt.Errorf("%w: cannot %s on field %q", ErrPermissionDenied, usage, field)
+		}
+		return nil
+	}
+
+	for _, f := range q.Filters {
+		if err := check(f.Field, "filter"); err != nil {
+			return err
+		}
+		if !typeOf(types[f.Field]).operators[f.Op] {
+			return &QueryError{Message: fmt.Sprintf("operator %q is not supported on field %q", f.Op, f.Field)}
+		}
+	}
+	for _, s := range q.Sort {
+		if err := check(s.Field, "sort"); err != nil {
+			return err
+		}
+		if types[s.Field] == "json" {
+			return &QueryError{Message: fmt.Sprintf("cannot sort on JSON field %q", s.Field)}
+		}
+	}
+	for _, f := range q.Fields {
+		if err := check(f, "select"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildWhere compiles the filters into a parameterized WHERE clause (without the keyword).
+// Placeholders are numbered from start.
+func (q *ListQuery) buildWhere(types map[string]string, start int) (string, []interface{}, error) {
+	conds := []string{}
+	args := []interface{}{}
+	n := start
+
+	for _, f := range q.Filters {
+		dataType := types[f.Field]
+
+		switch f.Op {
+		case "null":
+			isNull, err := strconv.ParseBool(f.Value)
+			if err != nil {
+				return "", nil, &QueryError{Message: fmt.Sprintf("filter[%s][null] expects true or false", f.Field)}
+			}
+			if isNull {
+				conds = append(conds, fmt.Sprintf("%s IS NULL", quoteIdent(f.Field)))
+			} else {
+				conds = append(conds, fmt.Sprintf("%s IS NOT NULL", quoteIdent(f.Field)))
+			}
+
+		case "like":
+			conds = append(conds, fmt.Sprintf("%s ILIKE $%d", quoteIdent(f.Field), n))
+			args = append(args, "%"+escapeLike(f.Value)+"%")
+			n++
+
+		case "in":
+			raw := strings.Split(f.Value, ",")
+			if len(raw) > maxInValues {
+				return "", nil, &QueryError{Message: fmt.Sprintf("filter[%s][in] accepts at most %d values", f.Field, maxInValues)}
+			}
+			placeholders := []string{}
+			for _, v := range raw {
+				parsed, err := parseFilterValue(dataType, strings.TrimSpace(v))
+				if err != nil {
+					return "", nil, &QueryError{Message: fmt.Sprintf("filter[%s][in]: %v", f.Field, err)}
+				}
+				placeholders = append(placeholders, fmt.Sprintf("$%d", n))
+				args = append(args, parsed)
+				n++
+			}
+			conds = append(conds, fmt.Sprintf("%s IN (%s)", quoteIdent(f.Field), strings.Join(placeholders, ", ")))
+
+		default:
+			parsed, err := parseFilterValue(dataType, f.Value)
+			if err != nil {
+				return "", nil, &QueryError{Message: fmt.Sprintf("filter[%s][%s]: %v", f.Field, f.Op, err)}
+			}
+			conds = append(conds, fmt.Sprintf("%s %s $%d", quoteIdent(f.Field), sqlOperators[f.Op], n))
+			args = append(args, parsed)
+			n++
+		}
+	}
+
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// orderFields returns the effective sort order, always ending with id so keyset pagination is stable
+func (q *ListQuery) orderFields() []SortField {
+	order := []SortField{}
+	hasID := false
+	for _, s := range q.Sort {
+		order = append(order, s)
+		if s.Field == "id" {
+			hasID = true
+			break // id is unique, later keys can never break a tie
+		}
+	}
+	if !hasID {
+		order = append(order, SortField{Field: "id"})
+	}
+	return order
+}
+
+func buildOrderBy(order []SortField) string {
+	parts := []string{}
+	for _, s := range order {
+		dir := "ASC"
+		if s.Desc {
+			dir = "DESC"
+		}
+		parts = append(parts, quoteIdent(s.Field)+" "+dir)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseFilterValue parses a query string value like a written value of the field's type
+func parseFilterValue(dataType, raw string) (interface{}, error) {
+	v, err := typeOf(dataType).parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%q %v", raw, err)
+	}
+	return v, nil
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
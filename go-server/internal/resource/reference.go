@@ -0,0 +1,163 @@
+package resource
+
+// Reference fields hold the id of a record of another resource (resource_fields.ref_resource).
+// Writes must point at a live record the caller can read; reads can embed the referenced record
+// with ?expand=, seen through the caller's own permissions on the target resource.
+
+import (
+	"errors"
+	"fmt"
+	"server/internal/history"
+	"server/internal/registry"
+	"server/pkg/utils"
+	"strconv"
+	"strings"
+)
+
+// checkReferences reports reference values in data that do not point at a live record the
+// writer can read. A record outside the caller's read permission or row scope gets the same
+// error as a missing one, so writes cannot probe for records.
+func (r *Repository) checkReferences(q history.Querier, w *writer, data map[string]interface{}) error {
+	errs := []FieldError{}
+	for _, field := range w.schema.resource.Fields {
+		value, ok := data[field.Name]
+		if !ok || field.DataType != "reference" || isEmpty(value) {
+			continue
+		}
+		id, _ := toRecordID(value) // the format was checked by validateWrite
+
+		exists, err := r.canReference(q, w.user, field.Reference, id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			errs = append(errs, FieldError{field.Name, "reference", fmt.Sprintf("must refer to an existing %s record", field.Reference)})
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// canReference reports whether a live record of a resource exists within what the user may read
+func (r *Repository) canReference(q history.Querier, user *utils.Claims, resource string, id int64) (bool, error) {
+	if allowed, err := r.HasPermission(user.RoleID, resource, "read"); err != nil || !allowed {
+		return false, err
+	}
+	target, err := r.schemaFor(resource)
+	if errors.Is(err, registry.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE "id" = $1`, target.table)
+	if live := target.liveOnly(); live != "" {
+		query += " AND " + live
+	}
+	scope, args, err := r.rowScope(target, user, ScopeRead, 2)
+	if err != nil {
+		return false, err
+	}
+	if scope != "" {
+		query += " AND " + scope
+	}
+	var exists bool
+	err = q.QueryRow(query+")", append([]interface{}{id}, args...)...).Scan(&exists)
+	return exists, err
+}
+
+// Expand replaces the values of reference fields in rows by the referenced records, projected
+// for the caller. A referenced record the caller cannot read (no read permission, outside the
+// row scope, trashed) is replaced by {"id": ...} only.
+func (r *Repository) Expand(resource string, user *utils.Claims, rows []map[string]interface{}, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	schema, err := r.schemaFor(resource)
+	if err != nil {
+		return err
+	}
+	viewFields, _, _, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range fields {
+		field, ok := schema.resource.Field(name)
+		if _, exists := schema.types[name]; !ok || !exists {
+			return &QueryError{Message: fmt.Sprintf("unknown field %q", name)}
+		}
+		if field.DataType != "reference" {
+			return &QueryError{Message: fmt.Sprintf("cannot expand %q: not a reference field", name)}
+		}
+		if !canView(viewFields, name) {
+			return fmt.Errorf("%w: cannot expand field %q", ErrPermissionDenied, name)
+		}
+
+		ids := []string{}
+		seen := map[int64]bool{}
+		for _, row := range rows {
+			if id, ok := toRecordID(row[name]); ok && !seen[id] {
+				seen[id] = true
+				ids = append(ids, strconv.FormatInt(id, 10))
+			}
+		}
+		records, err := r.referencedRecords(field.Reference, user, ids)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			id, ok := toRecordID(row[name])
+			if !ok {
+				continue // null (or masked)
+			}
+			if record, found := records[id]; found {
+				row[name] = record
+			} else {
+				row[name] = map[string]interface{}{"id": id}
+			}
+		}
+	}
+	return nil
+}
+
+// referencedRecords reads records by id as the caller may see them, keyed by id
+func (r *Repository) referencedRecords(resource string, user *utils.Claims, ids []string) (map[int64]map[string]interface{}, error) {
+	records := map[int64]map[string]interface{}{}
+	if len(ids) == 0 {
+		return records, nil
+	}
+	if allowed, _ := r.HasPermission(user.RoleID, resource, "read"); !allowed {
+		return records, nil
+	}
+
+	for start := 0; start < len(ids); start += maxInValues {
+		end := start + maxInValues
+		if end > len(ids) {
+			end = len(ids)
+		}
+		q := &ListQuery{Filters: []Filter{{Field: "id", Op: "in", Value: strings.Join(ids[start:end], ",")}}}
+		plan, err := r.planList(resource, user, q, false)
+		if errors.Is(err, registry.ErrNotFound) {
+			return records, nil // the target is gone or no longer served by the data API
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = r.query(plan, "", 0, func(row map[string]interface{}) error {
+			if id, ok := toRecordID(row["id"]); ok {
+				records[id] = plan.project(row)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
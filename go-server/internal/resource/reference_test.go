@@ -0,0 +1,79 @@
+package resource
+
+import (
+	"database/sql/driver"
+	"errors"
+	"server/internal/config"
+	"server/internal/dbtest"
+	"server/internal/registry"
+	"server/pkg/utils"
+	"testing"
+)
+
+func referenceResources() []registry.Resource {
+	return []registry.Resource{
+		{ID: 1, Name: "orders", Fields: []registry.Field{{ID: 1, Name: "customer_id", DataType: "reference", Reference: "customers"}}},
+		{ID: 2, Name: "customers", Fields: []registry.Field{{ID: 2, Name: "name", DataType: "text"}}},
+	}
+}
+
+// A reference the caller cannot read is reported like a missing one, without looking it up
+func TestCheckReferences(t *testing.T) {
+	user := &utils.Claims{ID: 5, RoleID: 2}
+	readable := func(ok bool) dbtest.Rule {
+		return dbtest.Rule{Match: "role_resource_permissions", Args: []driver.Value{int64(2), "customers"},
+			Columns: []string{"can_view"}, Rows: [][]driver.Value{{ok}}}
+	}
+	exists := func(ok bool) dbtest.Rule {
+		return dbtest.Rule{Match: `SELECT EXISTS (SELECT 1 FROM "customers"`, Columns: []string{"exists"}, Rows: [][]driver.Value{{ok}}}
+	}
+	scope := dbtest.Rule{Match: "FROM role_row_filters", Columns: []string{"expression"}, Rows: [][]driver.Value{{"name = 'Acme'"}}}
+	// Only the lookup within the scope misses the record
+	scoped := dbtest.Rule{Match: `"name" = $2`, Columns: []string{"exists"}, Rows: [][]driver.Value{{false}}}
+
+	tests := []struct {
+		name   string
+		rules  []dbtest.Rule
+		lookup bool // whether the record is looked up
+		valid  bool
+	}{
+		{"no read permission", []dbtest.Rule{readable(false), exists(true)}, false, false},
+		{"outside the row scope", []dbtest.Rule{readable(true), scope, scoped, exists(true)}, true, false},
+		{"missing", []dbtest.Rule{readable(true), exists(false)}, true, false},
+		{"readable", []dbtest.Rule{readable(true), scope, exists(true)}, true, true},
+	}
+	var message string
+	for _, tt := range tests {
+		db := useDB(t, append(registryRules(referenceResources()...), tt.rules...)...)
+		r := testRepository()
+		schema, err := r.schemaFor("orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &writer{schema: schema, user: user, filters: map[string]*rowFilter{}}
+
+		err = r.checkReferences(config.DB, w, map[string]interface{}{"customer_id": int64(9)})
+		if got := db.Ran(`FROM "customers"`); got != tt.lookup {
+			t.Errorf("%s: looked up=%v, want %v", tt.name, got, tt.lookup)
+		}
+		if tt.valid {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || len(validationErr.Errors) != 1 {
+			t.Errorf("%s: got %v, want a validation error", tt.name, err)
+			continue
+		}
+		if got := validationErr.Errors[0]; got.Field != "customer_id" || got.Code != "reference" {
+			t.Errorf("%s: got %+v", tt.name, got)
+		}
+		if message == "" {
+			message = validationErr.Errors[0].Message
+		} else if validationErr.Errors[0].Message != message {
+			t.Errorf("%s: message %q differs from %q", tt.name, validationErr.Errors[0].Message, message)
+		}
+	}
+}
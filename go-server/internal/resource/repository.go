@@ -3,25 +3,54 @@ package resource
 // Generic CRUD operations for resources (employees, projects, orders)
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"server/internal/config"
+	"server/internal/history"
+	"server/internal/permission"
+	"server/internal/registry"
+	"server/pkg/utils"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-type Repository struct{}
+type Repository struct {
+	Registry *registry.Service
+	History  *history.Repository
+	Files    FileRemover // attachment storage, so permanently deleted records take their files along
+}
+
+// FileRemover deletes stored files by key, like attachment.Storage
+type FileRemover interface {
+	Delete(key string) error
+}
 
-// Helper to fetch allowed fields for a role/resource
-func (r *Repository) getAllowedFields(roleID int, resource string) (viewFields map[string]bool, editFields map[string]bool, err error) {
+// fieldWrites holds the fields a role may set when creating a record and those it may change
+// afterwards (role_field_permissions.can_set_on_create and can_update)
+type fieldWrites struct {
+	create map[string]bool // nil = every field
+	update map[string]bool // nil = every field
+}
+
+// Helper to fetch allowed fields for a role/resource. masks holds the masking mode of
+// non-viewable fields that are returned masked instead of omitted.
+func (r *Repository) getAllowedFields(roleID int, resource string) (viewFields map[string]bool, writes fieldWrites, masks map[string]string, err error) {
 	viewFields = make(map[string]bool)
-	editFields = make(map[string]bool)
+	writes = fieldWrites{create: map[string]bool{}, update: map[string]bool{}}
+	masks = make(map[string]string)
 
 	// Admin (Role 1) has full access
 	if roleID == 1 {
-		return nil, nil, nil // nil maps signal full access
+		return nil, fieldWrites{}, nil, nil // nil maps signal full access
 	}
 
 	query := `
-		SELECT rf.field_name, COALESCE(rfp.can_view, false), COALESCE(rfp.can_edit, false)
+		SELECT rf.field_name, COALESCE(rfp.can_view, false), COALESCE(rfp.can_set_on_create, false),
+			COALESCE(rfp.can_update, false), COALESCE(rfp.mask_mode, 'omit')
 		FROM role_field_permissions rfp
 		JOIN resource_fields rf ON rfp.resource_field_id = rf.id
 		JOIN resources res ON rf.resource_id = res.id
@@ -29,63 +58,379 @@ func (r *Repository) getAllowedFields(roleID int, resource string) (viewFields m
 	`
 	rows, err := config.DB.Query(query, roleID, resource)
 	if err != nil {
-		return nil, nil, err
+		return nil, fieldWrites{}, nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var field string
-		var view, edit bool
-		if err := rows.Scan(&field, &view, &edit); err != nil {
+		var field, mask string
+		var view, create, update bool
+		if err := rows.Scan(&field, &view, &create, &update, &mask); err != nil {
 			// Ensure we don't silently fail, but for now continue is safe if COALESCE works
 			continue
 		}
 		if view {
 			viewFields[field] = true
+		} else if mask != permission.MaskOmit && permission.ValidMaskMode(mask) {
+			masks[field] = mask
+		}
+		if create {
+			writes.create[field] = true
 		}
-		if edit {
-			editFields[field] = true
+		if update {
+			writes.update[field] = true
 		}
 	}
-	return viewFields, editFields, nil
+	return viewFields, writes, masks, nil
 }
 
-func (r *Repository) GetAll(resource string, roleID int) ([]map[string]interface{}, error) {
-	viewFields, _, err := r.getAllowedFields(roleID, resource)
+// schemaFor resolves the identifiers a request may use: fields registered in resource_fields
+// that also exist on the live table (information_schema), plus the system columns.
+func (r *Repository) schemaFor(resource string) (*tableSchema, error) {
+	res, err := r.Registry.Get(resource)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsSystem || !res.Columns["id"] {
+		return nil, registry.ErrNotFound
+	}
+
+	s := &tableSchema{
+		resource: res,
+		table:    quoteIdent(res.Name),
+		types:    make(map[string]string),
+		writable: make(map[string]bool),
+
+		hasTrash:   res.Columns[registry.DeletedAtColumn],
+		softDelete: res.SoftDelete && res.Columns[registry.DeletedAtColumn],
+		versioned:  res.Columns[registry.VersionColumn],
+	}
+	for name, t := range systemFields {
+		if res.Columns[name] {
+			s.types[name] = t
+		}
+	}
+	for _, f := range res.Fields {
+		if !res.Columns[f.Name] {
+			continue // registered but not (yet) on the table
+		}
+		s.types[f.Name] = f.DataType
+		s.writable[f.Name] = true
+	}
+	s.loadComputed()
+	return s, nil
+}
+
+// canView reports whether a field is visible given the map from getAllowedFields (nil = full access)
+func canView(viewFields map[string]bool, field string) bool {
+	if viewFields == nil {
+		return true
+	}
+	if _, ok := systemFields[field]; ok {
+		return true
+	}
+	return viewFields[field]
+}
+
+// listPlan is a validated list query ready to execute
+type listPlan struct {
+	from       string // FROM ... [WHERE ...], shared by the row and count queries
+	args       []interface{}
+	selectCols string
+	order      []SortField
+	viewFields map[string]bool
+	masks      map[string]string // non-viewable columns returned masked
+	hidden     map[string]bool   // columns selected only to build cursors or computed fields
+	computed   []*computedField  // evaluated on each row by project
+}
+
+// planList builds a list query over live rows, or over trashed rows when trash is set
+func (r *Repository) planList(resource string, user *utils.Claims, q *ListQuery, trash bool) (*listPlan, error) {
+	viewFields, _, masks, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := r.schemaFor(resource)
+	if err != nil {
+		return nil, err
+	}
+	if trash {
+		if !schema.hasTrash {
+			return nil, ErrNoTrash
+		}
+		// The trash shows (and can be sorted by) when each row was deleted
+		schema.types[registry.DeletedAtColumn] = "datetime"
+		if viewFields != nil {
+			viewFields[registry.DeletedAtColumn] = true
+		}
+	}
+	types := schema.types
+	if err := schema.checkNotComputed(q); err != nil {
+		return nil, err
+	}
+	// Computed fields are not columns: they leave the fieldset, and what they read is selected
+	fields, computed, err := schema.splitComputed(q.Fields, viewFields)
 	if err != nil {
 		return nil, err
 	}
+	lq := *q
+	lq.Fields = fields
+	if err := lq.validate(types, viewFields); err != nil {
+		return nil, err
+	}
+
+	plan := &listPlan{order: q.orderFields(), viewFields: viewFields, masks: masks, hidden: map[string]bool{}, computed: computed}
 
-	selectClause := "*"
-	if roleID != 1 {
-		cols := []string{"id"} // Always include ID
-		// Retrieve all potential columns first to know what exists (or trust the allowed list)
-		// For simplicity/safety, we only select fields that are explicitly allowed.
-		// However, we need to know the valid columns of the table to avoid SQL errors if permission exists but column doesn't (rare).
-		// Better approach: Select * then filter in Go? No, requirement says "Fields ... must NOT appear".
-		// Construct SELECT based on viewFields.
-		if len(viewFields) > 0 {
-			for f := range viewFields {
+	cols := []string{}
+	if len(q.Fields) > 0 {
+		// Sparse fieldset: already validated against view permissions
+		cols = append(cols, "id")
+		for _, f := range fields {
+			if f != "id" {
+				cols = append(cols, f)
+			}
+		}
+	} else if user.RoleID != 1 {
+		cols = append(cols, "id") // Always include ID
+		// Only select fields that are explicitly allowed, so hidden fields never leave the database.
+		// If table access exists but no field is viewable, only IDs are returned.
+		for f := range viewFields {
+			if _, ok := types[f]; ok {
+				cols = append(cols, f)
+			}
+		}
+		// Masked fields are selected too; project replaces their values
+		for f := range masks {
+			if _, ok := types[f]; ok {
 				cols = append(cols, f)
 			}
-			selectClause = strings.Join(cols, ", ")
-		} else {
-			// If no fields allowed (but table access exists), return just IDs? or empty?
-			// Requirement says "See only tables they have access to". If table access is yes but field access is none, show IDs?
-			// Let's assume just ID if map is empty but not nil.
-			selectClause = "id"
 		}
 	}
+	if len(cols) > 0 && schema.versioned {
+		// Like the id, the version is always returned so clients can send If-Match
+		found := false
+		for _, c := range cols {
+			found = found || c == registry.VersionColumn
+		}
+		if !found {
+			cols = append(cols, registry.VersionColumn)
+		}
+	}
+	if len(cols) == 0 && !trash && schema.hasTrash {
+		plan.hidden[registry.DeletedAtColumn] = true // SELECT * must not leak the trash column
+	}
+
+	if len(cols) == 0 {
+		plan.selectCols = "*"
+	} else {
+		// Sort keys must be selected to build the next cursor, and the inputs of computed
+		// fields to evaluate them, even if not requested
+		selected := map[string]bool{}
+		for _, c := range cols {
+			selected[c] = true
+		}
+		needed := []string{}
+		for _, s := range plan.order {
+			needed = append(needed, s.Field)
+		}
+		for _, c := range computed {
+			needed = append(needed, c.deps...)
+		}
+		for _, f := range needed {
+			if !selected[f] {
+				cols = append(cols, f)
+				plan.hidden[f] = true
+				selected[f] = true
+			}
+		}
+		quoted := make([]string, len(cols))
+		for i, c := range cols {
+			quoted[i] = quoteIdent(c)
+		}
+		plan.selectCols = strings.Join(quoted, ", ")
+	}
+
+	where, args, err := q.buildWhere(types, 1)
+	if err != nil {
+		return nil, err
+	}
+	scope, scopeArgs, err := r.rowScope(schema, user, ScopeRead, len(args)+1)
+	if err != nil {
+		return nil, err
+	}
+	state := schema.liveOnly()
+	if trash {
+		state = quoteIdent(registry.DeletedAtColumn) + " IS NOT NULL"
+	}
+	conds := []string{}
+	for _, c := range []string{state, where, scope} {
+		if c != "" {
+			conds = append(conds, c)
+		}
+	}
+	plan.from = " FROM " + schema.table
+	if len(conds) > 0 {
+		plan.from += " WHERE " + strings.Join(conds, " AND ")
+	}
+	plan.args = append(args, scopeArgs...)
+	return plan, nil
+}
+
+// GetAll returns one page of rows using keyset pagination
+func (r *Repository) GetAll(resource string, user *utils.Claims, q *ListQuery) (*Page, error) {
+	plan, err := r.planList(resource, user, q, false)
+	if err != nil {
+		return nil, err
+	}
+	return r.page(plan, q)
+}
+
+// page runs a list plan and returns one page of projected rows plus the next cursor
+func (r *Repository) page(plan *listPlan, q *ListQuery) (*Page, error) {
+	page := &Page{Data: []map[string]interface{}{}}
+	if q.WithCount {
+		var total int
+		if err := config.DB.QueryRow("SELECT COUNT(*)"+plan.from, plan.args...).Scan(&total); err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	limit := q.pageSize()
+	rowsSeen := 0
+	var last map[string]interface{}
+	err := r.query(plan, q.After, limit+1, func(row map[string]interface{}) error {
+		rowsSeen++
+		if rowsSeen > limit {
+			return nil // fetched one extra row only to know whether another page exists
+		}
+		last = row
+		page.Data = append(page.Data, plan.project(row))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsSeen > limit && last != nil {
+		cursor, err := encodeCursor(plan.order, last)
+		if err != nil {
+			return nil, err
+		}
+		page.NextCursor = cursor
+	}
+	return page, nil
+}
+
+// Stream calls fn for every matching row as it is scanned, without buffering the result set.
+// A limit is only applied when the caller asked for one.
+func (r *Repository) Stream(resource string, user *utils.Claims, q *ListQuery, fn func(map[string]interface{}) error) error {
+	plan, err := r.planList(resource, user, q, false)
+	if err != nil {
+		return err
+	}
+
+	limit := 0
+	if q.Limit > 0 {
+		limit = q.Limit
+	}
+	return r.query(plan, q.After, limit, func(row map[string]interface{}) error {
+		return fn(plan.project(row))
+	})
+}
+
+// GetByID returns a single row, filtered to the caller's viewable fields (and the requested subset)
+func (r *Repository) GetByID(resource, id string, user *utils.Claims, fields []string) (map[string]interface{}, error) {
+	q := &ListQuery{
+		Fields:  fields,
+		Filters: []Filter{{Field: "id", Op: "eq", Value: id}},
+	}
+	plan, err := r.planList(resource, user, q, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var record map[string]interface{}
+	err = r.query(plan, "", 1, func(row map[string]interface{}) error {
+		record = plan.project(row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
 
-	query := fmt.Sprintf("SELECT %s FROM %s", selectClause, resource)
-	rows, err := config.DB.Query(query)
+// EditableFields lists the fields a role may change on an existing record of a resource
+func (r *Repository) EditableFields(resource string, roleID int) ([]string, error) {
+	_, writes, _, err := r.getAllowedFields(roleID, resource)
 	if err != nil {
 		return nil, err
 	}
+
+	schema, err := r.schemaFor(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &writer{schema: schema, writes: writes}
+	fields := []string{}
+	for f := range schema.writable {
+		if w.canWrite(f, false) && !schema.immutable(f) {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// query runs a plan starting after the given cursor and scans each row into a map
+func (r *Repository) query(plan *listPlan, after string, limit int, fn func(map[string]interface{}) error) error {
+	query := "SELECT " + plan.selectCols + plan.from
+	args := plan.args
+
+	if after != "" {
+		cur, err := decodeCursor(after, plan.order)
+		if err != nil {
+			return err
+		}
+		keyset, keyArgs := cur.buildKeyset(plan.order, len(args)+1)
+		if len(args) == 0 {
+			query += " WHERE " + keyset
+		} else {
+			query += " AND " + keyset
+		}
+		args = append(append([]interface{}{}, args...), keyArgs...)
+	}
+
+	query += " ORDER BY " + buildOrderBy(plan.order)
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := config.DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	return scanRows(rows, fn)
+}
+
+// scanRows scans each row into a column -> value map, typed by columnValue, and closes rows
+func scanRows(rows *sql.Rows, fn func(map[string]interface{}) error) error {
 	defer rows.Close()
 
-	cols, _ := rows.Columns()
-	results := []map[string]interface{}{}
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
 
 	for rows.Next() {
 		values := make([]interface{}, len(cols))
@@ -93,135 +438,701 @@ func (r *Repository) GetAll(resource string, roleID int) ([]map[string]interface
 		for i := range values {
 			valuePtrs[i] = &values[i]
 		}
-		rows.Scan(valuePtrs...)
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return err
+		}
 
 		row := make(map[string]interface{})
 		for i, col := range cols {
-			val := values[i]
-			if b, ok := val.([]byte); ok {
-				row[col] = string(b)
-			} else {
-				row[col] = val
+			row[col] = columnValue(colTypes[i].DatabaseTypeName(), values[i])
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// fetchRow reads a full row by id, including trashed rows; nil if it does not exist.
+// With lock set the row is locked for the rest of the transaction.
+func fetchRow(q history.Querier, schema *tableSchema, id string, lock bool) (map[string]interface{}, error) {
+	query := fmt.Sprintf(`SELECT * FROM %s WHERE "id" = $1`, schema.table)
+	if lock {
+		query += " FOR UPDATE"
+	}
+	rows, err := q.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var row map[string]interface{}
+	err = scanRows(rows, func(r map[string]interface{}) error {
+		row = r
+		return nil
+	})
+	return row, err
+}
+
+// project applies per-row field filtering before a row leaves the repository
+func (p *listPlan) project(row map[string]interface{}) map[string]interface{} {
+	out := projectFields(p.viewFields, p.masks, row)
+	addComputed(out, row, p.computed)
+	for col := range p.hidden {
+		delete(out, col)
+	}
+	return out
+}
+
+// projectFields drops non-viewable fields of a row, or masks them if the role has a mask mode
+func projectFields(viewFields map[string]bool, masks map[string]string, row map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(row))
+	for col, val := range row {
+		if !canView(viewFields, col) {
+			if mode, ok := masks[col]; ok {
+				out[col] = maskValue(mode, val)
 			}
+			continue
 		}
-		results = append(results, row)
+		out[col] = val
 	}
-	return results, nil
+	return out
+}
+
+// writer holds what a caller's writes to one resource need: the schema, the fields the role may
+// edit and its row filters. It is loaded once per request, or once for a whole batch.
+type writer struct {
+	schema  *tableSchema
+	user    *utils.Claims
+	writes  fieldWrites
+	filters map[string]*rowFilter // by action, loaded on first use
+	grants  map[int]bool          // state transitions the role may perform, loaded on first use
+	purged  []string              // files of attachments dropped with their records, see removeFiles
 }
 
-func (r *Repository) Create(resource string, data map[string]interface{}, roleID int) (int, error) {
-	_, editFields, err := r.getAllowedFields(roleID, resource)
+func (r *Repository) newWriter(resource string, user *utils.Claims) (*writer, error) {
+	schema, err := r.schemaFor(resource)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	_, writes, _, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
 	}
+	return &writer{schema: schema, user: user, writes: writes, filters: map[string]*rowFilter{}}, nil
+}
 
-	// Build dynamic INSERT
-	keys := []string{}
-	vals := []interface{}{}
-	placeholders := []string{}
-	i := 1
+// scope returns the writer's row condition for an action, with placeholders numbered from start
+func (r *Repository) scope(w *writer, action string, start int) (string, []interface{}, error) {
+	f, ok := w.filters[action]
+	if !ok {
+		var err error
+		if f, err = r.loadRowFilter(w.schema, w.user, action); err != nil {
+			return "", nil, err
+		}
+		w.filters[action] = f
+	}
+	return f.sql(start)
+}
 
+// canWrite reports whether the role may set a column when creating a record, or change it on
+// an existing one. Immutable fields are checked against the stored row, see checkImmutable.
+func (w *writer) canWrite(field string, isCreate bool) bool {
+	allowed := w.writes.update
+	if isCreate {
+		allowed = w.writes.create
+	}
+	return w.schema.writable[field] && (allowed == nil || allowed[field])
+}
+
+// filterWritable splits a write body into the columns the role may set and the keys it may not
+// (unknown columns, or fields without can_set_on_create on create and without can_update on
+// update). In strict mode any such key fails the request.
+func (w *writer) filterWritable(data map[string]interface{}, isCreate, strict bool) (map[string]interface{}, []string, error) {
+	permitted := map[string]interface{}{}
+	rejected := []string{}
 	for k, v := range data {
-		// If strict permission check:
-		if roleID != 1 {
-			if !editFields[k] {
-				continue // Skip fields user cannot edit (silently ignore)
-			}
+		if !w.canWrite(k, isCreate) {
+			rejected = append(rejected, k)
+			continue
 		}
+		permitted[k] = v
+	}
+	sort.Strings(rejected)
 
-		keys = append(keys, k)
-		vals = append(vals, v)
-		placeholders = append(placeholders, fmt.Sprintf("$%d", i))
-		i++
+	// Computed fields are rejected even in lenient mode: ignoring them would look like success
+	if err := w.schema.readOnly(data); err != nil {
+		return nil, nil, err
 	}
 
-	// Handle case where no fields are permitted (or data is empty)
-	if len(keys) == 0 {
-		// Try to insert with defaults (will fail if NOT NULL columns are missing, which is expected)
-		query := fmt.Sprintf("INSERT INTO %s DEFAULT VALUES RETURNING id", resource)
-		var id int
-		err = config.DB.QueryRow(query).Scan(&id)
-		return id, err
+	if strict && len(rejected) > 0 {
+		return nil, nil, &RejectedFieldsError{Fields: rejected}
 	}
+	return permitted, rejected, nil
+}
 
-	query := fmt.Sprintf(
-		"INSERT INTO %s (%s) VALUES (%s) RETURNING id",
-		resource,
-		strings.Join(keys, ", "),
-		strings.Join(placeholders, ", "),
-	)
+// inTx runs fn in a transaction that is committed only if fn succeeds
+func inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	var id int
-	err = config.DB.QueryRow(query, vals...).Scan(&id)
-	return id, err
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *Repository) Update(resource, id string, data map[string]interface{}, roleID int) error {
-	_, editFields, err := r.getAllowedFields(roleID, resource)
+func (r *Repository) Create(resource string, data map[string]interface{}, user *utils.Claims, strict bool) (*WriteResult, error) {
+	w, err := r.newWriter(resource, user)
 	if err != nil {
+		return nil, err
+	}
+
+	var result *WriteResult
+	err = inTx(func(tx *sql.Tx) error {
+		result, err = r.create(tx, w, data, strict)
 		return err
+	})
+	return result, err
+}
+
+func (r *Repository) create(tx *sql.Tx, w *writer, data map[string]interface{}, strict bool) (*WriteResult, error) {
+	permitted, ignored, err := w.filterWritable(data, true, strict)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateWrite(w.schema.resource, permitted, true); err != nil {
+		return nil, err
+	}
+	if err := r.checkReferences(tx, w, permitted); err != nil {
+		return nil, err
 	}
 
-	// Build dynamic UPDATE
-	sets := []string{}
-	vals := []interface{}{}
-	i := 1
+	query, args, err := buildInsert(w.schema, permitted)
+	if err != nil {
+		return nil, err
+	}
 
-	for k, v := range data {
-		// If strict permission check:
-		if roleID != 1 {
-			if !editFields[k] {
-				continue // Skip fields user cannot edit (silently ignore)
-			}
+	result := &WriteResult{IgnoredFields: ignored}
+	err = tx.QueryRow(query, args...).Scan(&result.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := fetchRow(tx, w.schema, strconv.Itoa(result.ID), false)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkTransitions(tx, w, nil, after); err != nil {
+		return nil, err
+	}
+	if err := r.recordChange(tx, w, result.ID, history.ActionCreate, nil, after); err != nil {
+		return nil, err
+	}
+	result.Version, _ = VersionOf(after)
+	return result, nil
+}
+
+// Update changes a row; with a precondition it only succeeds if the row is still at that version
+func (r *Repository) Update(resource, id string, data map[string]interface{}, user *utils.Claims, strict bool, pre *Precondition) (*WriteResult, error) {
+	w, err := r.newWriter(resource, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *WriteResult
+	err = inTx(func(tx *sql.Tx) error {
+		result, err = r.updateRecord(tx, w, id, data, strict, pre)
+		return err
+	})
+	return result, err
+}
+
+func (r *Repository) updateRecord(tx *sql.Tx, w *writer, id string, data map[string]interface{}, strict bool, pre *Precondition) (*WriteResult, error) {
+	if err := w.schema.requirePrecondition(pre); err != nil {
+		return nil, err
+	}
+
+	permitted, ignored, err := w.filterWritable(data, false, strict)
+	if err != nil {
+		return nil, err
+	}
+	result := &WriteResult{IgnoredFields: ignored}
+	if len(permitted) == 0 {
+		return result, ErrNothingToUpdate
+	}
+	// Sensitive fields that need approval are validated now and applied once approved
+	held := w.schema.protectedValues(permitted)
+	if err := validateWrite(w.schema.resource, permitted, false); err != nil {
+		return nil, err
+	}
+	if err := r.checkReferences(tx, w, permitted); err != nil {
+		return nil, err
+	}
+	if len(held) > 0 {
+		result.PendingChange, result.Version, err = r.hold(tx, w, id, permitted, held, pre)
+		if err != nil || len(permitted) == 0 {
+			return result, err
 		}
+	}
+
+	after, err := r.update(tx, w, id, permitted, history.ActionUpdate, pre)
+	if err != nil {
+		return nil, err
+	}
+	result.Version, _ = VersionOf(after)
+	return result, nil
+}
+
+// update writes already permitted and validated data to one row, records the change as event
+// and returns the updated row
+func (r *Repository) update(tx *sql.Tx, w *writer, id string, data map[string]interface{}, event string, pre *Precondition) (map[string]interface{}, error) {
+	query, args, err := buildUpdate(w.schema, id, data)
+	if err != nil {
+		return nil, err
+	}
+	if live := w.schema.liveOnly(); live != "" {
+		query += " AND " + live // trashed rows cannot be edited until restored
+	}
+	// Rows outside the caller's update scope behave as if they did not exist
+	scope, scopeArgs, err := r.scope(w, ScopeUpdate, len(args)+1)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" {
+		query += " AND " + scope
+		args = append(args, scopeArgs...)
+	}
+
+	return r.execRecorded(tx, w, event, query, args, id, pre)
+}
 
-		sets = append(sets, fmt.Sprintf("%s = $%d", k, i))
-		vals = append(vals, v)
-		i++
+// Delete moves a row to the trash on soft-delete resources and removes it otherwise
+func (r *Repository) Delete(resource, id string, user *utils.Claims, pre *Precondition) error {
+	w, err := r.newWriter(resource, user)
+	if err != nil {
+		return err
 	}
+	err = inTx(func(tx *sql.Tx) error {
+		return r.delete(tx, w, id, pre)
+	})
+	if err == nil {
+		r.removeFiles(w)
+	}
+	return err
+}
 
-	if len(sets) == 0 {
-		return nil // Nothing to update
+func (r *Repository) delete(tx *sql.Tx, w *writer, id string, pre *Precondition) error {
+	schema := w.schema
+	if err := schema.requirePrecondition(pre); err != nil {
+		return err
 	}
 
-	vals = append(vals, id)
+	query := fmt.Sprintf(`DELETE FROM %s WHERE "id" = $1`, schema.table)
+	if schema.softDelete {
+		query = fmt.Sprintf(`UPDATE %s SET %s WHERE "id" = $1`,
+			schema.table, withVersion(schema, quoteIdent(registry.DeletedAtColumn)+" = CURRENT_TIMESTAMP"))
+	}
+	if live := schema.liveOnly(); live != "" {
+		query += " AND " + live
+	}
+	if err := r.execScoped(tx, w, ScopeDelete, history.ActionDelete, query, id, pre); err != nil {
+		return err
+	}
+	if schema.softDelete {
+		return nil // attachments stay with the trashed record until it is purged
+	}
+	return r.dropAttachments(tx, w, id)
+}
 
-	query := fmt.Sprintf(
-		"UPDATE %s SET %s WHERE id = $%d",
-		resource,
-		strings.Join(sets, ", "),
-		i,
-	)
+// GetTrash lists trashed rows with the same field and row filtering as GetAll
+func (r *Repository) GetTrash(resource string, user *utils.Claims, q *ListQuery) (*Page, error) {
+	plan, err := r.planList(resource, user, q, true)
+	if err != nil {
+		return nil, err
+	}
+	return r.page(plan, q)
+}
+
+// Restore moves a row out of the trash
+func (r *Repository) Restore(resource, id string, user *utils.Claims) error {
+	w, err := r.newWriter(resource, user)
+	if err != nil {
+		return err
+	}
+	if !w.schema.hasTrash {
+		return ErrNoTrash
+	}
 
-	_, err = config.DB.Exec(query, vals...)
+	deletedAt := quoteIdent(registry.DeletedAtColumn)
+	query := fmt.Sprintf(`UPDATE %s SET %s WHERE "id" = $1 AND %s IS NOT NULL`,
+		w.schema.table, withVersion(w.schema, deletedAt+" = NULL"), deletedAt)
+	return inTx(func(tx *sql.Tx) error {
+		return r.execScoped(tx, w, ScopeDelete, history.ActionRestore, query, id, nil)
+	})
+}
+
+// Purge permanently deletes a row from the trash
+func (r *Repository) Purge(resource, id string, user *utils.Claims) error {
+	w, err := r.newWriter(resource, user)
+	if err != nil {
+		return err
+	}
+	if !w.schema.hasTrash {
+		return ErrNoTrash
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE "id" = $1 AND %s IS NOT NULL`, w.schema.table, quoteIdent(registry.DeletedAtColumn))
+	err = inTx(func(tx *sql.Tx) error {
+		if err := r.execScoped(tx, w, ScopeDelete, history.ActionPurge, query, id, nil); err != nil {
+			return err
+		}
+		return r.dropAttachments(tx, w, id)
+	})
+	if err == nil {
+		r.removeFiles(w)
+	}
 	return err
 }
 
-func (r *Repository) Delete(resource, id string) error {
-	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1", resource)
-	_, err := config.DB.Exec(query, id)
+// dropAttachments deletes the attachments of a permanently deleted record, so their download
+// links stop working with it. The files are removed once the transaction commits.
+func (r *Repository) dropAttachments(tx *sql.Tx, w *writer, id string) error {
+	rows, err := tx.Query(`DELETE FROM attachments WHERE resource_id = $1 AND record_id = $2 RETURNING storage_key`,
+		w.schema.resource.ID, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	w.purged = append(w.purged, keys...)
+	return nil
+}
+
+// removeFiles deletes the files of the attachments dropped by a committed write. A file left
+// behind is only wasted space: its row, and with it every link, is already gone.
+func (r *Repository) removeFiles(w *writer) {
+	for _, key := range w.purged {
+		if r.Files == nil {
+			break
+		}
+		if err := r.Files.Delete(key); err != nil {
+			log.Printf("Failed to delete attachment file %s: %v", key, err)
+		}
+	}
+	w.purged = nil
+}
+
+// withVersion appends the version bump to a SET clause
+func withVersion(schema *tableSchema, set string) string {
+	if v := schema.versionSet(); v != "" {
+		return set + ", " + v
+	}
+	return set
+}
+
+// execScoped runs a single-row statement (WHERE "id" = $1 ...) within the caller's row scope
+func (r *Repository) execScoped(tx *sql.Tx, w *writer, action, event, query, id string, pre *Precondition) error {
+	args := []interface{}{id}
+	scope, scopeArgs, err := r.scope(w, action, 2)
+	if err != nil {
+		return err
+	}
+	if scope != "" {
+		query += " AND " + scope
+		args = append(args, scopeArgs...)
+	}
+	_, err = r.execRecorded(tx, w, event, query, args, id, pre)
 	return err
 }
 
-func (r *Repository) HasPermission(roleID int, resource, action string) (bool, error) {
-	query := `
-		SELECT CASE 
-			WHEN $3 = 'read' THEN rrp.can_view
-			WHEN $3 = 'create' THEN rrp.can_create
-			WHEN $3 = 'update' THEN rrp.can_update
-			WHEN $3 = 'delete' THEN rrp.can_delete
-			ELSE false
-		END as has_permission
-		FROM role_resource_permissions rrp
-		JOIN resources res ON rrp.resource_id = res.id
-		WHERE rrp.role_id = $1 AND res.name = $2
-	`
+// execRecorded runs a statement that changes row id and records the change in the same
+// transaction, returning the row afterwards (nil once deleted). A statement that matches no
+// row is reported as ErrNotFound; a row that no longer matches pre as PreconditionFailedError.
+// On error the caller must roll back: the statement may already have been applied.
+func (r *Repository) execRecorded(tx *sql.Tx, w *writer, event, query string, args []interface{}, id string, pre *Precondition) (map[string]interface{}, error) {
+	recordID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	before, err := fetchRow(tx, w.schema, id, true)
+	if err != nil {
+		return nil, err
+	}
+	if before == nil {
+		return nil, ErrNotFound
+	}
+
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+
+	// The version is compared only once the statement matched, so rows outside the caller's
+	// scope (or in the trash) stay 404 instead of revealing their version. The row is locked,
+	// so before is still the current state once the transaction rolls back.
+	if version, ok := VersionOf(before); ok && pre != nil && !pre.Matches(version) {
+		return nil, r.preconditionFailed(tx, w, id, version, before)
+	}
+
+	after, err := fetchRow(tx, w.schema, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.schema.checkImmutable(before, after); err != nil {
+		return nil, err
+	}
+	// Approved changes were checked against the requester's transition grants when held
+	if event != history.ActionApprove {
+		if err := r.checkTransitions(tx, w, before, after); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.recordChange(tx, w, recordID, event, before, after); err != nil {
+		return nil, err
+	}
+	return after, nil
+}
+
+// preconditionFailed builds the 412 error carrying the current row, filtered like GetByID
+func (r *Repository) preconditionFailed(q history.Querier, w *writer, id string, version int, current map[string]interface{}) error {
+	schema, user := w.schema, w.user
+	failed := &PreconditionFailedError{Version: version}
+	if err := r.checkVisible(q, schema, user, id); errors.Is(err, ErrNotFound) {
+		return failed
+	} else if err != nil {
+		return err
+	}
+	if allowed, err := r.HasPermission(user.RoleID, schema.resource.Name, permission.ActionRead); err != nil || !allowed {
+		return failed
+	}
+
+	viewFields, _, masks, err := r.getAllowedFields(user.RoleID, schema.resource.Name)
+	if err != nil {
+		return err
+	}
+	failed.Current = projectFields(viewFields, masks, current)
+	_, computed, _ := schema.splitComputed(nil, viewFields)
+	addComputed(failed.Current, current, computed)
+	delete(failed.Current, registry.DeletedAtColumn)
+	return failed
+}
+
+func (r *Repository) recordChange(q history.Querier, w *writer, recordID int, event string, before, after map[string]interface{}) error {
+	return r.History.Record(q, w.schema.resource.ID, recordID, event, w.user.ID, w.user.Username, before, after)
+}
+
+// checkVisible reports ErrNotFound unless the record (live or trashed) is within the caller's
+// read scope. Roles without a read filter may also see the history of purged records.
+func (r *Repository) checkVisible(q history.Querier, schema *tableSchema, user *utils.Claims, id string) error {
+	scope, args, err := r.rowScope(schema, user, ScopeRead, 2)
+	if err != nil || scope == "" {
+		return err
+	}
+
+	var exists bool
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE "id" = $1 AND %s)`, schema.table, scope)
+	if err := q.QueryRow(query, append([]interface{}{id}, args...)...).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetHistory lists the changes of a record, newest first, limited to fields the caller can view
+func (r *Repository) GetHistory(resource, id string, user *utils.Claims) ([]history.Entry, error) {
+	schema, err := r.schemaFor(resource)
+	if err != nil {
+		return nil, err
+	}
+	recordID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if err := r.checkVisible(config.DB, schema, user, id); err != nil {
+		return nil, err
+	}
+	viewFields, _, masks, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := r.History.List(config.DB, schema.resource.ID, recordID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		visible := map[string]history.Change{}
+		for field, c := range entries[i].Changes {
+			if canView(viewFields, field) {
+				visible[field] = c
+			} else if mode, ok := masks[field]; ok {
+				visible[field] = history.Change{Old: maskValue(mode, c.Old), New: maskValue(mode, c.New)}
+			}
+		}
+		entries[i].Changes = visible
+	}
+	return entries, nil
+}
 
-	var hasPermission bool
-	err := config.DB.QueryRow(query, roleID, resource, action).Scan(&hasPermission)
+// GetAsOf returns a record as it was at t, filtered like GetByID
+func (r *Repository) GetAsOf(resource, id string, user *utils.Claims, fields []string, t time.Time) (map[string]interface{}, error) {
+	schema, err := r.schemaFor(resource)
 	if err != nil {
-		return false, nil // No permission found
+		return nil, err
+	}
+	recordID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	viewFields, _, masks, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+	columns, computed, err := schema.splitComputed(fields, viewFields)
+	if err != nil {
+		return nil, err
+	}
+	q := &ListQuery{Fields: columns}
+	if err := q.validate(schema.types, viewFields); err != nil {
+		return nil, err
+	}
+	if err := r.checkVisible(config.DB, schema, user, id); err != nil {
+		return nil, err
 	}
 
-	return hasPermission, nil
+	current, err := fetchRow(config.DB, schema, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current[registry.DeletedAtColumn] != nil {
+		current = nil // in the trash
+	}
+
+	state, err := r.History.StateAt(config.DB, schema.resource.ID, recordID, t, current)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, ErrNotFound
+	}
+
+	record := projectFields(viewFields, masks, state)
+	addComputed(record, state, computed)
+	delete(record, registry.DeletedAtColumn)
+	if len(fields) > 0 {
+		wanted := map[string]bool{"id": true}
+		for _, f := range fields {
+			wanted[f] = true
+		}
+		for col := range record {
+			if !wanted[col] {
+				delete(record, col)
+			}
+		}
+	}
+	return record, nil
+}
+
+// Revert sets a record's fields back to their values after history entry entryID, limited to
+// fields the caller can view and update. The record must be readable before its history is looked
+// at; the revert is then an update like any other: validation, row scope and If-Match apply.
+func (r *Repository) Revert(resource, id string, entryID int, user *utils.Claims, pre *Precondition) (*WriteResult, error) {
+	w, err := r.newWriter(resource, user)
+	if err != nil {
+		return nil, err
+	}
+	schema := w.schema
+	if err := schema.requirePrecondition(pre); err != nil {
+		return nil, err
+	}
+	recordID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if err := r.checkVisible(config.DB, schema, user, id); err != nil {
+		return nil, err
+	}
+	viewFields, _, _, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := r.History.Get(config.DB, schema.resource.ID, recordID, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+	current, err := fetchRow(config.DB, schema, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, ErrNotFound
+	}
+
+	permitted := w.revertValues(viewFields, entry.Snapshot, current)
+	if len(permitted) == 0 {
+		return nil, ErrNothingToUpdate
+	}
+	// Reverting a sensitive field is a change like any other and needs approval
+	held := schema.protectedValues(permitted)
+	if err := validateWrite(schema.resource, permitted, false); err != nil {
+		return nil, err
+	}
+	result := &WriteResult{ID: recordID}
+	err = inTx(func(tx *sql.Tx) error {
+		if len(held) > 0 {
+			result.PendingChange, result.Version, err = r.hold(tx, w, id, permitted, held, pre)
+			if err != nil || len(permitted) == 0 {
+				return err
+			}
+		}
+		after, err := r.update(tx, w, id, permitted, history.ActionRevert, pre)
+		result.Version, _ = VersionOf(after)
+		return err
+	})
+	return result, err
+}
+
+// revertValues returns the values of a snapshot that differ from the current row, for the fields
+// the caller may view and update. Other fields are left alone, so a revert neither reveals nor
+// changes what the caller cannot see.
+func (w *writer) revertValues(viewFields map[string]bool, snapshot, current map[string]interface{}) map[string]interface{} {
+	data := map[string]interface{}{}
+	for field := range w.schema.writable {
+		// Immutable fields never change after creation, so there is nothing to revert
+		if w.schema.immutable(field) || !canView(viewFields, field) || !w.canWrite(field, false) {
+			continue
+		}
+		if old, ok := snapshot[field]; ok && !history.Equal(old, current[field]) {
+			data[field] = old
+		}
+	}
+	return data
+}
+
+func (r *Repository) HasPermission(roleID int, resource, action string) (bool, error) {
+	return permission.HasPermission(roleID, resource, action)
 }
@@ -0,0 +1,142 @@
+package resource
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"server/internal/config"
+	"server/internal/dbtest"
+	"server/internal/history"
+	"server/internal/registry"
+	"server/pkg/utils"
+	"testing"
+)
+
+// useDB points config.DB at a scripted database for the duration of a test
+func useDB(t *testing.T, rules ...dbtest.Rule) *dbtest.DB {
+	t.Helper()
+	db := dbtest.Open(rules...)
+	saved := config.DB
+	config.DB = db.DB
+	t.Cleanup(func() { config.DB = saved })
+	return db
+}
+
+// registryRules answers the registry's metadata queries with resources whose tables have
+// the id, created_at and version columns and one column per stored field
+func registryRules(resources ...registry.Resource) []dbtest.Rule {
+	fields := dbtest.Rule{Match: "FROM resources res", Columns: []string{
+		"id", "name", "display_name", "is_system", "soft_delete", "require_if_match", "approver_role_id",
+		"id", "field_name", "data_type", "is_sensitive", "validation", "min_group_size",
+		"ref_resource", "expression", "immutable",
+	}}
+	columns := dbtest.Rule{Match: "information_schema.columns", Columns: []string{"table_name", "column_name"}}
+	for _, res := range resources {
+		for _, col := range []string{"id", "created_at", registry.VersionColumn} {
+			columns.Rows = append(columns.Rows, []driver.Value{res.Name, col})
+		}
+		for _, f := range res.Fields {
+			validation, _ := json.Marshal(f.Validation)
+			fields.Rows = append(fields.Rows, []driver.Value{
+				int64(res.ID), res.Name, res.Name, false, res.SoftDelete, res.RequireIfMatch, int64(res.ApproverRoleID),
+				int64(f.ID), f.Name, f.DataType, f.IsSensitive, validation, int64(f.MinGroupSize),
+				f.Reference, f.Expression, f.Immutable,
+			})
+			if !f.Computed() {
+				columns.Rows = append(columns.Rows, []driver.Value{res.Name, f.Name})
+			}
+		}
+	}
+	return []dbtest.Rule{fields, columns}
+}
+
+func testRepository() *Repository {
+	return &Repository{Registry: &registry.Service{Repo: &registry.Repository{}}, History: &history.Repository{}}
+}
+
+func ordersResource() registry.Resource {
+	return registry.Resource{ID: 1, Name: "orders", Fields: []registry.Field{
+		{ID: 1, Name: "customer_name", DataType: "text", Immutable: true},
+		{ID: 2, Name: "amount", DataType: "number"},
+		{ID: 3, Name: "status", DataType: "text"},
+	}}
+}
+
+func TestRevertValues(t *testing.T) {
+	res := ordersResource()
+	s := testSchema()
+	s.resource = &res
+	w := &writer{schema: s, writes: fieldWrites{update: map[string]bool{"customer_name": true, "amount": true}}}
+	snapshot := map[string]interface{}{"customer_name": "Acme", "amount": json.Number("5"), "status": "Open"}
+	current := map[string]interface{}{"customer_name": "Other", "amount": int64(9), "status": "Closed"}
+
+	// customer_name is immutable, status is not updatable
+	got := w.revertValues(nil, snapshot, current)
+	if want := map[string]interface{}{"amount": json.Number("5")}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Fields the caller cannot view are left alone even if they may update them
+	if got := w.revertValues(map[string]bool{"status": true}, snapshot, current); len(got) != 0 {
+		t.Fatalf("hidden field reverted: %v", got)
+	}
+	current["amount"] = int64(5)
+	if got := w.revertValues(nil, snapshot, current); len(got) != 0 {
+		t.Fatalf("unchanged field reverted: %v", got)
+	}
+}
+
+// A record outside the caller's read scope is not found, whether or not its history has the
+// entry, and the history is not read
+func TestRevertRequiresReadableRecord(t *testing.T) {
+	db := useDB(t, append(registryRules(ordersResource()),
+		dbtest.Rule{Match: "FROM role_row_filters", Columns: []string{"expression"}, Rows: [][]driver.Value{{"status = 'Open'"}}},
+		dbtest.Rule{Match: "SELECT EXISTS", Columns: []string{"exists"}, Rows: [][]driver.Value{{false}}},
+	)...)
+
+	_, err := testRepository().Revert("orders", "7", 3, &utils.Claims{ID: 2, RoleID: 2}, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if db.Ran("record_history") {
+		t.Fatal("history read for a record outside the read scope")
+	}
+}
+
+func TestFilterWritable(t *testing.T) {
+	s := testSchema()
+	s.resource = &registry.Resource{Name: "orders"}
+	w := &writer{schema: s, writes: fieldWrites{
+		create: map[string]bool{"customer_name": true, "amount": true},
+		update: map[string]bool{"amount": true, "status": true},
+	}}
+	data := map[string]interface{}{"customer_name": "Acme", "amount": 5, "status": "Open", "id": 9}
+
+	permitted, rejected, err := w.filterWritable(data, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(permitted, map[string]interface{}{"customer_name": "Acme", "amount": 5}) ||
+		!reflect.DeepEqual(rejected, []string{"id", "status"}) {
+		t.Fatalf("create: got %v, rejected %v", permitted, rejected)
+	}
+	permitted, rejected, err = w.filterWritable(data, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(permitted, map[string]interface{}{"amount": 5, "status": "Open"}) ||
+		!reflect.DeepEqual(rejected, []string{"customer_name", "id"}) {
+		t.Fatalf("update: got %v, rejected %v", permitted, rejected)
+	}
+
+	var rejectedErr *RejectedFieldsError
+	if _, _, err := w.filterWritable(data, false, true); !errors.As(err, &rejectedErr) ||
+		!reflect.DeepEqual(rejectedErr.Fields, []string{"customer_name", "id"}) {
+		t.Fatalf("strict update: got %v", err)
+	}
+
+	admin := &writer{schema: s}
+	if permitted, rejected, _ := admin.filterWritable(data, false, false); len(permitted) != 3 || !reflect.DeepEqual(rejected, []string{"id"}) {
+		t.Fatalf("admin: got %v, rejected %v", permitted, rejected)
+	}
+}
@@ -0,0 +1,141 @@
+package resource
+
+// Row-level security. A role may have one predicate per resource and action in role_row_filters
+// (written in the internal/expr language); it is compiled to a parameterized condition and
+// ANDed into every list, read, update and delete for that role.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"server/internal/config"
+	"server/internal/expr"
+	"server/pkg/utils"
+	"strings"
+)
+
+// Row filter actions
+const (
+	ScopeRead   = "read"
+	ScopeUpdate = "update"
+	ScopeDelete = "delete"
+)
+
+// rowScope returns the caller's row condition for an action, with placeholders numbered from start.
+// An empty condition means the role is not restricted.
+func (r *Repository) rowScope(schema *tableSchema, user *utils.Claims, action string, start int) (string, []interface{}, error) {
+	f, err := r.loadRowFilter(schema, user, action)
+	if err != nil {
+		return "", nil, err
+	}
+	return f.sql(start)
+}
+
+// rowFilter is a role's compiled predicate for one action. A nil filter does not restrict rows.
+type rowFilter struct {
+	pred   *expr.Predicate // nil when the stored filter is no longer valid: no row matches
+	params *userParams
+}
+
+// loadRowFilter reads and parses the caller's filter for an action; the result can be
+// rendered any number of times, so callers doing many writes load it once
+func (r *Repository) loadRowFilter(schema *tableSchema, user *utils.Claims, action string) (*rowFilter, error) {
+	// Admin (Role 1) sees every row
+	if user.RoleID == 1 {
+		return nil, nil
+	}
+
+	var source string
+	err := config.DB.QueryRow(`
+		SELECT f.expression
+		FROM role_row_filters f
+		JOIN resources res ON f.resource_id = res.id
+		WHERE f.role_id = $1 AND res.name = $2 AND f.action = $3
+	`, user.RoleID, schema.resource.Name, action).Scan(&source)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compileRowFilter(schema, user, source), nil
+}
+
+// compileRowFilter parses a stored filter for a schema
+func compileRowFilter(schema *tableSchema, user *utils.Claims, source string) *rowFilter {
+	pred, err := expr.ParsePredicate(source)
+	if err != nil {
+		// Filters are validated when saved; fail closed if one no longer parses
+		return &rowFilter{}
+	}
+	for _, f := range pred.Fields() {
+		if _, ok := schema.types[f]; !ok {
+			return &rowFilter{} // field was removed from the resource
+		}
+	}
+	return &rowFilter{pred: pred, params: &userParams{user: user}}
+}
+
+// sql renders the filter as a condition with placeholders numbered from start
+func (f *rowFilter) sql(start int) (string, []interface{}, error) {
+	if f == nil {
+		return "", nil, nil
+	}
+	if f.pred == nil {
+		return "FALSE", nil, nil
+	}
+	return f.pred.SQL(quoteIdent, f.params.resolve, start)
+}
+
+// userParams resolves :current_user and :user.<name>, loading the user row only when needed
+type userParams struct {
+	user   *utils.Claims
+	values map[string]interface{}
+}
+
+func (p *userParams) resolve(name string) (interface{}, error) {
+	switch name {
+	case "current_user", "user.id":
+		return p.user.ID, nil
+	case "user.username":
+		return p.user.Username, nil
+	case "user.role_id":
+		return p.user.RoleID, nil
+	}
+
+	if p.values == nil {
+		if err := p.load(); err != nil {
+			return nil, err
+		}
+	}
+	// Unknown attributes bind NULL, which matches no row
+	return p.values[strings.TrimPrefix(name, "user.")], nil
+}
+
+func (p *userParams) load() error {
+	var email sql.NullString
+	var attributes []byte
+	err := config.DB.QueryRow(
+		"SELECT email, COALESCE(attributes, '{}') FROM users WHERE id = $1", p.user.ID,
+	).Scan(&email, &attributes)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	decoded := map[string]interface{}{}
+	if len(attributes) > 0 {
+		if err := json.Unmarshal(attributes, &decoded); err != nil {
+			return err
+		}
+	}
+	p.values = map[string]interface{}{}
+	for k, v := range decoded {
+		switch v.(type) {
+		case string, float64, bool:
+			p.values[k] = v // nested objects and arrays cannot be bound and stay NULL
+		}
+	}
+	if email.Valid {
+		p.values["email"] = email.String
+	}
+	return nil
+}
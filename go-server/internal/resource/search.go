@@ -0,0 +1,210 @@
+package resource
+
+// Global search. Every resource the caller can read is searched over the text fields the role
+// can view, using the per-field full-text indexes the registry maintains. Masked fields are not
+// searched: a hit would confirm a value the role cannot see.
+
+import (
+	"errors"
+	"fmt"
+	"server/internal/config"
+	"server/internal/permission"
+	"server/internal/registry"
+	"server/pkg/utils"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 5 // hits per resource
+	maxSearchLimit     = 20
+	minSearchLength    = 2
+	maxSearchTerms     = 10
+)
+
+// SearchGroup holds the hits of one resource, best first
+type SearchGroup struct {
+	Resource    string      `json:"resource"`
+	DisplayName string      `json:"display_name"`
+	Results     []SearchHit `json:"results"`
+}
+
+type SearchHit struct {
+	ID            int                    `json:"id"`
+	Rank          float64                `json:"rank"`
+	MatchedFields []string               `json:"matched_fields"`
+	Record        map[string]interface{} `json:"record"`
+}
+
+// searchQuery turns user input into a prefix query: "Acme co" becomes "acme:* & co:*".
+// Only letters and digits survive, so the result is always a valid tsquery.
+func searchQuery(input string) string {
+	words := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > maxSearchTerms {
+		words = words[:maxSearchTerms]
+	}
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// Search returns up to limit hits per resource, grouped by resource; groups with the best hit come first
+func (r *Repository) Search(input string, user *utils.Claims, limit int) ([]SearchGroup, error) {
+	tsquery := searchQuery(input)
+	if len([]rune(strings.TrimSpace(input))) < minSearchLength || tsquery == "" {
+		return nil, &QueryError{Message: fmt.Sprintf("q must contain at least %d characters", minSearchLength)}
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	names, err := r.readableResources(user)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []SearchGroup{}
+	for _, name := range names {
+		group, err := r.searchResource(name, tsquery, user, limit)
+		if err != nil {
+			return nil, err
+		}
+		if group != nil {
+			groups = append(groups, *group)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Results[0].Rank > groups[j].Results[0].Rank
+	})
+	return groups, nil
+}
+
+// readableResources lists the resources the caller may read, by name
+func (r *Repository) readableResources(user *utils.Claims) ([]string, error) {
+	names := []string{}
+	if user.RoleID == 1 {
+		list, err := r.Registry.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range list {
+			if !res.IsSystem {
+				names = append(names, res.Name)
+			}
+		}
+	} else {
+		grants, err := permission.GrantedActions(user.RoleID)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range grants {
+			if g.Action == permission.ActionRead {
+				names = append(names, g.Resource)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// searchResource searches one resource; nil if it has no searchable field or no hit
+func (r *Repository) searchResource(resource, tsquery string, user *utils.Claims, limit int) (*SearchGroup, error) {
+	schema, err := r.schemaFor(resource)
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil, nil // system resource
+	}
+	if err != nil {
+		return nil, err
+	}
+	viewFields, _, masks, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	searchable := []string{}
+	for _, f := range schema.resource.Fields {
+		if _, ok := schema.types[f.Name]; ok && registry.IsText(f.DataType) && canView(viewFields, f.Name) {
+			searchable = append(searchable, f.Name)
+		}
+	}
+	if len(searchable) == 0 {
+		return nil, nil
+	}
+	_, computed, _ := schema.splitComputed(nil, viewFields)
+
+	// Same columns as a list: hidden fields never leave the database
+	cols := []string{quoteIdent("id")}
+	for col := range schema.types {
+		if col == "id" || col == registry.DeletedAtColumn {
+			continue
+		}
+		if _, masked := masks[col]; canView(viewFields, col) || masked {
+			cols = append(cols, quoteIdent(col))
+		}
+	}
+
+	query := fmt.Sprintf("to_tsquery('%s', $1)", registry.SearchConfig)
+	vectors := make([]string, len(searchable))
+	matches := make([]string, len(searchable))
+	for i, f := range searchable {
+		vectors[i] = registry.SearchVector(f)
+		matches[i] = vectors[i] + " @@ " + query
+		cols = append(cols, fmt.Sprintf(`%s AS "__match_%d"`, matches[i], i))
+	}
+	cols = append(cols, fmt.Sprintf(`ts_rank(%s, %s) AS "__rank"`, strings.Join(vectors, " || "), query))
+
+	conds := []string{"(" + strings.Join(matches, " OR ") + ")"}
+	if live := schema.liveOnly(); live != "" {
+		conds = append(conds, live)
+	}
+	scope, scopeArgs, err := r.rowScope(schema, user, ScopeRead, 2)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" {
+		conds = append(conds, scope)
+	}
+
+	stmt := fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY "__rank" DESC, "id" LIMIT %d`,
+		strings.Join(cols, ", "), schema.table, strings.Join(conds, " AND "), limit)
+	rows, err := config.DB.Query(stmt, append([]interface{}{tsquery}, scopeArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	group := &SearchGroup{Resource: schema.resource.Name, DisplayName: schema.resource.DisplayName, Results: []SearchHit{}}
+	err = scanRows(rows, func(row map[string]interface{}) error {
+		hit := SearchHit{MatchedFields: []string{}}
+		for i, f := range searchable {
+			key := fmt.Sprintf("__match_%d", i)
+			if matched, _ := row[key].(bool); matched {
+				hit.MatchedFields = append(hit.MatchedFields, f)
+			}
+			delete(row, key)
+		}
+		hit.Rank, _ = row["__rank"].(float64)
+		delete(row, "__rank")
+		if id, ok := row["id"].(int64); ok {
+			hit.ID = int(id)
+		}
+		hit.Record = projectFields(viewFields, masks, row)
+		addComputed(hit.Record, row, computed)
+		delete(hit.Record, registry.VersionColumn)
+		group.Results = append(group.Results, hit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(group.Results) == 0 {
+		return nil, nil
+	}
+	return group, nil
+}
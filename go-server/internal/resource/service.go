@@ -1,47 +1,321 @@
 package resource
 
-import "errors"
+import (
+	"server/internal/history"
+	"server/internal/permission"
+	"server/pkg/utils"
+	"strconv"
+	"time"
+)
 
 type Service struct {
 	Repo *Repository
+
+	// LenientWrites makes writes ignore (and report) fields the caller may not set
+	// instead of rejecting the request. Can be overridden per request.
+	LenientWrites bool
+}
+
+func (s *Service) GetAll(resource string, user *utils.Claims, q *ListQuery) (*Page, error) {
+	// Check permission
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "read")
+	if !allowed {
+		return nil, ErrPermissionDenied
+	}
+
+	return s.Repo.GetAll(resource, user, q)
 }
 
-func (s *Service) GetAll(resource string, roleID int) ([]map[string]interface{}, error) {
+func (s *Service) Stream(resource string, user *utils.Claims, q *ListQuery, fn func(map[string]interface{}) error) error {
 	// Check permission
-	allowed, _ := s.Repo.HasPermission(roleID, resource, "read")
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "read")
 	if !allowed {
-		return nil, errors.New("permission denied")
+		return ErrPermissionDenied
 	}
 
-	return s.Repo.GetAll(resource, roleID)
+	return s.Repo.Stream(resource, user, q, fn)
 }
 
-func (s *Service) Create(resource string, data map[string]interface{}, roleID int) (int, error) {
+func (s *Service) GetByID(resource, id string, user *utils.Claims, fields []string) (*Record, error) {
 	// Check permission
-	allowed, _ := s.Repo.HasPermission(roleID, resource, "create")
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "read")
 	if !allowed {
-		return 0, errors.New("permission denied")
+		return nil, ErrPermissionDenied
+	}
+
+	recordID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	data, err := s.Repo.GetByID(resource, id, user, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// Field-level edit rights only matter if the role may update the table at all
+	editable := []string{}
+	if canUpdate, _ := s.Repo.HasPermission(user.RoleID, resource, "update"); canUpdate {
+		editable, err = s.Repo.EditableFields(resource, user.RoleID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	record := &Record{Data: data, EditableFields: editable}
+	record.PendingChanges, err = s.Repo.PendingChanges(resource, recordID, user)
+	if err != nil {
+		return nil, err
 	}
+	return record, nil
+}
 
-	return s.Repo.Create(resource, data, roleID)
+// CheckRecord reports ErrNotFound unless the record is live and within the caller's read scope.
+// Table-level permissions are left to the caller.
+func (s *Service) CheckRecord(resource, id string, user *utils.Claims) error {
+	if _, err := strconv.Atoi(id); err != nil {
+		return ErrNotFound
+	}
+	_, err := s.Repo.GetByID(resource, id, user, []string{"id"})
+	return err
 }
 
-func (s *Service) Update(resource, id string, data map[string]interface{}, roleID int) error {
+func (s *Service) Create(resource string, data map[string]interface{}, user *utils.Claims, strict bool) (*WriteResult, error) {
 	// Check permission
-	allowed, _ := s.Repo.HasPermission(roleID, resource, "update")
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "create")
 	if !allowed {
-		return errors.New("permission denied")
+		return nil, ErrPermissionDenied
 	}
 
-	return s.Repo.Update(resource, id, data, roleID)
+	return s.Repo.Create(resource, data, user, strict)
 }
 
-func (s *Service) Delete(resource, id string, roleID int) error {
+func (s *Service) Update(resource, id string, data map[string]interface{}, user *utils.Claims, strict bool, pre *Precondition) (*WriteResult, error) {
 	// Check permission
-	allowed, _ := s.Repo.HasPermission(roleID, resource, "delete")
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "update")
 	if !allowed {
-		return errors.New("permission denied")
+		return nil, ErrPermissionDenied
+	}
+
+	if _, err := strconv.Atoi(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	return s.Repo.Update(resource, id, data, user, strict, pre)
+}
+
+func (s *Service) Lookup(resource string, user *utils.Claims, label, prefix string, limit int) ([]LookupItem, error) {
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "read")
+	if !allowed {
+		return nil, ErrPermissionDenied
+	}
+
+	return s.Repo.Lookup(resource, user, label, prefix, limit)
+}
+
+// Expand embeds referenced records; permissions on each target resource are checked per record
+func (s *Service) Expand(resource string, user *utils.Claims, rows []map[string]interface{}, fields []string) error {
+	return s.Repo.Expand(resource, user, rows, fields)
+}
+
+func (s *Service) Aggregate(resource string, user *utils.Claims, q *AggregateQuery) (*AggregateResult, error) {
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "read")
+	if !allowed {
+		return nil, ErrPermissionDenied
+	}
+
+	return s.Repo.Aggregate(resource, user, q)
+}
+
+// Search covers every resource the caller can read; field and row permissions apply per resource
+func (s *Service) Search(input string, user *utils.Claims, limit int) ([]SearchGroup, error) {
+	return s.Repo.Search(input, user, limit)
+}
+
+// PlanExport requires both read and export: reading a resource does not imply exporting it
+func (s *Service) PlanExport(resource string, user *utils.Claims, q *ListQuery) (*Export, error) {
+	for _, action := range []string{"read", "export"} {
+		allowed, _ := s.Repo.HasPermission(user.RoleID, resource, action)
+		if !allowed {
+			return nil, ErrPermissionDenied
+		}
+	}
+
+	return s.Repo.PlanExport(resource, user, q)
+}
+
+// AuthorizeImport checks the table-level permissions an import needs: create, and update
+// when rows may match existing records by key
+func (s *Service) AuthorizeImport(resource string, user *utils.Claims, opts ImportOptions) error {
+	actions := []string{"create"}
+	if opts.Key != "" {
+		actions = append(actions, "update")
+	}
+	for _, action := range actions {
+		allowed, _ := s.Repo.HasPermission(user.RoleID, resource, action)
+		if !allowed {
+			return ErrPermissionDenied
+		}
+	}
+	return nil
+}
+
+func (s *Service) Import(resource string, src *ImportSource, opts ImportOptions, user *utils.Claims, progress func(int)) (*ImportResult, error) {
+	if err := s.AuthorizeImport(resource, user, opts); err != nil {
+		return nil, err
+	}
+
+	return s.Repo.Import(resource, src, opts, user, progress)
+}
+
+// Batch authorizes every kind of operation in the batch up front, so a batch either runs
+// with all the permissions it needs or not at all
+func (s *Service) Batch(resource string, req *BatchRequest, user *utils.Claims, strict bool) ([]BatchResult, bool, error) {
+	actions, err := req.actions()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, action := range actions {
+		allowed, _ := s.Repo.HasPermission(user.RoleID, resource, action)
+		if !allowed {
+			return nil, false, ErrPermissionDenied
+		}
+	}
+
+	return s.Repo.Batch(resource, req, user, strict)
+}
+
+func (s *Service) Delete(resource, id string, user *utils.Claims, pre *Precondition) error {
+	// Check permission
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "delete")
+	if !allowed {
+		return ErrPermissionDenied
+	}
+
+	return s.Repo.Delete(resource, id, user, pre)
+}
+
+// GetTrash lists soft-deleted records; viewing the trash requires the restore action
+func (s *Service) GetTrash(resource string, user *utils.Claims, q *ListQuery) (*Page, error) {
+	// Check permission
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, permission.ActionRestore)
+	if !allowed {
+		return nil, ErrPermissionDenied
+	}
+
+	return s.Repo.GetTrash(resource, user, q)
+}
+
+func (s *Service) Restore(resource, id string, user *utils.Claims) error {
+	// Check permission
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, permission.ActionRestore)
+	if !allowed {
+		return ErrPermissionDenied
+	}
+
+	if _, err := strconv.Atoi(id); err != nil {
+		return ErrNotFound
+	}
+
+	return s.Repo.Restore(resource, id, user)
+}
+
+func (s *Service) Purge(resource, id string, user *utils.Claims) error {
+	// Check permission
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, permission.ActionPurge)
+	if !allowed {
+		return ErrPermissionDenied
+	}
+
+	if _, err := strconv.Atoi(id); err != nil {
+		return ErrNotFound
+	}
+
+	return s.Repo.Purge(resource, id, user)
+}
+
+// GetAsOf returns a record as it was at t. Historic versions are read-only, so no fields are editable.
+func (s *Service) GetAsOf(resource, id string, user *utils.Claims, fields []string, t time.Time) (*Record, error) {
+	// Check permission
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, permission.ActionRead)
+	if !allowed {
+		return nil, ErrPermissionDenied
+	}
+
+	data, err := s.Repo.GetAsOf(resource, id, user, fields, t)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{Data: data, EditableFields: []string{}}, nil
+}
+
+func (s *Service) GetHistory(resource, id string, user *utils.Claims) ([]history.Entry, error) {
+	// Check permission
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, permission.ActionRead)
+	if !allowed {
+		return nil, ErrPermissionDenied
+	}
+
+	return s.Repo.GetHistory(resource, id, user)
+}
+
+// PendingChanges lists the changes awaiting review on a resource; only its approvers may see them all
+func (s *Service) PendingChanges(resource string, user *utils.Claims) ([]PendingChange, error) {
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "read")
+	if !allowed {
+		return nil, ErrPermissionDenied
+	}
+	schema, err := s.Repo.schemaFor(resource)
+	if err != nil {
+		return nil, err
+	}
+	if !schema.canApprove(user) {
+		return nil, ErrPermissionDenied
+	}
+
+	return s.Repo.PendingChanges(resource, 0, user)
+}
+
+// ReviewChange approves or rejects a pending change; the approver role is checked by the repository
+func (s *Service) ReviewChange(resource, changeID string, approve bool, user *utils.Claims) (*PendingChange, error) {
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, "read")
+	if !allowed {
+		return nil, ErrPermissionDenied
+	}
+
+	id, err := strconv.Atoi(changeID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	return s.Repo.ReviewChange(resource, id, approve, user)
+}
+
+// Transitions lists the state changes the caller may make on a record; none without update permission
+func (s *Service) Transitions(resource, id string, user *utils.Claims) ([]AvailableTransition, error) {
+	// Check permission
+	allowed, _ := s.Repo.HasPermission(user.RoleID, resource, permission.ActionRead)
+	if !allowed {
+		return nil, ErrPermissionDenied
+	}
+	if _, err := strconv.Atoi(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	if canUpdate, _ := s.Repo.HasPermission(user.RoleID, resource, permission.ActionUpdate); !canUpdate {
+		return []AvailableTransition{}, nil
+	}
+	return s.Repo.Transitions(resource, id, user)
+}
+
+// Revert requires both read and update: it reads the record's history before changing it
+func (s *Service) Revert(resource, id string, entryID int, user *utils.Claims, pre *Precondition) (*WriteResult, error) {
+	for _, action := range []string{permission.ActionRead, permission.ActionUpdate} {
+		allowed, _ := s.Repo.HasPermission(user.RoleID, resource, action)
+		if !allowed {
+			return nil, ErrPermissionDenied
+		}
 	}
 
-	return s.Repo.Delete(resource, id)
+	return s.Repo.Revert(resource, id, entryID, user, pre)
 }
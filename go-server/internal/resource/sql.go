@@ -0,0 +1,124 @@
+package resource
+
+// SQL builders for the generic resource tables. Every identifier that reaches a query
+// comes from a tableSchema (registered in resource_fields AND present on the live table)
+// and is quoted; request data only ever travels as bind parameters.
+
+import (
+	"fmt"
+	"server/internal/registry"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// tableSchema is the set of identifiers a request may touch for one resource
+type tableSchema struct {
+	resource *registry.Resource
+	table    string            // quoted table name
+	types    map[string]string // readable columns -> data_type, including system columns
+	writable map[string]bool   // registered, non-system columns
+
+	hasTrash   bool // table has deleted_at; rows with it set are in the trash
+	softDelete bool // DELETE moves rows to the trash instead of removing them
+	versioned  bool // table has the version column used for ETags
+
+	computed map[string]*computedField // computed fields whose inputs are all on the table
+}
+
+func quoteIdent(name string) string {
+	return pq.QuoteIdentifier(name)
+}
+
+// checkColumns rejects any key that is not a writable column of the table
+func (s *tableSchema) checkColumns(data map[string]interface{}) error {
+	unknown := []string{}
+	for k := range data {
+		if !s.writable[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &UnknownFieldError{Fields: unknown}
+	}
+	return nil
+}
+
+// buildInsert returns an INSERT ... RETURNING id statement for data
+func buildInsert(s *tableSchema, data map[string]interface{}) (string, []interface{}, error) {
+	if err := s.checkColumns(data); err != nil {
+		return "", nil, err
+	}
+
+	if len(data) == 0 {
+		// Insert with defaults (fails if NOT NULL columns are missing, which is expected)
+		return fmt.Sprintf(`INSERT INTO %s DEFAULT VALUES RETURNING "id"`, s.table), nil, nil
+	}
+
+	keys := sortedKeys(data)
+	cols := make([]string, len(keys))
+	placeholders := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, k := range keys {
+		cols[i] = quoteIdent(k)
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = data[k]
+	}
+
+	query := fmt.Sprintf(
+		`INSERT INTO %s (%s) VALUES (%s) RETURNING "id"`,
+		s.table,
+		strings.Join(cols, ", "),
+		strings.Join(placeholders, ", "),
+	)
+	return query, args, nil
+}
+
+// buildUpdate returns an UPDATE statement for one row; an empty query means there is nothing to set
+func buildUpdate(s *tableSchema, id string, data map[string]interface{}) (string, []interface{}, error) {
+	if err := s.checkColumns(data); err != nil {
+		return "", nil, err
+	}
+	if len(data) == 0 {
+		return "", nil, nil
+	}
+
+	keys := sortedKeys(data)
+	sets := make([]string, len(keys))
+	args := make([]interface{}, 0, len(keys)+1)
+	for i, k := range keys {
+		sets[i] = fmt.Sprintf("%s = $%d", quoteIdent(k), i+1)
+		args = append(args, data[k])
+	}
+	if v := s.versionSet(); v != "" {
+		sets = append(sets, v)
+	}
+	args = append(args, id)
+
+	query := fmt.Sprintf(
+		`UPDATE %s SET %s WHERE "id" = $%d`,
+		s.table,
+		strings.Join(sets, ", "),
+		len(keys)+1,
+	)
+	return query, args, nil
+}
+
+// liveOnly is the condition excluding trashed rows ("" if the table has no trash)
+func (s *tableSchema) liveOnly() string {
+	if !s.hasTrash {
+		return ""
+	}
+	return quoteIdent(registry.DeletedAtColumn) + " IS NULL"
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,220 @@
+package resource
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func testSchema() *tableSchema {
+	return &tableSchema{
+		table: quoteIdent("orders"),
+		types: map[string]string{
+			"id":            "number",
+			"created_at":    "datetime",
+			"customer_name": "text",
+			"amount":        "number",
+			"status":        "text",
+		},
+		writable: map[string]bool{"customer_name": true, "amount": true, "status": true},
+	}
+}
+
+var quotedIdent = regexp.MustCompile(`"(?:[^"]|"")*"`)
+
+// Once quoted identifiers are removed, only keywords, operators and placeholders may remain
+var sqlSkeleton = regexp.MustCompile(`^[A-Z ()<>=,$0-9]*$`)
+
+// assertIdentifiers checks that every identifier in sql is one of the allowed columns
+// and that nothing else from the request leaked into the statement text.
+func assertIdentifiers(t *testing.T, sql string, allowed map[string]bool) {
+	t.Helper()
+	for _, ident := range quotedIdent.FindAllString(sql, -1) {
+		name := strings.ReplaceAll(ident[1:len(ident)-1], `""`, `"`)
+		if !allowed[name] {
+			t.Fatalf("identifier %s is not an allowed column in %q", ident, sql)
+		}
+	}
+	if rest := quotedIdent.ReplaceAllString(sql, ""); !sqlSkeleton.MatchString(rest) {
+		t.Fatalf("unexpected text outside identifiers: %q (from %q)", rest, sql)
+	}
+}
+
+func allowedColumns(s *tableSchema) map[string]bool {
+	allowed := map[string]bool{"orders": true}
+	for c := range s.types {
+		allowed[c] = true
+	}
+	return allowed
+}
+
+func TestBuildInsertRejectsUnknownKeys(t *testing.T) {
+	s := testSchema()
+	data := map[string]interface{}{
+		"amount":                      10,
+		"status) VALUES (1); DROP --": "x",
+	}
+
+	_, _, err := buildInsert(s, data)
+	var unknown *UnknownFieldError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("expected UnknownFieldError, got %v", err)
+	}
+	if !reflect.DeepEqual(unknown.Fields, []string{"status) VALUES (1); DROP --"}) {
+		t.Fatalf("unexpected fields %v", unknown.Fields)
+	}
+}
+
+func TestBuildInsertQuotesColumns(t *testing.T) {
+	query, args, err := buildInsert(testSchema(), map[string]interface{}{"status": "open", "amount": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `INSERT INTO "orders" ("amount", "status") VALUES ($1, $2) RETURNING "id"`
+	if query != want {
+		t.Fatalf("got %q, want %q", query, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{5, "open"}) {
+		t.Fatalf("unexpected args %v", args)
+	}
+}
+
+// Property: for any body, the statement either fails with exactly the unknown keys,
+// or its column list is exactly the (quoted) body keys, all of which are writable.
+func TestWriteBuildersProperty(t *testing.T) {
+	s := testSchema()
+	pool := []string{"customer_name", "amount", "status", "id", "created_at", `a"b`, "x; DROP TABLE orders", ""}
+
+	property := func(picks []uint8, values []string, id string) bool {
+		data := map[string]interface{}{}
+		for i, p := range picks {
+			key := pool[int(p)%len(pool)]
+			if i < len(values) {
+				data[key] = values[i]
+			} else {
+				data[key] = nil
+			}
+		}
+
+		wantUnknown := []string{}
+		for k := range data {
+			if !s.writable[k] {
+				wantUnknown = append(wantUnknown, k)
+			}
+		}
+		sort.Strings(wantUnknown)
+
+		for _, build := range []func() (string, []interface{}, error){
+			func() (string, []interface{}, error) { return buildInsert(s, data) },
+			func() (string, []interface{}, error) { return buildUpdate(s, id, data) },
+		} {
+			query, args, err := build()
+			if len(wantUnknown) > 0 {
+				var unknown *UnknownFieldError
+				if !errors.As(err, &unknown) || !reflect.DeepEqual(unknown.Fields, wantUnknown) {
+					return false
+				}
+				continue
+			}
+			if err != nil {
+				return false
+			}
+			if query == "" {
+				continue // empty update
+			}
+			assertIdentifiers(t, query, allowedColumns(s))
+			if len(args) < len(data) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func FuzzBuildInsert(f *testing.F) {
+	f.Add("status", "open")
+	f.Add(`status" = 1; --`, "x")
+	f.Add("amount) VALUES (1) RETURNING *; --", "1")
+
+	s := testSchema()
+	f.Fuzz(func(t *testing.T, key, value string) {
+		query, args, err := buildInsert(s, map[string]interface{}{key: value, "amount": 1})
+		if !s.writable[key] {
+			var unknown *UnknownFieldError
+			if !errors.As(err, &unknown) {
+				t.Fatalf("key %q accepted: %q", key, query)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIdentifiers(t, query, allowedColumns(s))
+		for _, a := range args {
+			if a == value {
+				return
+			}
+		}
+		t.Fatalf("value %q not passed as a parameter", value)
+	})
+}
+
+func FuzzBuildUpdate(f *testing.F) {
+	f.Add("status", "open", "1")
+	f.Add("status = 'x', amount", "1", "1 OR 1=1")
+
+	s := testSchema()
+	f.Fuzz(func(t *testing.T, key, value, id string) {
+		query, args, err := buildUpdate(s, id, map[string]interface{}{key: value})
+		if !s.writable[key] {
+			if err == nil {
+				t.Fatalf("key %q accepted: %q", key, query)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIdentifiers(t, query, allowedColumns(s))
+		if args[len(args)-1] != id {
+			t.Fatalf("id not passed as the last parameter")
+		}
+	})
+}
+
+// Any query string either fails validation or compiles to SQL that only references schema columns
+func FuzzListQuery(f *testing.F) {
+	f.Add("filter[status]=open&filter[amount][gte]=10&sort=-created_at&fields=status")
+	f.Add(`filter[status"%3B DROP TABLE orders; --]=x`)
+	f.Add("sort=amount)%2C(SELECT 1&fields=id")
+
+	s := testSchema()
+	f.Fuzz(func(t *testing.T, raw string) {
+		values, err := url.ParseQuery(raw)
+		if err != nil {
+			return
+		}
+		q, err := ParseListQuery(values)
+		if err != nil {
+			return
+		}
+		if err := q.validate(s.types, nil); err != nil {
+			return
+		}
+		where, _, err := q.buildWhere(s.types, 1)
+		if err != nil {
+			return
+		}
+		assertIdentifiers(t, where, allowedColumns(s))
+		assertIdentifiers(t, buildOrderBy(q.orderFields()), allowedColumns(s))
+	})
+}
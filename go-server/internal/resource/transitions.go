@@ -0,0 +1,165 @@
+package resource
+
+// State machines (field_transitions) restrict how a text or enum field such as orders.status may
+// change: Pending -> Open -> In Progress, but not back to Pending. A transition from "" names a
+// state new records may start in; without one, records can be created in any state. Each
+// transition is granted to roles on its own (role_transition_permissions), on top of can_update
+// on the field. The admin role may perform every transition.
+
+import (
+	"fmt"
+	"server/internal/config"
+	"server/internal/history"
+	"server/pkg/utils"
+)
+
+// AvailableTransition is a move the caller may make on a record right now
+type AvailableTransition struct {
+	Field string `json:"field"`
+	Name  string `json:"name"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// stateOf reads a state field's value; null is the empty state
+func stateOf(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// canTransition reports whether the writer's role has been granted a transition
+func (r *Repository) canTransition(q history.Querier, w *writer, transitionID int) (bool, error) {
+	if w.user.RoleID == 1 {
+		return true, nil
+	}
+	if w.grants == nil {
+		rows, err := q.Query(`
+			SELECT rtp.transition_id
+			FROM role_transition_permissions rtp
+			JOIN field_transitions ft ON rtp.transition_id = ft.id
+			JOIN resource_fields rf ON ft.resource_field_id = rf.id
+			JOIN resources res ON rf.resource_id = res.id
+			WHERE rtp.role_id = $1 AND res.name = $2
+		`, w.user.RoleID, w.schema.resource.Name)
+		if err != nil {
+			return false, err
+		}
+		defer rows.Close()
+
+		grants := map[int]bool{}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return false, err
+			}
+			grants[id] = true
+		}
+		if err := rows.Err(); err != nil {
+			return false, err
+		}
+		w.grants = grants
+	}
+	return w.grants[transitionID], nil
+}
+
+// checkTransitions enforces the state machines on a write from before to after (before is nil on
+// create). Like checkImmutable it compares stored rows once the statement matched; a move the
+// machine does not define is a validation error, one the role was not granted is denied.
+func (r *Repository) checkTransitions(q history.Querier, w *writer, before, after map[string]interface{}) error {
+	if after == nil {
+		return nil // deleted
+	}
+	errs := []FieldError{}
+	for _, f := range w.schema.resource.Fields {
+		if len(f.Transitions) == 0 || !w.schema.writable[f.Name] {
+			continue
+		}
+		from, to := stateOf(before[f.Name]), stateOf(after[f.Name])
+		if before != nil && from == to {
+			continue
+		}
+		if before == nil && (to == "" || !f.HasInitialStates()) {
+			continue
+		}
+
+		t, ok := f.Transition(from, to)
+		if !ok {
+			msg := fmt.Sprintf("cannot change from %q to %q", from, to)
+			if before == nil {
+				msg = fmt.Sprintf("new records cannot start as %q", to)
+			}
+			errs = append(errs, FieldError{f.Name, "transition", msg})
+			continue
+		}
+		granted, err := r.canTransition(q, w, t.ID)
+		if err != nil {
+			return err
+		}
+		if !granted {
+			return fmt.Errorf("%w: transition %q of field %q", ErrPermissionDenied, t.Name, f.Name)
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// Transitions lists the moves the caller may make on a record: from the current state of each
+// state field it can view and update, the transitions it was granted. Records outside the
+// caller's update scope have none.
+func (r *Repository) Transitions(resource, id string, user *utils.Claims) ([]AvailableTransition, error) {
+	record, err := r.GetByID(resource, id, user, nil)
+	if err != nil {
+		return nil, err
+	}
+	w, err := r.newWriter(resource, user)
+	if err != nil {
+		return nil, err
+	}
+	viewFields, _, _, err := r.getAllowedFields(user.RoleID, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	available := []AvailableTransition{}
+	scope, args, err := r.scope(w, ScopeUpdate, 2)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" {
+		var inScope bool
+		query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE "id" = $1 AND %s)`, w.schema.table, scope)
+		if err := config.DB.QueryRow(query, append([]interface{}{id}, args...)...).Scan(&inScope); err != nil {
+			return nil, err
+		}
+		if !inScope {
+			return available, nil
+		}
+	}
+
+	for _, f := range w.schema.resource.Fields {
+		if len(f.Transitions) == 0 || f.Immutable || !canView(viewFields, f.Name) || !w.canWrite(f.Name, false) {
+			continue
+		}
+		from := stateOf(record[f.Name])
+		for _, t := range f.Transitions {
+			if t.From != from {
+				continue
+			}
+			granted, err := r.canTransition(config.DB, w, t.ID)
+			if err != nil {
+				return nil, err
+			}
+			if granted {
+				available = append(available, AvailableTransition{Field: f.Name, Name: t.Name, From: t.From, To: t.To})
+			}
+		}
+	}
+	return available, nil
+}
@@ -0,0 +1,66 @@
+package resource
+
+import (
+	"errors"
+	"reflect"
+	"server/internal/registry"
+	"server/pkg/utils"
+	"testing"
+)
+
+func stateSchema() *tableSchema {
+	s := testSchema()
+	s.resource = &registry.Resource{Name: "orders", Fields: []registry.Field{
+		{Name: "status", DataType: "text", Transitions: []registry.Transition{
+			{ID: 1, Name: "submit", From: "", To: "Pending"},
+			{ID: 2, Name: "open", From: "Pending", To: "Open"},
+			{ID: 3, Name: "close", From: "Open", To: "Closed"},
+		}},
+		{Name: "amount", DataType: "number"},
+	}}
+	return s
+}
+
+func TestCheckTransitions(t *testing.T) {
+	row := func(status interface{}) map[string]interface{} {
+		return map[string]interface{}{"status": status, "amount": int64(5)}
+	}
+	tests := []struct {
+		name          string
+		role          int
+		before, after map[string]interface{}
+		codes         []string
+		denied        bool
+	}{
+		{name: "granted move", role: 2, before: row("Pending"), after: row("Open"), codes: []string{}},
+		{name: "unchanged state", role: 2, before: row("Closed"), after: row("Closed"), codes: []string{}},
+		{name: "undefined move", role: 2, before: row("Open"), after: row("Pending"), codes: []string{"status:transition"}},
+		{name: "move not granted", role: 2, before: row("Open"), after: row("Closed"), denied: true},
+		{name: "admin", role: 1, before: row("Open"), after: row("Closed"), codes: []string{}},
+		{name: "initial state", role: 2, after: row("Pending"), denied: true},
+		{name: "create in another state", role: 2, after: row("Open"), codes: []string{"status:transition"}},
+		{name: "create without state", role: 2, after: row(nil), codes: []string{}},
+		{name: "delete", role: 2, before: row("Open"), codes: []string{}},
+	}
+	for _, tt := range tests {
+		w := &writer{schema: stateSchema(), user: &utils.Claims{RoleID: tt.role}, grants: map[int]bool{2: true}}
+		err := (&Repository{}).checkTransitions(nil, w, tt.before, tt.after)
+		if tt.denied {
+			if !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("%s: got %v, want ErrPermissionDenied", tt.name, err)
+			}
+			continue
+		}
+		if got := fieldCodes(t, err); !reflect.DeepEqual(got, tt.codes) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.codes)
+		}
+	}
+}
+
+func TestStateOf(t *testing.T) {
+	for in, want := range map[interface{}]string{nil: "", "Open": "Open", int64(3): "3"} {
+		if got := stateOf(in); got != want {
+			t.Errorf("%#v: got %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package resource
+
+// Field data types (resource_fields.data_type). Each type parses request values, from JSON
+// bodies and query strings alike, into the value that is stored, and decides which filter
+// operators apply. Values read back are typed by scanRows from the Postgres column type.
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type fieldType struct {
+	operators map[string]bool
+	// parse normalizes a non-empty value; the error completes "<field> ..." (e.g. "must be a date")
+	parse func(v interface{}) (interface{}, error)
+	text  bool // stored as TEXT: blank values are kept, length and pattern rules apply
+}
+
+func ops(names ...string) map[string]bool {
+	m := map[string]bool{}
+	for _, n := range names {
+		m[n] = true
+	}
+	return m
+}
+
+var (
+	comparisonOps = ops("eq", "ne", "gt", "gte", "lt", "lte", "in", "null")
+	textOps       = ops("eq", "ne", "like", "in", "null")
+)
+
+var fieldTypes = map[string]fieldType{
+	"text":      {operators: textOps, parse: parseString, text: true},
+	"email":     {operators: textOps, parse: parseEmail, text: true},
+	"enum":      {operators: ops("eq", "ne", "in", "null"), parse: parseString, text: true},
+	"number":    {operators: comparisonOps, parse: parseInteger}, // original name of integer
+	"integer":   {operators: comparisonOps, parse: parseInteger},
+	"decimal":   {operators: comparisonOps, parse: parseDecimal},
+	"boolean":   {operators: ops("eq", "ne", "null"), parse: parseBoolean},
+	"date":      {operators: comparisonOps, parse: parseDate},
+	"datetime":  {operators: ops("eq", "ne", "gt", "gte", "lt", "lte", "null"), parse: parseDatetime},
+	"json":      {operators: ops("null"), parse: parseJSON},
+	"reference": {operators: ops("eq", "ne", "in", "null"), parse: parseReference},
+}
+
+// typeOf returns the type of a data type name; unknown names behave as text, like their columns
+func typeOf(dataType string) fieldType {
+	if t, ok := fieldTypes[dataType]; ok {
+		return t
+	}
+	return fieldTypes["text"]
+}
+
+func parseString(v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.New("must be a string")
+	}
+	return s, nil
+}
+
+// parseEmail accepts a bare address and lowercases its domain
+func parseEmail(v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.New("must be a string")
+	}
+	s = strings.TrimSpace(s)
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s {
+		return nil, errors.New("must be an email address")
+	}
+	at := strings.LastIndex(s, "@")
+	return s[:at] + strings.ToLower(s[at:]), nil
+}
+
+func parseInteger(v interface{}) (interface{}, error) {
+	n, ok := toNumber(v)
+	if !ok {
+		return nil, errors.New("must be a number")
+	}
+	if n != math.Trunc(n) || n > math.MaxInt32 || n < math.MinInt32 {
+		return nil, errors.New("must be a whole number")
+	}
+	return int64(n), nil
+}
+
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// parseDecimal keeps the digits as given so amounts like 0.10 are stored exactly
+func parseDecimal(v interface{}) (interface{}, error) {
+	var s string
+	switch n := v.(type) {
+	case json.Number:
+		s = n.String()
+	case float64:
+		s = strconv.FormatFloat(n, 'f', -1, 64)
+	case int64:
+		s = strconv.FormatInt(n, 10)
+	case string:
+		s = strings.TrimSpace(n)
+	}
+	if !decimalPattern.MatchString(s) {
+		return nil, errors.New("must be a number")
+	}
+	return s, nil
+}
+
+func parseBoolean(v interface{}) (interface{}, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(b)); err == nil {
+			return parsed, nil
+		}
+	}
+	return nil, errors.New("must be true or false")
+}
+
+// parseDate accepts YYYY-MM-DD or an RFC 3339 time, whose date is taken as written
+func parseDate(v interface{}) (interface{}, error) {
+	s, _ := v.(string)
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Format("2006-01-02"), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Format("2006-01-02"), nil
+	}
+	return nil, errors.New("must be a date (YYYY-MM-DD)")
+}
+
+// parseDatetime accepts RFC 3339 or YYYY-MM-DD (midnight UTC) and stores UTC
+func parseDatetime(v interface{}) (interface{}, error) {
+	if t, ok := v.(time.Time); ok {
+		return t.UTC(), nil
+	}
+	s, _ := v.(string)
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return nil, errors.New("must be a date and time (RFC 3339 or YYYY-MM-DD)")
+}
+
+// parseJSON stores any JSON value as is; a string is stored as a JSON string
+func parseJSON(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.New("must be a JSON value")
+	}
+	return string(b), nil
+}
+
+func parseReference(v interface{}) (interface{}, error) {
+	id, ok := toRecordID(v)
+	if !ok {
+		return nil, errors.New("must be a record id")
+	}
+	return id, nil
+}
+
+// columnValue types a scanned value by its Postgres column type, so JSON output carries
+// numbers, objects and dates instead of the driver's raw bytes
+func columnValue(dbType string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		switch dbType {
+		case "NUMERIC":
+			return json.Number(v)
+		case "JSON", "JSONB":
+			return json.RawMessage(v)
+		}
+		return string(v)
+	case time.Time:
+		if dbType == "DATE" {
+			return v.Format("2006-01-02")
+		}
+	}
+	return v
+}
@@ -0,0 +1,113 @@
+package resource
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFieldTypeParse(t *testing.T) {
+	tests := []struct {
+		dataType string
+		in       interface{}
+		want     interface{} // nil: the value is rejected
+	}{
+		{"text", "  kept as is ", "  kept as is "},
+		{"text", 12.0, nil},
+		{"email", " Ada@Example.COM ", "Ada@example.com"},
+		{"email", "Ada <ada@example.com>", nil},
+		{"email", "not an address", nil},
+		{"integer", json.Number("42"), int64(42)},
+		{"integer", " -7 ", int64(-7)},
+		{"integer", 4.5, nil},
+		{"integer", "3e10", nil},
+		{"number", 2.0, int64(2)},
+		{"decimal", json.Number("0.10"), "0.10"},
+		{"decimal", " -12.5 ", "-12.5"},
+		{"decimal", 1.25, "1.25"},
+		{"decimal", "1e3", "1e3"},
+		{"decimal", "1,5", nil},
+		{"decimal", "NaN", nil},
+		{"boolean", true, true},
+		{"boolean", " false ", false},
+		{"boolean", "yes", nil},
+		{"boolean", 1.0, nil},
+		{"date", "2024-02-29", "2024-02-29"},
+		{"date", "2024-02-29T23:30:00-05:00", "2024-02-29"},
+		{"date", "2023-02-29", nil},
+		{"date", "29/02/2024", nil},
+		{"datetime", "2024-02-29T23:30:00-05:00", time.Date(2024, 3, 1, 4, 30, 0, 0, time.UTC)},
+		{"datetime", "2024-02-29", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"datetime", "yesterday", nil},
+		{"json", map[string]interface{}{"a": []interface{}{1.0}}, `{"a":[1]}`},
+		{"json", "text", `"text"`},
+		{"reference", "12", int64(12)},
+		{"reference", 0.0, nil},
+		{"unknown_type", "treated as text", "treated as text"},
+	}
+	for _, tt := range tests {
+		got, err := typeOf(tt.dataType).parse(tt.in)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s %#v: accepted as %#v", tt.dataType, tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %#v: %v", tt.dataType, tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %#v: got %#v, want %#v", tt.dataType, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestColumnValue(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		dbType string
+		in     interface{}
+		want   interface{}
+	}{
+		{"NUMERIC", []byte("12.5000"), json.Number("12.5000")},
+		{"JSONB", []byte(`{"a":1}`), json.RawMessage(`{"a":1}`)},
+		{"TEXT", []byte("abc"), "abc"},
+		{"DATE", day, "2024-05-01"},
+		{"TIMESTAMP", day, day},
+		{"INT4", int64(3), int64(3)},
+		{"TEXT", nil, nil},
+	}
+	for _, tt := range tests {
+		if got := columnValue(tt.dbType, tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %#v: got %#v, want %#v", tt.dbType, tt.in, got, tt.want)
+		}
+	}
+}
+
+// Parsed values are stable: parsing a stored value again yields the same value
+func FuzzFieldTypeParse(f *testing.F) {
+	// json and datetime parse into other Go types than they accept
+	stable := []string{"text", "email", "integer", "decimal", "boolean", "date", "reference"}
+	f.Add(uint8(3), "0.10")
+	f.Add(uint8(2), "-12")
+	f.Add(uint8(5), "2024-02-29T23:30:00Z")
+	f.Add(uint8(1), "A@B.C")
+
+	f.Fuzz(func(t *testing.T, kind uint8, in string) {
+		dataType := stable[int(kind)%len(stable)]
+		ft := fieldTypes[dataType]
+		once, err := ft.parse(in)
+		if err != nil {
+			return
+		}
+		twice, err := ft.parse(once)
+		if err != nil {
+			t.Fatalf("%s %q parsed to %#v, which does not parse: %v", dataType, in, once, err)
+		}
+		if !reflect.DeepEqual(once, twice) {
+			t.Fatalf("%s %q: %#v parsed again to %#v", dataType, in, once, twice)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package resource
+
+// Write validation driven by resource_fields.validation. Runs before any SQL is built so
+// bad input is reported as a 422 with every field error, not as a database error.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"server/internal/history"
+	"server/internal/registry"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes one failed rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every field error of a write request
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return "validation failed"
+}
+
+// validateWrite parses data by field type and checks it against the field rules, replacing each
+// value by its normalized form. On create, required fields must be present; on update only the
+// supplied fields are checked. Keys that are not fields are left to checkColumns.
+func validateWrite(res *registry.Resource, data map[string]interface{}, isCreate bool) error {
+	errs := []FieldError{}
+
+	for _, field := range res.Fields {
+		if field.Computed() {
+			continue // rejected before validation, see tableSchema.readOnly
+		}
+		value, present := data[field.Name]
+		rules := field.Validation
+		ft := typeOf(field.DataType)
+
+		if isEmpty(value) {
+			if rules.Required && (present || isCreate) {
+				errs = append(errs, FieldError{field.Name, "required", "is required"})
+			}
+			if present && !ft.text {
+				data[field.Name] = nil // a blank number or date input clears the field
+			}
+			continue
+		}
+
+		parsed, err := ft.parse(value)
+		if err != nil {
+			errs = append(errs, FieldError{field.Name, "type", err.Error()})
+			continue
+		}
+		data[field.Name] = parsed
+		errs = append(errs, checkValue(field, parsed)...)
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// immutable reports whether a field cannot change once its record is created
+func (s *tableSchema) immutable(field string) bool {
+	f, ok := s.resource.Field(field)
+	return ok && f.Immutable
+}
+
+// checkImmutable reports the immutable fields a statement changed from before to after. It runs
+// once the statement matched the row, so records outside the caller's scope stay 404, and an
+// unchanged value sent back with the rest of a record is accepted. The caller rolls back.
+func (s *tableSchema) checkImmutable(before, after map[string]interface{}) error {
+	if after == nil {
+		return nil // deleted
+	}
+	errs := []FieldError{}
+	for _, f := range s.resource.Fields {
+		if f.Immutable && s.writable[f.Name] && !history.Equal(before[f.Name], after[f.Name]) {
+			errs = append(errs, FieldError{f.Name, "immutable", "cannot be changed once the record is created"})
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// checkValue applies the validation rules to a parsed value
+func checkValue(field registry.Field, value interface{}) []FieldError {
+	rules := field.Validation
+	errs := []FieldError{}
+	add := func(code, msg string) {
+		errs = append(errs, FieldError{field.Name, code, msg})
+	}
+
+	if n, ok := toNumber(value); ok && !typeOf(field.DataType).text {
+		if rules.Min != nil && n < *rules.Min {
+			add("min", fmt.Sprintf("must be at least %v", *rules.Min))
+		}
+		if rules.Max != nil && n > *rules.Max {
+			add("max", fmt.Sprintf("must be at most %v", *rules.Max))
+		}
+	}
+
+	if str, ok := value.(string); ok && typeOf(field.DataType).text {
+		if rules.MaxLength != nil && utf8.RuneCountInString(str) > *rules.MaxLength {
+			add("max_length", fmt.Sprintf("must be at most %d characters", *rules.MaxLength))
+		}
+		if rules.Pattern != "" {
+			// Patterns were compiled when saved; anchor them so they match the whole value
+			re, err := regexp.Compile("^(?:" + rules.Pattern + ")$")
+			if err != nil || !re.MatchString(str) {
+				add("pattern", "has an invalid format")
+			}
+		}
+	}
+
+	if len(rules.Enum) > 0 {
+		str := fmt.Sprint(value)
+		allowed := false
+		for _, e := range rules.Enum {
+			if e == str {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			add("enum", "must be one of: "+strings.Join(rules.Enum, ", "))
+		}
+	}
+
+	return errs
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	s, ok := v.(string)
+	return ok && strings.TrimSpace(s) == ""
+}
+
+// toRecordID accepts positive whole numbers, also as strings
+func toRecordID(v interface{}) (int64, bool) {
+	n, ok := toNumber(v)
+	if !ok || n < 1 || n != math.Trunc(n) || n > math.MaxInt32 {
+		return 0, false
+	}
+	return int64(n), true
+}
+
+// toNumber accepts JSON numbers and numeric strings (HTML number inputs submit strings)
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+	}
+	return 0, false
+}
@@ -0,0 +1,156 @@
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"server/internal/registry"
+	"sort"
+	"testing"
+)
+
+func validationResource() *registry.Resource {
+	lo, hi, length := 18.0, 65.0, 5
+	return &registry.Resource{Name: "people", Fields: []registry.Field{
+		{Name: "name", DataType: "text", Validation: registry.ValidationRules{Required: true, MaxLength: &length}},
+		{Name: "code", DataType: "text", Validation: registry.ValidationRules{Pattern: "[A-Z]{3}"}},
+		{Name: "age", DataType: "integer", Validation: registry.ValidationRules{Min: &lo, Max: &hi}},
+		{Name: "status", DataType: "enum", Validation: registry.ValidationRules{Enum: []string{"Open", "Closed"}}},
+		{Name: "start", DataType: "date"},
+		{Name: "label", DataType: "text", Expression: "upper(name)"},
+	}}
+}
+
+// fieldCodes lists the errors of a validation result as "field:code", sorted
+func fieldCodes(t *testing.T, err error) []string {
+	t.Helper()
+	codes := []string{}
+	if err == nil {
+		return codes
+	}
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	for _, e := range validationErr.Errors {
+		codes = append(codes, e.Field+":"+e.Code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func TestValidateWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     map[string]interface{}
+		isCreate bool
+		codes    []string
+		want     map[string]interface{} // normalized data, when valid
+	}{
+		{
+			name:     "valid create is normalized",
+			data:     map[string]interface{}{"name": "Ada", "age": "30", "start": "2024-05-01T10:00:00Z", "status": "Open"},
+			isCreate: true,
+			codes:    []string{},
+			want:     map[string]interface{}{"name": "Ada", "age": int64(30), "start": "2024-05-01", "status": "Open"},
+		},
+		{
+			name:     "required on create only",
+			data:     map[string]interface{}{"age": json.Number("20")},
+			isCreate: true,
+			codes:    []string{"name:required"},
+		},
+		{
+			name:  "partial update skips missing required fields",
+			data:  map[string]interface{}{"age": 20.0},
+			codes: []string{},
+			want:  map[string]interface{}{"age": int64(20)},
+		},
+		{
+			name:  "blank required value on update",
+			data:  map[string]interface{}{"name": "  "},
+			codes: []string{"name:required"},
+		},
+		{
+			name:  "blank number clears the field",
+			data:  map[string]interface{}{"age": ""},
+			codes: []string{},
+			want:  map[string]interface{}{"age": nil},
+		},
+		{
+			name:  "every rule reported at once",
+			data:  map[string]interface{}{"name": "Adelaide", "code": "ab1", "age": 70.0, "status": "Lost", "start": "May 1st"},
+			codes: []string{"age:max", "code:pattern", "name:max_length", "start:type", "status:enum"},
+		},
+		{
+			name:  "pattern is anchored",
+			data:  map[string]interface{}{"code": "XABCX"},
+			codes: []string{"code:pattern"},
+		},
+		{
+			name:  "max length counts characters",
+			data:  map[string]interface{}{"name": "Zoë Ü"},
+			codes: []string{},
+			want:  map[string]interface{}{"name": "Zoë Ü"},
+		},
+		{
+			name:  "types",
+			data:  map[string]interface{}{"name": 12.0, "age": "12.5"},
+			codes: []string{"age:type", "name:type"},
+		},
+		{
+			name:  "below min",
+			data:  map[string]interface{}{"age": int64(17)},
+			codes: []string{"age:min"},
+		},
+		{
+			name:  "computed fields are left alone",
+			data:  map[string]interface{}{"label": 1.0},
+			codes: []string{},
+			want:  map[string]interface{}{"label": 1.0},
+		},
+	}
+	for _, tt := range tests {
+		err := validateWrite(validationResource(), tt.data, tt.isCreate)
+		if got := fieldCodes(t, err); !reflect.DeepEqual(got, tt.codes) {
+			t.Errorf("%s: got errors %v, want %v", tt.name, got, tt.codes)
+			continue
+		}
+		if tt.want != nil && !reflect.DeepEqual(tt.data, tt.want) {
+			t.Errorf("%s: got data %#v, want %#v", tt.name, tt.data, tt.want)
+		}
+	}
+}
+
+func TestCheckImmutable(t *testing.T) {
+	s := testSchema()
+	s.resource = &registry.Resource{Fields: []registry.Field{
+		{Name: "customer_name", Immutable: true},
+		{Name: "amount"},
+	}}
+	before := map[string]interface{}{"customer_name": "Acme", "amount": int64(5)}
+
+	if err := s.checkImmutable(before, map[string]interface{}{"customer_name": "Acme", "amount": int64(9)}); err != nil {
+		t.Fatalf("unchanged immutable field rejected: %v", err)
+	}
+	if err := s.checkImmutable(before, nil); err != nil {
+		t.Fatalf("delete rejected: %v", err)
+	}
+	err := s.checkImmutable(before, map[string]interface{}{"customer_name": "Other", "amount": int64(5)})
+	if got := fieldCodes(t, err); !reflect.DeepEqual(got, []string{"customer_name:immutable"}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestToRecordID(t *testing.T) {
+	for _, v := range []interface{}{1.0, int64(42), json.Number("7"), " 3 ", "2147483647"} {
+		if _, ok := toRecordID(v); !ok {
+			t.Errorf("%#v rejected", v)
+		}
+	}
+	for _, v := range []interface{}{0.0, -1.0, 1.5, "1e12", "abc", "NaN", true, nil} {
+		if id, ok := toRecordID(v); ok {
+			t.Errorf("%#v accepted as %d", v, id)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package resource
+
+// Optimistic concurrency. Resource tables carry a server-managed version column that every
+// update increments. Writes return it as a strong ETag ("3") and check it against If-Match.
+// Reads return a weak ETag (W/"3-1a2b3c4d") that also identifies the representation, since the
+// same version reads differently per role, field list and expansion; If-None-Match compares the
+// whole tag, If-Match only its version.
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"server/internal/registry"
+	"strconv"
+	"strings"
+)
+
+var ErrPreconditionRequired = errors.New("If-Match header is required for this resource")
+
+// PreconditionFailedError is returned when If-Match does not match the stored version.
+// Current is the record as the caller may see it (nil if the caller cannot read it).
+type PreconditionFailedError struct {
+	Version int
+	Current map[string]interface{}
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return "record has been modified (current version " + strconv.Itoa(e.Version) + ")"
+}
+
+// Precondition is a parsed If-Match or If-None-Match header; nil means no header was sent
+type Precondition struct {
+	Any      bool     // "*"
+	Versions []int    // versions of the entity tags that have one; others can never match
+	Tags     []string // the entity tags without W/ and quotes
+}
+
+// ParsePrecondition parses a list of entity tags ("3", W/"3", W/"3-1a2b3c4d", *). Write
+// preconditions compare by version, so any tag the server sent can be used in If-Match.
+func ParsePrecondition(header string) *Precondition {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil
+	}
+	p := &Precondition{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			p.Any = true
+			continue
+		}
+		tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+		p.Tags = append(p.Tags, tag)
+		version, _, _ := strings.Cut(tag, "-")
+		if v, err := strconv.Atoi(version); err == nil {
+			p.Versions = append(p.Versions, v)
+		}
+	}
+	return p
+}
+
+// Matches reports whether the precondition holds for an existing record at version
+func (p *Precondition) Matches(version int) bool {
+	if p.Any {
+		return true
+	}
+	for _, v := range p.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesTag reports whether the precondition holds for an existing record read as etag
+// (weak comparison, as for If-None-Match)
+func (p *Precondition) MatchesTag(etag string) bool {
+	if p.Any {
+		return true
+	}
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	for _, tag := range p.Tags {
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ETag formats a version as a strong entity tag
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ReadETag formats the weak entity tag of a record read at version; variant lists what else
+// shapes the representation (role, fields, expansions)
+func ReadETag(version int, variant ...string) string {
+	h := fnv.New32a()
+	for _, v := range variant {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf(`W/"%d-%08x"`, version, h.Sum32())
+}
+
+// VersionOf returns the version of a scanned or projected row
+func VersionOf(row map[string]interface{}) (int, bool) {
+	switch v := row[registry.VersionColumn].(type) {
+	case int64:
+		return int(v), true
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// versionSet is the SET clause that bumps the row version ("" for unversioned tables)
+func (s *tableSchema) versionSet() string {
+	if !s.versioned {
+		return ""
+	}
+	col := quoteIdent(registry.VersionColumn)
+	return col + " = " + col + " + 1"
+}
+
+// requirePrecondition enforces require_if_match for writes to versioned resources
+func (s *tableSchema) requirePrecondition(p *Precondition) error {
+	if p == nil && s.versioned && s.resource.RequireIfMatch {
+		return ErrPreconditionRequired
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package resource
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePrecondition(t *testing.T) {
+	tests := []struct {
+		header   string
+		any      bool
+		versions []int
+	}{
+		{`"3"`, false, []int{3}},
+		{` W/"3" , "4"`, false, []int{3, 4}},
+		{`W/"3-1a2b3c4d"`, false, []int{3}},
+		{`*`, true, nil},
+		{`"abc", "5"`, false, []int{5}},
+		{`"x-3"`, false, nil},
+	}
+	for _, tt := range tests {
+		p := ParsePrecondition(tt.header)
+		if p == nil || p.Any != tt.any || !reflect.DeepEqual(p.Versions, tt.versions) {
+			t.Errorf("%s: got %+v", tt.header, p)
+		}
+	}
+	if p := ParsePrecondition("  "); p != nil {
+		t.Errorf("blank header: got %+v", p)
+	}
+}
+
+func TestPreconditionMatches(t *testing.T) {
+	if p := ParsePrecondition(`"2", "3"`); !p.Matches(3) || p.Matches(4) {
+		t.Error("version list")
+	}
+	if !ParsePrecondition("*").Matches(7) {
+		t.Error("* matches any version")
+	}
+	// A read's ETag can be sent back in If-Match
+	if !ParsePrecondition(ReadETag(5, "2", "", "")).Matches(5) {
+		t.Error("read ETag in If-Match")
+	}
+}
+
+func TestReadETag(t *testing.T) {
+	tag := ReadETag(5, "2", "name,amount", "")
+	if !strings.HasPrefix(tag, `W/"5-`) {
+		t.Fatalf("got %s", tag)
+	}
+	if tag != ReadETag(5, "2", "name,amount", "") {
+		t.Fatal("tag is not stable")
+	}
+	for _, other := range []string{
+		ReadETag(6, "2", "name,amount", ""),
+		ReadETag(5, "3", "name,amount", ""),
+		ReadETag(5, "2", "name", ""),
+		ReadETag(5, "2", "name,amount", "customer"),
+		ReadETag(5, "2", "name", "amount"),
+	} {
+		if other == tag {
+			t.Errorf("%s does not tell the representations apart", other)
+		}
+	}
+
+	// If-None-Match compares whole tags, weakly
+	if p := ParsePrecondition(tag); !p.MatchesTag(tag) {
+		t.Error("same tag")
+	}
+	if p := ParsePrecondition(strings.TrimPrefix(tag, "W/")); !p.MatchesTag(tag) {
+		t.Error("weak comparison ignores W/")
+	}
+	if p := ParsePrecondition(`"5"`); p.MatchesTag(tag) {
+		t.Error("version alone matched a representation tag")
+	}
+	if p := ParsePrecondition(ReadETag(5, "2", "name", "")); p.MatchesTag(tag) {
+		t.Error("tag of another field list matched")
+	}
+	if !ParsePrecondition("*").MatchesTag(tag) {
+		t.Error("* matches any tag")
+	}
+}
+
+func TestVersionOf(t *testing.T) {
+	for _, v := range []interface{}{int64(4), 4, 4.0} {
+		if got, ok := VersionOf(map[string]interface{}{"version": v}); !ok || got != 4 {
+			t.Errorf("%#v: got %d, %v", v, got, ok)
+		}
+	}
+	if _, ok := VersionOf(map[string]interface{}{"id": 1}); ok {
+		t.Error("unversioned row")
+	}
+}
@@ -1,6 +1,7 @@
 package role
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -124,7 +125,11 @@ func (h *Handler) UpdateFieldPermission(c *gin.Context) {
 		return
 	}
 
-	err := h.Service.UpdateFieldPermission(req.RoleID, req.Resource, req.Field, req.CanView, req.CanEdit)
+	err := h.Service.UpdateFieldPermission(req)
+	if errors.Is(err, ErrInvalidMaskMode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -132,3 +137,81 @@ func (h *Handler) UpdateFieldPermission(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"status": "updated"})
 }
+
+// State transition grants
+
+func (h *Handler) GetTransitionPermissions(c *gin.Context) {
+	roleID, err := strconv.Atoi(c.Param("role_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	perms, err := h.Service.GetTransitionPermissions(roleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, perms)
+}
+
+func (h *Handler) UpdateTransitionPermission(c *gin.Context) {
+	var req UpdateTransitionPermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RoleID == 0 || req.TransitionID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role_id and transition_id are required"})
+		return
+	}
+
+	if err := h.Service.UpdateTransitionPermission(req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// Row-level security handlers
+
+func (h *Handler) GetRowFilters(c *gin.Context) {
+	roleID, err := strconv.Atoi(c.Param("role_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	filters, err := h.Service.GetRowFilters(roleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, filters)
+}
+
+func (h *Handler) SetRowFilter(c *gin.Context) {
+	var req RowFilterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.Service.SetRowFilter(req)
+	if errors.Is(err, ErrInvalidRowFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+func (h *Handler) DeleteRowFilter(c *gin.Context) {
+	err := h.Service.DeleteRowFilter(c.Query("role_id"), c.Query("resource"), c.Query("action"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -27,17 +27,59 @@ type PermissionRequest struct {
 }
 
 type FieldPermission struct {
-	RoleID   int    `json:"role_id"`
-	Resource string `json:"resource"`
-	Field    string `json:"field"`
-	CanView  bool   `json:"can_view"`
-	CanEdit  bool   `json:"can_edit"`
+	RoleID         int    `json:"role_id"`
+	Resource       string `json:"resource"`
+	Field          string `json:"field"`
+	IsSensitive    bool   `json:"is_sensitive"`
+	CanView        bool   `json:"can_view"`
+	CanEdit        bool   `json:"can_edit"` // can_set_on_create or can_update
+	CanSetOnCreate bool   `json:"can_set_on_create"`
+	CanUpdate      bool   `json:"can_update"`
+	MaskMode       string `json:"mask_mode"`
+	CanAggregate   bool   `json:"can_aggregate"`
 }
 
 type UpdateFieldPermissionRequest struct {
-	RoleID   int    `json:"role_id"`
-	Resource string `json:"resource"`
-	Field    string `json:"field"`
-	CanView  bool   `json:"can_view"`
-	CanEdit  bool   `json:"can_edit"`
+	RoleID         int    `json:"role_id"`
+	Resource       string `json:"resource"`
+	Field          string `json:"field"`
+	CanView        bool   `json:"can_view"`
+	CanEdit        bool   `json:"can_edit"`          // shorthand for both write permissions below
+	CanSetOnCreate *bool  `json:"can_set_on_create"` // nil takes can_edit
+	CanUpdate      *bool  `json:"can_update"`        // nil takes can_edit
+	MaskMode       string `json:"mask_mode"`         // empty keeps the current mode
+	CanAggregate   *bool  `json:"can_aggregate"`     // sum/avg/min/max without view access; nil keeps the current value
+}
+
+// TransitionPermission is a state transition of a field and whether a role may perform it
+type TransitionPermission struct {
+	RoleID       int    `json:"role_id"`
+	TransitionID int    `json:"transition_id"`
+	Resource     string `json:"resource"`
+	Field        string `json:"field"`
+	Name         string `json:"name"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Granted      bool   `json:"granted"`
+}
+
+type UpdateTransitionPermissionRequest struct {
+	RoleID       int  `json:"role_id"`
+	TransitionID int  `json:"transition_id"`
+	Granted      bool `json:"granted"`
+}
+
+// RowFilter restricts which rows of a resource a role can read, update or delete
+type RowFilter struct {
+	RoleID     int    `json:"role_id"`
+	Resource   string `json:"resource"`
+	Action     string `json:"action"`
+	Expression string `json:"expression"`
+}
+
+type RowFilterRequest struct {
+	RoleID     int    `json:"role_id"`
+	Resource   string `json:"resource"`
+	Action     string `json:"action"`
+	Expression string `json:"expression"`
 }
@@ -1,8 +1,11 @@
 package role
 
 import (
+	"database/sql"
 	"fmt"
 	"server/internal/config"
+	"server/internal/permission"
+	"strconv"
 )
 
 type Repository struct{}
@@ -52,43 +55,21 @@ func (r *Repository) Delete(id int) error {
 }
 
 func (r *Repository) GetPermissions(roleID string) ([]Permission, error) {
-	// Query the new RBAC schema (role_resource_permissions table)
-	query := `
-		SELECT rrp.role_id, res.name, rrp.can_view, rrp.can_create, rrp.can_update, rrp.can_delete
-		FROM role_resource_permissions rrp
-		JOIN resources res ON rrp.resource_id = res.id
-		WHERE rrp.role_id = $1
-	`
+	id, err := strconv.Atoi(roleID)
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := config.DB.Query(query, roleID)
+	// Query the new RBAC schema (role_resource_permissions table)
+	grants, err := permission.GrantedActions(id)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
+	// Convert to action-based format that frontend expects
 	perms := []Permission{}
-	for rows.Next() {
-		var rID int
-		var resourceName string
-		var canView, canCreate, canUpdate, canDelete bool
-
-		if err := rows.Scan(&rID, &resourceName, &canView, &canCreate, &canUpdate, &canDelete); err != nil {
-			continue
-		}
-
-		// Convert to action-based format that frontend expects
-		if canView {
-			perms = append(perms, Permission{RoleID: rID, Resource: resourceName, Action: "read"})
-		}
-		if canCreate {
-			perms = append(perms, Permission{RoleID: rID, Resource: resourceName, Action: "create"})
-		}
-		if canUpdate {
-			perms = append(perms, Permission{RoleID: rID, Resource: resourceName, Action: "update"})
-		}
-		if canDelete {
-			perms = append(perms, Permission{RoleID: rID, Resource: resourceName, Action: "delete"})
-		}
+	for _, g := range grants {
+		perms = append(perms, Permission{RoleID: id, Resource: g.Resource, Action: g.Action})
 	}
 	return perms, nil
 }
@@ -102,17 +83,7 @@ func (r *Repository) AddOrUpdatePermission(roleID int, resource, action string)
 	}
 
 	// 2. Map action to column
-	column := ""
-	switch action {
-	case "read":
-		column = "can_view"
-	case "create":
-		column = "can_create"
-	case "update":
-		column = "can_update"
-	case "delete":
-		column = "can_delete"
-	}
+	column := permission.Column(action)
 
 	if column == "" {
 		return "", 0, fmt.Errorf("invalid action")
@@ -136,17 +107,7 @@ func (r *Repository) DeletePermission(roleID, resource, action string) error {
 	}
 
 	// 2. Map action to column
-	column := ""
-	switch action {
-	case "read":
-		column = "can_view"
-	case "create":
-		column = "can_create"
-	case "update":
-		column = "can_update"
-	case "delete":
-		column = "can_delete"
-	}
+	column := permission.Column(action)
 
 	if column == "" {
 		return nil
@@ -163,8 +124,13 @@ func (r *Repository) GetFieldPermissions(roleID int) ([]FieldPermission, error)
 		SELECT 
 			r.name, 
 			rf.field_name, 
+			COALESCE(rf.is_sensitive, false),
 			COALESCE(rfp.can_view, false), 
-			COALESCE(rfp.can_edit, false)
+			COALESCE(rfp.can_edit, false),
+			COALESCE(rfp.can_set_on_create, false),
+			COALESCE(rfp.can_update, false),
+			COALESCE(rfp.mask_mode, 'omit'),
+			COALESCE(rfp.can_aggregate, false)
 		FROM resource_fields rf
 		JOIN resources r ON rf.resource_id = r.id
 		LEFT JOIN role_field_permissions rfp ON rf.id = rfp.resource_field_id AND rfp.role_id = $1
@@ -180,7 +146,7 @@ func (r *Repository) GetFieldPermissions(roleID int) ([]FieldPermission, error)
 	for rows.Next() {
 		var p FieldPermission
 		p.RoleID = roleID
-		if err := rows.Scan(&p.Resource, &p.Field, &p.CanView, &p.CanEdit); err != nil {
+		if err := rows.Scan(&p.Resource, &p.Field, &p.IsSensitive, &p.CanView, &p.CanEdit, &p.CanSetOnCreate, &p.CanUpdate, &p.MaskMode, &p.CanAggregate); err != nil {
 			continue
 		}
 		perms = append(perms, p)
@@ -188,19 +154,141 @@ func (r *Repository) GetFieldPermissions(roleID int) ([]FieldPermission, error)
 	return perms, nil
 }
 
-func (r *Repository) UpdateFieldPermission(roleID int, resource, field string, canView, canEdit bool) error {
-	// Nested subquery to find usage of resource_field_id
+func (r *Repository) UpdateFieldPermission(req UpdateFieldPermissionRequest) error {
+	// Nested subquery to find usage of resource_field_id; an empty mask mode (or a nil
+	// can_aggregate) keeps the stored one
 	query := `
-		INSERT INTO role_field_permissions (role_id, resource_field_id, can_view, can_edit)
+		INSERT INTO role_field_permissions (role_id, resource_field_id, can_view, can_edit, mask_mode, can_aggregate, can_set_on_create, can_update)
 		VALUES (
 			$1, 
 			(SELECT rf.id FROM resource_fields rf JOIN resources r ON rf.resource_id = r.id WHERE r.name = $2 AND rf.field_name = $3), 
 			$4, 
-			$5
+			$5,
+			COALESCE(NULLIF($6, ''), 'omit'),
+			COALESCE($7::boolean, false),
+			$8,
+			$9
 		)
 		ON CONFLICT (role_id, resource_field_id) 
-		DO UPDATE SET can_view = $4, can_edit = $5
+		DO UPDATE SET can_view = $4, can_edit = $5,
+			mask_mode = COALESCE(NULLIF($6, ''), role_field_permissions.mask_mode),
+			can_aggregate = COALESCE($7::boolean, role_field_permissions.can_aggregate),
+			can_set_on_create = $8, can_update = $9
+	`
+	_, err := config.DB.Exec(query, req.RoleID, req.Resource, req.Field, req.CanView, req.CanEdit, req.MaskMode, req.CanAggregate,
+		req.CanSetOnCreate, req.CanUpdate)
+	return err
+}
+
+// GetTransitionPermissions lists every state transition and whether the role was granted it
+func (r *Repository) GetTransitionPermissions(roleID int) ([]TransitionPermission, error) {
+	query := `
+		SELECT ft.id, r.name, rf.field_name, ft.name, ft.from_state, ft.to_state, rtp.role_id IS NOT NULL
+		FROM field_transitions ft
+		JOIN resource_fields rf ON ft.resource_field_id = rf.id
+		JOIN resources r ON rf.resource_id = r.id
+		LEFT JOIN role_transition_permissions rtp ON ft.id = rtp.transition_id AND rtp.role_id = $1
+		ORDER BY r.name, rf.id, ft.id
 	`
-	_, err := config.DB.Exec(query, roleID, resource, field, canView, canEdit)
+	rows, err := config.DB.Query(query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	perms := []TransitionPermission{}
+	for rows.Next() {
+		p := TransitionPermission{RoleID: roleID}
+		if err := rows.Scan(&p.TransitionID, &p.Resource, &p.Field, &p.Name, &p.From, &p.To, &p.Granted); err != nil {
+			return nil, err
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
+
+// SetTransitionPermission grants a transition to a role or revokes it
+func (r *Repository) SetTransitionPermission(req UpdateTransitionPermissionRequest) error {
+	if !req.Granted {
+		_, err := config.DB.Exec(`DELETE FROM role_transition_permissions WHERE role_id = $1 AND transition_id = $2`, req.RoleID, req.TransitionID)
+		return err
+	}
+	_, err := config.DB.Exec(`
+		INSERT INTO role_transition_permissions (role_id, transition_id) VALUES ($1, $2)
+		ON CONFLICT (role_id, transition_id) DO NOTHING
+	`, req.RoleID, req.TransitionID)
+	return err
+}
+
+// GetRowFilters lists the row filters of a role
+func (r *Repository) GetRowFilters(roleID int) ([]RowFilter, error) {
+	query := `
+		SELECT res.name, f.action, f.expression
+		FROM role_row_filters f
+		JOIN resources res ON f.resource_id = res.id
+		WHERE f.role_id = $1
+		ORDER BY res.name, f.action
+	`
+	rows, err := config.DB.Query(query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filters := []RowFilter{}
+	for rows.Next() {
+		f := RowFilter{RoleID: roleID}
+		if err := rows.Scan(&f.Resource, &f.Action, &f.Expression); err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, rows.Err()
+}
+
+// ResourceFieldNames returns the registered fields of a resource; found is false for an unknown resource
+func (r *Repository) ResourceFieldNames(resource string) (fields map[string]bool, found bool, err error) {
+	var resourceID int
+	err = config.DB.QueryRow("SELECT id FROM resources WHERE name = $1", resource).Scan(&resourceID)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	rows, err := config.DB.Query("SELECT field_name FROM resource_fields WHERE resource_id = $1", resourceID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	fields = map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, false, err
+		}
+		fields[name] = true
+	}
+	return fields, true, rows.Err()
+}
+
+func (r *Repository) SetRowFilter(roleID int, resource, action, expression string) error {
+	query := `
+		INSERT INTO role_row_filters (role_id, resource_id, action, expression)
+		VALUES ($1, (SELECT id FROM resources WHERE name = $2), $3, $4)
+		ON CONFLICT (role_id, resource_id, action)
+		DO UPDATE SET expression = $4
+	`
+	_, err := config.DB.Exec(query, roleID, resource, action, expression)
+	return err
+}
+
+func (r *Repository) DeleteRowFilter(roleID, resource, action string) error {
+	_, err := config.DB.Exec(`
+		DELETE FROM role_row_filters
+		WHERE role_id = $1 AND action = $3 AND resource_id = (SELECT id FROM resources WHERE name = $2)
+	`, roleID, resource, action)
 	return err
 }
@@ -1,5 +1,21 @@
 package role
 
+import (
+	"errors"
+	"fmt"
+	"server/internal/expr"
+	"server/internal/permission"
+)
+
+var (
+	// ErrInvalidRowFilter is returned for row filters that do not parse or reference unknown fields
+	ErrInvalidRowFilter = errors.New("invalid row filter")
+	ErrInvalidMaskMode  = errors.New("mask_mode must be omit, redact, partial or bucket")
+)
+
+// Row filters apply to these actions; create has no existing row to filter
+var rowFilterActions = map[string]bool{"read": true, "update": true, "delete": true}
+
 type Service struct {
 	Repo *Repository
 }
@@ -32,6 +48,60 @@ func (s *Service) GetFieldPermissions(roleID int) ([]FieldPermission, error) {
 	return s.Repo.GetFieldPermissions(roleID)
 }
 
-func (s *Service) UpdateFieldPermission(roleID int, resource, field string, canView, canEdit bool) error {
-	return s.Repo.UpdateFieldPermission(roleID, resource, field, canView, canEdit)
+func (s *Service) UpdateFieldPermission(req UpdateFieldPermissionRequest) error {
+	if req.MaskMode != "" && !permission.ValidMaskMode(req.MaskMode) {
+		return ErrInvalidMaskMode
+	}
+	// can_edit is kept as "may write at all" for clients that only know the combined flag
+	if req.CanSetOnCreate == nil {
+		req.CanSetOnCreate = &req.CanEdit
+	}
+	if req.CanUpdate == nil {
+		req.CanUpdate = &req.CanEdit
+	}
+	req.CanEdit = *req.CanSetOnCreate || *req.CanUpdate
+	return s.Repo.UpdateFieldPermission(req)
+}
+
+func (s *Service) GetTransitionPermissions(roleID int) ([]TransitionPermission, error) {
+	return s.Repo.GetTransitionPermissions(roleID)
+}
+
+func (s *Service) UpdateTransitionPermission(req UpdateTransitionPermissionRequest) error {
+	return s.Repo.SetTransitionPermission(req)
+}
+
+func (s *Service) GetRowFilters(roleID int) ([]RowFilter, error) {
+	return s.Repo.GetRowFilters(roleID)
+}
+
+// SetRowFilter validates an expression against the resource's fields before storing it
+func (s *Service) SetRowFilter(req RowFilterRequest) error {
+	if !rowFilterActions[req.Action] {
+		return fmt.Errorf("%w: action must be read, update or delete", ErrInvalidRowFilter)
+	}
+
+	pred, err := expr.ParsePredicate(req.Expression)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRowFilter, err)
+	}
+
+	fields, found, err := s.Repo.ResourceFieldNames(req.Resource)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: unknown resource %q", ErrInvalidRowFilter, req.Resource)
+	}
+	for _, f := range pred.Fields() {
+		if !fields[f] && f != "id" && f != "created_at" {
+			return fmt.Errorf("%w: unknown field %q", ErrInvalidRowFilter, f)
+		}
+	}
+
+	return s.Repo.SetRowFilter(req.RoleID, req.Resource, req.Action, req.Expression)
+}
+
+func (s *Service) DeleteRowFilter(roleID, resource, action string) error {
+	return s.Repo.DeleteRowFilter(roleID, resource, action)
 }
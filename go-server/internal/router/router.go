@@ -1,8 +1,11 @@
 package router
 
 import (
+	"server/internal/attachment"
 	"server/internal/auth"
+	"server/internal/job"
 	"server/internal/middleware"
+	"server/internal/registry"
 	"server/internal/resource"
 	"server/internal/role"
 	"server/internal/user"
@@ -17,6 +20,10 @@ func SetupRoutes(
 	userHandler *user.Handler,
 	roleHandler *role.Handler,
 	resourceHandler *resource.Handler,
+	registryHandler *registry.Handler,
+	jobHandler *job.Handler,
+	attachmentHandler *attachment.Handler,
+	registryService *registry.Service,
 ) {
 	api := r.Group("/api")
 
@@ -33,8 +40,6 @@ func SetupRoutes(
 		{
 			authenticatedAuth.GET("/permissions", authHandler.GetMyPermissions)
 			authenticatedAuth.GET("/field-permissions", authHandler.GetMyFieldPermissions)
-			authenticatedAuth.GET("/employees", authHandler.GetEmployees)
-			authenticatedAuth.GET("/users", authHandler.GetUsers)
 		}
 	}
 
@@ -70,32 +75,82 @@ func SetupRoutes(
 		adminGroup.GET("/field-permissions/:role_id", roleHandler.GetFieldPermissions)
 		adminGroup.POST("/field-permissions", roleHandler.UpdateFieldPermission)
 
+		// State transitions each role may perform
+		adminGroup.GET("/transition-permissions/:role_id", roleHandler.GetTransitionPermissions)
+		adminGroup.POST("/transition-permissions", roleHandler.UpdateTransitionPermission)
+
+		// Row-level security filters
+		adminGroup.GET("/row-filters/:role_id", roleHandler.GetRowFilters)
+		adminGroup.PUT("/row-filters", roleHandler.SetRowFilter)
+		adminGroup.DELETE("/row-filters", roleHandler.DeleteRowFilter)
+
+		// Resource registry (config-driven resources and their tables)
+		adminGroup.GET("/resources", registryHandler.GetAll)
+		adminGroup.POST("/resources", registryHandler.Create)
+		adminGroup.POST("/resources/:name/fields", registryHandler.AddField)
+		adminGroup.PUT("/resources/:name/fields/:field/validation", registryHandler.SetValidation)
+		adminGroup.PUT("/resources/:name/fields/:field/min-group-size", registryHandler.SetMinGroupSize)
+		adminGroup.PUT("/resources/:name/fields/:field/immutable", registryHandler.SetImmutable)
+		adminGroup.PUT("/resources/:name/fields/:field/transitions", registryHandler.SetTransitions)
+		adminGroup.PUT("/resources/:name/soft-delete", registryHandler.SetSoftDelete)
+		adminGroup.PUT("/resources/:name/require-if-match", registryHandler.SetRequireIfMatch)
+		adminGroup.PUT("/resources/:name/approver", registryHandler.SetApprover)
+
 		// User management
 		adminGroup.GET("/users", userHandler.GetAll)
 		adminGroup.POST("/users", userHandler.Create)
 		adminGroup.PUT("/users/:id/role", userHandler.UpdateRole)
+		adminGroup.PUT("/users/:id/attributes", userHandler.UpdateAttributes)
 		adminGroup.DELETE("/users/:id", userHandler.Delete)
 	}
 
+	// Background jobs (visible to the user who started them)
+	jobGroup := api.Group("/jobs")
+	jobGroup.Use(middleware.AuthMiddleware())
+	{
+		jobGroup.GET("/:id", jobHandler.Get)
+	}
+
+	// Id/label pairs for dropdowns, governed by the same permissions as reads
+	api.GET("/lookup/:resource", middleware.AuthMiddleware(), resourceHandler.Lookup)
+
+	// Global search across every resource the caller can read
+	api.GET("/search", middleware.AuthMiddleware(), resourceHandler.Search)
+
+	// Export downloads, authorized by the signed link itself
+	api.GET("/exports/:token", resourceHandler.DownloadExport)
+	api.GET("/attachments/:token", attachmentHandler.Download)
+
 	// Data routes (Authenticated with resource validation)
 	dataGroup := api.Group("/data")
 	dataGroup.Use(middleware.AuthMiddleware())
 	{
-		// Resource validation middleware
-		dataGroup.Use(func(c *gin.Context) {
-			resource := c.Param("resource")
-			allowed := map[string]bool{"employees": true, "projects": true, "orders": true}
-			if !allowed[resource] {
-				c.JSON(404, gin.H{"message": "Resource not found"})
-				c.Abort()
-				return
-			}
-			c.Next()
-		})
+		// Resource validation middleware (resolved from the registry)
+		dataGroup.Use(middleware.ResourceMiddleware(registryService))
 
 		dataGroup.GET("/:resource", resourceHandler.GetAll)
+		dataGroup.GET("/:resource/trash", resourceHandler.GetTrash)
+		dataGroup.GET("/:resource/export", resourceHandler.Export)
+		dataGroup.GET("/:resource/aggregate", resourceHandler.Aggregate)
+		dataGroup.GET("/:resource/pending-changes", resourceHandler.PendingChanges)
+		dataGroup.POST("/:resource/pending-changes/:change_id/approve", resourceHandler.ApproveChange)
+		dataGroup.POST("/:resource/pending-changes/:change_id/reject", resourceHandler.RejectChange)
+		dataGroup.GET("/:resource/:id", resourceHandler.GetOne)
 		dataGroup.POST("/:resource", resourceHandler.Create)
+		dataGroup.POST("/:resource/_batch", resourceHandler.Batch)
+		dataGroup.POST("/:resource/_import", resourceHandler.Import)
 		dataGroup.PUT("/:resource/:id", resourceHandler.Update)
 		dataGroup.DELETE("/:resource/:id", resourceHandler.Delete)
+		dataGroup.POST("/:resource/:id/restore", resourceHandler.Restore)
+		dataGroup.GET("/:resource/:id/history", resourceHandler.GetHistory)
+		dataGroup.GET("/:resource/:id/transitions", resourceHandler.Transitions)
+		dataGroup.POST("/:resource/:id/revert", resourceHandler.Revert)
+		dataGroup.DELETE("/:resource/:id/purge", resourceHandler.Purge)
+
+		// File attachments
+		dataGroup.GET("/:resource/:id/attachments", attachmentHandler.List)
+		dataGroup.POST("/:resource/:id/attachments", attachmentHandler.Upload)
+		dataGroup.GET("/:resource/:id/attachments/:attachment_id/download", attachmentHandler.Link)
+		dataGroup.DELETE("/:resource/:id/attachments/:attachment_id", attachmentHandler.Delete)
 	}
 }
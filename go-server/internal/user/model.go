@@ -24,3 +24,8 @@ type CreateUserRequest struct {
 type UpdateUserRoleRequest struct {
 	RoleID int `json:"role_id"`
 }
+
+// UpdateAttributesRequest replaces the attributes row filters can reference as :user.<name>
+type UpdateAttributesRequest struct {
+	Attributes map[string]interface{} `json:"attributes"`
+}
@@ -75,6 +75,15 @@ func (r *Repository) GetAll() ([]map[string]interface{}, error) {
 	return users, nil
 }
 
+func (r *Repository) UpdateAttributes(userID int, attributes []byte) (bool, error) {
+	res, err := config.DB.Exec("UPDATE users SET attributes = $1 WHERE id = $2", attributes, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
 func (r *Repository) IsAdmin(userID int) (bool, error) {
 	var isAdmin bool
 	err := config.DB.QueryRow("SELECT is_admin FROM users WHERE id = $1", userID).Scan(&isAdmin)
@@ -1,6 +1,7 @@
 package user
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"server/internal/config"
@@ -69,3 +70,22 @@ func (s *Service) UpdateRole(userID, roleID int) error {
 func (s *Service) Delete(userID string) error {
 	return s.Repo.Delete(userID)
 }
+
+// UpdateAttributes stores scalar attributes (strings, numbers, booleans) for row filters
+func (s *Service) UpdateAttributes(userID int, attributes map[string]interface{}) (bool, error) {
+	for k, v := range attributes {
+		switch v.(type) {
+		case string, float64, bool, nil:
+		default:
+			return false, fmt.Errorf("attribute %q must be a string, number or boolean", k)
+		}
+	}
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		return false, err
+	}
+	return s.Repo.UpdateAttributes(userID, data)
+}
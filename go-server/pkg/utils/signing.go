@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// SignPayload returns an opaque "payload.signature" token, HMAC-signed with SecretKey
+func SignPayload(payload []byte) string {
+	mac := hmac.New(sha256.New, SecretKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyPayload checks a token produced by SignPayload and returns the original payload
+func VerifyPayload(token string) ([]byte, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+
+	mac := hmac.New(sha256.New, SecretKey)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid token signature")
+	}
+	return payload, nil
+}
@@ -21,7 +21,8 @@ export default function Admin() {
     const [deleteConfirmation, setDeleteConfirmation] = useState({ isOpen: false, userId: null, username: '', type: 'user' });
 
     const resources = ['employees', 'projects', 'orders'];
-    const actions = ['read', 'create', 'update', 'delete'];
+    const actions = ['read', 'create', 'update', 'delete', 'restore', 'purge', 'export',
+        'list_attachments', 'download_attachments', 'upload_attachments', 'delete_attachments'];
 
     useEffect(() => {
         loadRoles();
@@ -130,7 +131,11 @@ export default function Admin() {
         // Optimistic UI update
         const updatedPerms = fieldPermissions.map(p => {
             if (p.resource === resource && p.field === field) {
-                return { ...p, [type === 'view' ? 'can_view' : 'can_edit']: value };
+                if (type === 'mask') return { ...p, mask_mode: value };
+                if (type === 'aggregate') return { ...p, can_aggregate: value };
+                if (type === 'create') return { ...p, can_set_on_create: value };
+                if (type === 'update') return { ...p, can_update: value };
+                return { ...p, can_view: value };
             }
             return p;
         });
@@ -144,7 +149,11 @@ export default function Admin() {
                 resource,
                 field,
                 can_view: record.can_view,
-                can_edit: record.can_edit
+                can_edit: record.can_set_on_create || record.can_update,
+                can_set_on_create: record.can_set_on_create,
+                can_update: record.can_update,
+                mask_mode: record.mask_mode,
+                can_aggregate: record.can_aggregate
             });
         } catch (e) {
             toast.error("Failed to update field permission");
@@ -244,7 +253,7 @@ export default function Admin() {
                                         <thead className="bg-slate-50 border-b border-slate-200/60">
                                             <tr>
                                                 <th className="px-6 py-3 text-xs font-semibold text-slate-500 uppercase tracking-wider w-1/4">Resource</th>
-                                                {actions.map(a => <th key={a} className="px-4 py-3 text-xs font-semibold text-slate-500 uppercase tracking-wider text-center flex-1">{a}</th>)}
+                                                {actions.map(a => <th key={a} className="px-4 py-3 text-xs font-semibold text-slate-500 uppercase tracking-wider text-center flex-1">{a.replace('_', ' ')}</th>)}
                                             </tr>
                                         </thead>
                                         <tbody className="divide-y divide-slate-100">
@@ -316,12 +325,44 @@ export default function Admin() {
                                                                         View
                                                                     </button>
                                                                     <button
-                                                                        onClick={() => handleFieldPermissionChange(res, field.field, 'edit', !field.can_edit)}
-                                                                        className={`px-3 py-1 text-[10px] font-bold uppercase tracking-wider rounded transition-all ${field.can_edit ? 'bg-slate-800 text-white ring-1 ring-slate-800 ring-offset-1' : 'bg-slate-100 text-slate-400 hover:bg-slate-200'
+                                                                        onClick={() => handleFieldPermissionChange(res, field.field, 'create', !field.can_set_on_create)}
+                                                                        title="Can be set when creating a record"
+                                                                        className={`px-3 py-1 text-[10px] font-bold uppercase tracking-wider rounded transition-all ${field.can_set_on_create ? 'bg-slate-800 text-white ring-1 ring-slate-800 ring-offset-1' : 'bg-slate-100 text-slate-400 hover:bg-slate-200'
                                                                             }`}
                                                                     >
-                                                                        Edit
+                                                                        Create
                                                                     </button>
+                                                                    <button
+                                                                        onClick={() => handleFieldPermissionChange(res, field.field, 'update', !field.can_update)}
+                                                                        title="Can be changed on existing records"
+                                                                        className={`px-3 py-1 text-[10px] font-bold uppercase tracking-wider rounded transition-all ${field.can_update ? 'bg-slate-800 text-white ring-1 ring-slate-800 ring-offset-1' : 'bg-slate-100 text-slate-400 hover:bg-slate-200'
+                                                                            }`}
+                                                                    >
+                                                                        Update
+                                                                    </button>
+                                                                    {!field.can_view && (
+                                                                        <button
+                                                                            onClick={() => handleFieldPermissionChange(res, field.field, 'aggregate', !field.can_aggregate)}
+                                                                            title="Allow sums and averages without seeing values"
+                                                                            className={`px-3 py-1 text-[10px] font-bold uppercase tracking-wider rounded transition-all ${field.can_aggregate ? 'bg-slate-800 text-white' : 'bg-slate-100 text-slate-400 hover:bg-slate-200'
+                                                                                }`}
+                                                                        >
+                                                                            Agg
+                                                                        </button>
+                                                                    )}
+                                                                    {!field.can_view && (
+                                                                        <select
+                                                                            value={field.mask_mode || 'omit'}
+                                                                            onChange={(e) => handleFieldPermissionChange(res, field.field, 'mask', e.target.value)}
+                                                                            title="How this field is shown to the role"
+                                                                            className={`px-2 py-1 text-[10px] font-bold uppercase tracking-wider rounded border-0 ${field.is_sensitive ? 'bg-amber-50 text-amber-700' : 'bg-slate-100 text-slate-500'}`}
+                                                                        >
+                                                                            <option value="omit">Hide</option>
+                                                                            <option value="redact">***</option>
+                                                                            <option value="partial">Last 4</option>
+                                                                            <option value="bucket">Range</option>
+                                                                        </select>
+                                                                    )}
                                                                 </div>
                                                             </div>
                                                         ))}
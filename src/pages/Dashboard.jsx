@@ -1,9 +1,9 @@
 import { useEffect, useState } from 'react';
 import { useAuth } from '../context/AuthContext';
-import { fetchMyPermissions, fetchMyFieldPermissions, fetchResource, createResource, updateResource, deleteResource, fetchEmployeeNames } from '../services/api';
+import { fetchMyPermissions, fetchMyFieldPermissions, fetchResource, createResource, updateResource, deleteResource, lookupResource, fetchTransitions, exportResource, fetchJob, absoluteApiUrl } from '../services/api';
 import { useNavigate } from 'react-router-dom';
 import { motion, AnimatePresence } from 'framer-motion';
-import { LayoutDashboard, Trash2, AlertTriangle, X, Pencil, Plus, Users, Briefcase, Package, ShieldCheck } from 'lucide-react';
+import { LayoutDashboard, Trash2, AlertTriangle, X, Pencil, Plus, Users, Briefcase, Package, ShieldCheck, Download } from 'lucide-react';
 import { toast } from 'sonner';
 
 export default function Dashboard() {
@@ -19,54 +19,79 @@ export default function Dashboard() {
     const [itemToDelete, setItemToDelete] = useState(null);
     const [currentItem, setCurrentItem] = useState(null);
     const [newItem, setNewItem] = useState({});
+    // Status moves allowed on the record being edited; null when the status is not restricted
+    const [statusMoves, setStatusMoves] = useState(null);
 
-    const [employeesList, setEmployeesList] = useState([]);
+    // Assignee typeahead: employees whose name starts with the typed text
+    const [assigneeQuery, setAssigneeQuery] = useState('');
+    const [assigneeOptions, setAssigneeOptions] = useState([]);
+    const [assigneeOpen, setAssigneeOpen] = useState(false);
     const [fieldPermissions, setFieldPermissions] = useState({});
 
     const canRead = (res) => user?.role_id === 1 || permissions.some(p => p.resource === res && p.action === 'read');
     const canCreate = (res) => user?.role_id === 1 || permissions.some(p => p.resource === res && p.action === 'create');
     const canUpdate = (res) => user?.role_id === 1 || permissions.some(p => p.resource === res && p.action === 'update');
     const canDelete = (res) => user?.role_id === 1 || permissions.some(p => p.resource === res && p.action === 'delete');
+    const canExport = (res) => user?.role_id === 1 || (canRead(res) && permissions.some(p => p.resource === res && p.action === 'export'));
 
-    const loadEmployees = async () => {
+    const openEdit = async (row) => {
+        setCurrentItem(row);
+        setStatusMoves(null);
+        setModalOpen(true);
         try {
-            let emps = await fetchEmployeeNames();
-            if (!Array.isArray(emps)) {
-                throw new Error("Invalid response format");
-            }
-            setEmployeesList(emps || []);
+            const moves = await fetchTransitions(activeResource, row.id);
+            const status = moves.filter(m => m.field === 'status');
+            setStatusMoves(status.length > 0 ? status : null);
         } catch (err) {
-            console.warn("Dedicated employee fetch failed, trying fallback...", err);
-            try {
-                const data = await fetchResource('employees');
-                if (Array.isArray(data)) {
-                    setEmployeesList(data);
-                } else {
-                    setEmployeesList([]);
-                }
-            } catch (fallbackErr) {
-                console.error("All employee fetch methods failed", fallbackErr);
-            }
+            console.warn("Transition lookup failed", err);
         }
     };
 
-    useEffect(() => {
-        loadEmployees();
-    }, []);
+    const ASSIGNEE_LIMIT = 20;
 
     useEffect(() => {
         if (modalOpen) {
-            loadEmployees();
+            setAssigneeQuery(currentItem?.assigned_to?.name || '');
+            setAssigneeOpen(false);
         }
     }, [modalOpen]);
 
+    useEffect(() => {
+        if (!modalOpen) return;
+        const timer = setTimeout(async () => {
+            try {
+                const emps = await lookupResource('employees', { label: 'name', q: assigneeQuery, limit: ASSIGNEE_LIMIT });
+                setAssigneeOptions(emps.map(e => ({ id: e.id, name: e.label })));
+            } catch (err) {
+                // Roles without read access to employee names get no choices
+                console.warn("Employee lookup failed", err);
+                setAssigneeOptions([]);
+            }
+        }, 250);
+        return () => clearTimeout(timer);
+    }, [assigneeQuery, modalOpen]);
+
+    const pickAssignee = (field, emp) => {
+        const value = emp ? emp.id : '';
+        if (currentItem) setCurrentItem({ ...currentItem, [field]: value });
+        else setNewItem({ ...newItem, [field]: value });
+        if (emp) setAssigneeQuery(emp.name);
+        setAssigneeOpen(false);
+    };
+
     useEffect(() => {
         // Load field permissions for everyone (Admin gets simulated full perms from backend)
         fetchMyFieldPermissions().then(fPerms => {
             const fMap = {};
             fPerms.forEach(p => {
                 if (!fMap[p.resource]) fMap[p.resource] = {};
-                fMap[p.resource][p.field] = { view: p.can_view, edit: p.can_edit };
+                // Immutable fields can only be set when the record is created
+                fMap[p.resource][p.field] = {
+                    view: p.can_view,
+                    create: p.can_set_on_create,
+                    update: p.can_update && !p.immutable,
+                    mask: p.mask_mode
+                };
             });
             setFieldPermissions(fMap);
         }).catch(err => console.error("Field permissions fetch failed", err));
@@ -85,14 +110,15 @@ export default function Dashboard() {
     useEffect(() => {
         if (activeResource) {
             setLoading(true);
-            fetchResource(activeResource)
+            fetchResource(activeResource, listParams(activeResource))
                 .then(resData => {
                     const safeData = Array.isArray(resData) ? resData : [];
                     setData(safeData);
                     // Headers are now determined by what the API returned (READ enforcement)
                     // But we can also use our known schema intersected with permissions for better ordering
                     if (safeData.length > 0) {
-                        setHeaders(Object.keys(safeData[0]));
+                        // version is server-managed (used for If-Match), not a column to show or edit
+                        setHeaders(Object.keys(safeData[0]).filter(h => h !== 'version'));
                     } else {
                         // If no data, fall back to schema filtered by view permissions
                         const schema = RESOURCE_SCHEMAS[activeResource] || [];
@@ -109,6 +135,18 @@ export default function Dashboard() {
         }
     }, [activeResource, fieldPermissions]);
 
+    // Non-viewable fields returned by the API carry a masked value (redacted, partial or bucketed)
+    const isMasked = (field) => {
+        const perm = fieldPermissions[activeResource]?.[field];
+        return !!perm && !perm.view && !!perm.mask && perm.mask !== 'omit';
+    };
+
+    // Reference fields shown with the referenced record's name
+    const REFERENCES = { projects: ['assigned_to'] };
+    const listParams = (resource) => (REFERENCES[resource] ? { expand: REFERENCES[resource].join(',') } : {});
+    const refId = (value) => (value && typeof value === 'object' ? value.id : value);
+    const displayValue = (value) => (value && typeof value === 'object' ? value.name ?? `#${value.id}` : value);
+
     const RESOURCE_SCHEMAS = {
         employees: ['name', 'position', 'salary', 'department'],
         projects: ['name', 'assigned_to', 'status', 'budget'],
@@ -120,32 +158,74 @@ export default function Dashboard() {
         const toastId = toast.loading('Saving...');
         try {
             const allowedFields = RESOURCE_SCHEMAS[activeResource] || headers;
-            const filterData = (source) => {
+            const filterData = (source, isCreate) => {
                 const filtered = {};
                 allowedFields.forEach(field => {
-                    if (source[field] !== undefined) filtered[field] = source[field];
+                    // The server rejects writes to non-editable fields (strict write mode);
+                    // non-viewable fields only ever hold masked values, so never send them back
+                    const perm = fieldPermissions[activeResource]?.[field];
+                    if (perm && (!(isCreate ? perm.create : perm.update) || !perm.view)) return;
+                    if (source[field] !== undefined) filtered[field] = refId(source[field]);
                 });
                 return filtered;
             };
 
+            let pending = false;
             if (currentItem) {
-                const payload = filterData(currentItem);
-                await updateResource(activeResource, currentItem.id, payload);
+                const payload = filterData(currentItem, false);
+                const res = await updateResource(activeResource, currentItem.id, payload, currentItem.version);
+                pending = !!res.pending_change_id;
             } else {
-                const payload = filterData(newItem);
+                const payload = filterData(newItem, true);
                 await createResource(activeResource, payload);
             }
-            const updated = await fetchResource(activeResource);
+            const updated = await fetchResource(activeResource, listParams(activeResource));
             setData(updated);
             setModalOpen(false);
             setCurrentItem(null);
             setNewItem({});
-            toast.success("Saved successfully!", { id: toastId });
+            // Changes to sensitive fields wait for an approver on resources that require one
+            toast.success(pending ? "Saved. Sensitive changes are awaiting approval." : "Saved successfully!", { id: toastId });
         } catch (err) {
+            if (err.response?.status === 412) {
+                toast.error("This record was changed by someone else. Reload it and try again.", { id: toastId });
+                return;
+            }
             toast.error(err.response?.data?.message || "Operation failed! Check permissions.", { id: toastId });
         }
     };
 
+    const handleExport = async (format) => {
+        if (!activeResource) return;
+        const toastId = toast.loading('Exporting...');
+        try {
+            const result = await exportResource(activeResource, format);
+            if (result.blob) {
+                const url = URL.createObjectURL(result.blob);
+                const link = document.createElement('a');
+                link.href = url;
+                link.download = `${activeResource}.${format}`;
+                link.click();
+                URL.revokeObjectURL(url);
+                toast.success("Export downloaded!", { id: toastId });
+                return;
+            }
+
+            // Large export: wait for the background job, then follow its signed link
+            let job = await fetchJob(result.job_id);
+            while (job.status === 'running') {
+                toast.loading(`Exporting... ${job.processed}/${job.total}`, { id: toastId });
+                await new Promise(resolve => setTimeout(resolve, 1000));
+                job = await fetchJob(result.job_id);
+            }
+            if (job.status !== 'succeeded') throw new Error(job.error);
+            window.location.href = absoluteApiUrl(job.result.download_url);
+            toast.success("Export ready!", { id: toastId });
+        } catch (err) {
+            toast.error("Export failed! Check permissions.", { id: toastId });
+        }
+    };
+
     const handleDeleteClick = (id) => {
         setItemToDelete(id);
         setDeleteModalOpen(true);
@@ -156,13 +236,16 @@ export default function Dashboard() {
 
         const toastId = toast.loading('Deleting...');
         try {
-            await deleteResource(activeResource, itemToDelete);
+            const row = data.find(item => item.id === itemToDelete);
+            await deleteResource(activeResource, itemToDelete, row?.version);
             setData(data.filter(item => item.id !== itemToDelete));
             toast.success("Deleted successfully!", { id: toastId });
             setDeleteModalOpen(false);
             setItemToDelete(null);
         } catch (err) {
-            toast.error("Delete failed! Check permissions.", { id: toastId });
+            toast.error(err.response?.status === 412
+                ? "This record was changed by someone else. Reload it and try again."
+                : "Delete failed! Check permissions.", { id: toastId });
         }
     };
 
@@ -250,15 +333,27 @@ export default function Dashboard() {
                     <h1 className="text-xl font-bold tracking-tight text-zinc-900 capitalize">
                         {activeResource ? `${activeResource}` : 'Dashboard'}
                     </h1>
-                    {activeResource && canCreate(activeResource) && (
-                        <button
-                            onClick={() => { setCurrentItem(null); setModalOpen(true); }}
-                            className="bg-zinc-900 text-white px-4 py-2 rounded-lg text-sm font-medium hover:bg-zinc-800 transition-all shadow-lg shadow-zinc-900/10 active:scale-95 flex items-center space-x-2"
-                        >
-                            <Plus className="w-4 h-4" />
-                            <span>New Entry</span>
-                        </button>
-                    )}
+                    <div className="flex items-center space-x-2">
+                        {activeResource && canExport(activeResource) && ['csv', 'xlsx'].map(format => (
+                            <button
+                                key={format}
+                                onClick={() => handleExport(format)}
+                                className="border border-zinc-200 text-zinc-700 px-3 py-2 rounded-lg text-sm font-medium hover:bg-zinc-100 transition-all active:scale-95 flex items-center space-x-2"
+                            >
+                                <Download className="w-4 h-4" />
+                                <span className="uppercase">{format}</span>
+                            </button>
+                        ))}
+                        {activeResource && canCreate(activeResource) && (
+                            <button
+                                onClick={() => { setCurrentItem(null); setModalOpen(true); }}
+                                className="bg-zinc-900 text-white px-4 py-2 rounded-lg text-sm font-medium hover:bg-zinc-800 transition-all shadow-lg shadow-zinc-900/10 active:scale-95 flex items-center space-x-2"
+                            >
+                                <Plus className="w-4 h-4" />
+                                <span>New Entry</span>
+                            </button>
+                        )}
+                    </div>
                 </header>
 
                 <div className="flex-1 overflow-auto p-8 relative">
@@ -279,6 +374,11 @@ export default function Dashboard() {
                                                 {headers.map(h => (
                                                     <th key={h} className="px-6 py-4 font-semibold text-zinc-500 uppercase text-[10px] tracking-wider">
                                                         {h.replaceAll('_', ' ')}
+                                                        {isMasked(h) && (
+                                                            <span className="ml-2 px-1.5 py-0.5 rounded bg-amber-50 text-amber-600 normal-case tracking-normal" title={`Masked (${fieldPermissions[activeResource][h].mask})`}>
+                                                                masked
+                                                            </span>
+                                                        )}
                                                     </th>
                                                 ))}
                                                 {(canUpdate(activeResource) || canDelete(activeResource)) && (
@@ -298,15 +398,15 @@ export default function Dashboard() {
                                                     className="group hover:bg-zinc-50/80 transition-colors"
                                                 >
                                                     {headers.map(h => (
-                                                        <td key={h} className="px-6 py-4 text-sm text-zinc-700 font-medium group-hover:text-zinc-900">
-                                                            {row[h]}
+                                                        <td key={h} className={`px-6 py-4 text-sm font-medium ${isMasked(h) ? 'text-zinc-400 italic' : 'text-zinc-700 group-hover:text-zinc-900'}`}>
+                                                            {displayValue(row[h])}
                                                         </td>
                                                     ))}
                                                     {(canUpdate(activeResource) || canDelete(activeResource)) && (
                                                         <td className="px-6 py-4 text-right space-x-2">
                                                             {canUpdate(activeResource) && (
                                                                 <button
-                                                                    onClick={() => { setCurrentItem(row); setModalOpen(true); }}
+                                                                    onClick={() => openEdit(row)}
                                                                     className="inline-flex items-center justify-center w-8 h-8 rounded-full text-zinc-400 hover:text-zinc-900 hover:bg-zinc-100 transition-all"
                                                                     title="Edit"
                                                                 >
@@ -399,8 +499,8 @@ export default function Dashboard() {
                                             const canView = fieldPermissions[activeResource]?.[field]?.view;
                                             if (!canView) return null;
 
-                                            const canEdit = fieldPermissions[activeResource]?.[field]?.edit;
-                                            const isReadOnly = !canEdit;
+                                            const perm = fieldPermissions[activeResource]?.[field];
+                                            const isReadOnly = !(currentItem ? perm?.update : perm?.create);
 
                                             if (field === 'assigned_to') {
                                                 return (
@@ -409,33 +509,44 @@ export default function Dashboard() {
                                                             {field.replace('_', ' ')} <span className="text-red-500">*</span>
                                                         </label>
                                                         <div className="relative">
-                                                            <select
+                                                            <input
+                                                                type="text"
                                                                 required
                                                                 disabled={isReadOnly}
-                                                                value={currentItem ? currentItem[field] || '' : newItem[field] || ''}
+                                                                value={assigneeQuery}
+                                                                placeholder="Type an employee name..."
+                                                                autoComplete="off"
+                                                                onFocus={() => setAssigneeOpen(true)}
+                                                                onBlur={() => setAssigneeOpen(false)}
                                                                 onChange={(e) => {
-                                                                    const val = e.target.value;
-                                                                    if (currentItem) setCurrentItem({ ...currentItem, [field]: val });
-                                                                    else setNewItem({ ...newItem, [field]: val });
+                                                                    setAssigneeQuery(e.target.value);
+                                                                    setAssigneeOpen(true);
+                                                                    if (refId(currentItem ? currentItem[field] : newItem[field])) pickAssignee(field, null);
                                                                 }}
-                                                                className={`w-full px-4 py-2.5 bg-zinc-50 border border-zinc-200 rounded-lg focus:ring-2 focus:ring-zinc-900 focus:border-transparent outline-none transition-all text-sm font-medium text-zinc-900 appearance-none ${isReadOnly ? 'opacity-50 cursor-not-allowed' : 'cursor-pointer'}`}
-                                                            >
-                                                                <option value="" disabled>Select employee...</option>
-                                                                {employeesList.length > 0 ? (
-                                                                    employeesList.map(u => (
-                                                                        <option key={u.id} value={u.name}>
-                                                                            {u.name}
-                                                                        </option>
-                                                                    ))
-                                                                ) : (
-                                                                    <option value="" disabled>No employees found (Create one first)</option>
-                                                                )}
-                                                            </select>
-                                                            <div className="absolute inset-y-0 right-0 flex items-center px-4 pointer-events-none text-zinc-500">
-                                                                <svg className="w-4 h-4" fill="none" stroke="currentColor" viewBox="0 0 24 24">
-                                                                    <path strokeLinecap="round" strokeLinejoin="round" strokeWidth="2" d="M19 9l-7 7-7-7" />
-                                                                </svg>
-                                                            </div>
+                                                                className={`w-full px-4 py-2.5 bg-zinc-50 border border-zinc-200 rounded-lg focus:ring-2 focus:ring-zinc-900 focus:border-transparent outline-none transition-all text-sm font-medium text-zinc-900 ${isReadOnly ? 'opacity-50 cursor-not-allowed' : ''}`}
+                                                            />
+                                                            {assigneeOpen && !isReadOnly && (
+                                                                <div className="absolute z-10 mt-1 w-full max-h-60 overflow-y-auto bg-white border border-zinc-200 rounded-lg shadow-lg">
+                                                                    {assigneeOptions.length > 0 ? (
+                                                                        assigneeOptions.map(u => (
+                                                                            <button
+                                                                                type="button"
+                                                                                key={u.id}
+                                                                                onMouseDown={(e) => e.preventDefault()}
+                                                                                onClick={() => pickAssignee(field, u)}
+                                                                                className="block w-full text-left px-4 py-2 text-sm text-zinc-900 hover:bg-zinc-50"
+                                                                            >
+                                                                                {u.name}
+                                                                            </button>
+                                                                        ))
+                                                                    ) : (
+                                                                        <p className="px-4 py-2 text-sm text-zinc-500">No employees match</p>
+                                                                    )}
+                                                                    {assigneeOptions.length === ASSIGNEE_LIMIT && (
+                                                                        <p className="px-4 py-2 text-xs text-zinc-400 border-t border-zinc-100">Showing the first {ASSIGNEE_LIMIT}; keep typing to narrow down</p>
+                                                                    )}
+                                                                </div>
+                                                            )}
                                                         </div>
                                                     </div>
                                                 );
@@ -471,9 +582,11 @@ export default function Dashboard() {
                                                                 className={`w-full px-4 py-2.5 rounded-lg border focus:ring-2 focus:ring-zinc-900 focus:border-transparent outline-none transition-all text-sm font-semibold appearance-none ${isReadOnly ? 'opacity-50 cursor-not-allowed' : 'cursor-pointer'} ${getStatusColor(currentVal)}`}
                                                             >
                                                                 <option value="" disabled>Select status...</option>
-                                                                <option value="Open">🔵 &nbsp; Open</option>
-                                                                <option value="In Progress">🔄 &nbsp; In Progress</option>
-                                                                <option value="Pending">⏳ &nbsp; Pending</option>
+                                                                {[['Open', '🔵'], ['In Progress', '🔄'], ['Pending', '⏳']]
+                                                                    .filter(([val]) => !currentItem || !statusMoves || val === statusMoves[0].from || statusMoves.some(m => m.to === val))
+                                                                    .map(([val, icon]) => (
+                                                                        <option key={val} value={val}>{icon} &nbsp; {val}</option>
+                                                                    ))}
                                                             </select>
                                                             <div className="absolute inset-y-0 right-0 flex items-center px-4 pointer-events-none opacity-50">
                                                                 <svg className="w-4 h-4" fill="none" stroke="currentColor" viewBox="0 0 24 24">
@@ -493,7 +606,8 @@ export default function Dashboard() {
                                                     <input
                                                         required
                                                         disabled={isReadOnly}
-                                                        type={['salary', 'amount', 'budget'].includes(field) ? 'number' : 'text'}
+                                                        type={field === 'order_date' ? 'date' : ['salary', 'amount', 'budget'].includes(field) ? 'number' : 'text'}
+                                                        step={field === 'amount' ? 'any' : undefined}
                                                         defaultValue={currentItem ? currentItem[field] : ''}
                                                         onChange={(e) => {
                                                             if (currentItem) setCurrentItem({ ...currentItem, [field]: e.target.value });
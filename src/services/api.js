@@ -38,8 +38,9 @@ export const fetchMyFieldPermissions = async () => {
 };
 
 // Data Resource APIs
-export const fetchResource = async (resource) => {
-    const response = await api.get(`/data/${resource}`);
+// params.expand ('assigned_to') embeds referenced records: full when readable, { id } otherwise
+export const fetchResource = async (resource, params = {}) => {
+    const response = await api.get(`/data/${resource}`, { params });
     return response.data;
 };
 
@@ -48,13 +49,64 @@ export const createResource = async (resource, data) => {
     return response.data;
 };
 
-export const updateResource = async (resource, id, data) => {
-    const response = await api.put(`/data/${resource}/${id}`, data);
+// Passing the version the record was read at makes the server reject the write (412)
+// if someone else changed it in the meantime
+const ifMatch = (version) => (version ? { headers: { 'If-Match': `"${version}"` } } : {});
+
+export const updateResource = async (resource, id, data, version) => {
+    const response = await api.put(`/data/${resource}/${id}`, data, ifMatch(version));
+    return response.data;
+};
+
+export const deleteResource = async (resource, id, version) => {
+    const response = await api.delete(`/data/${resource}/${id}`, ifMatch(version));
+    return response.data;
+};
+
+// Small files return the import report directly; large ones return { job_id } to poll with fetchJob
+export const importResource = async (resource, file, { key, dryRun = false, continueOnError = false } = {}) => {
+    const form = new FormData();
+    form.append('file', file);
+    const params = { dry_run: dryRun, continue_on_error: continueOnError };
+    if (key) params.key = key;
+    const response = await api.post(`/data/${resource}/_import`, form, { params });
+    return response.data;
+};
+
+export const fetchJob = async (id) => {
+    const response = await api.get(`/jobs/${id}`);
     return response.data;
 };
 
-export const deleteResource = async (resource, id) => {
-    const response = await api.delete(`/data/${resource}/${id}`);
+// Returns { blob } for exports sent right away, or { job_id } for large exports that finish
+// in the background with a signed download_url in the job result
+export const exportResource = async (resource, format) => {
+    const response = await api.get(`/data/${resource}/export`, { params: { format }, responseType: 'blob' });
+    if (response.status === 202) {
+        return JSON.parse(await response.data.text());
+    }
+    return { blob: response.data };
+};
+
+// metrics like ['count', 'sum:amount'], groupBy like ['status']; returns { groups, min_group_size }
+export const aggregateResource = async (resource, metrics, groupBy = [], params = {}) => {
+    const response = await api.get(`/data/${resource}/aggregate`, {
+        params: { ...params, metrics: metrics.join(','), group_by: groupBy.join(',') || undefined }
+    });
+    return response.data;
+};
+
+// Returns [{ resource, display_name, results: [{ id, rank, matched_fields, record }] }]
+export const searchAll = async (q, limit) => {
+    const response = await api.get('/search', { params: { q, limit } });
+    return response.data;
+};
+
+export const absoluteApiUrl = (path) => new URL(path, API_URL).href;
+
+// operations: [{ op: 'create' | 'update' | 'delete', id, data, version }]
+export const batchResource = async (resource, operations, continueOnError = false) => {
+    const response = await api.post(`/data/${resource}/_batch`, { operations, continue_on_error: continueOnError });
     return response.data;
 };
 
@@ -125,14 +177,15 @@ export const updateRoleFieldPermission = async (payload) => {
     return response.data;
 };
 
-// Available to all authenticated users
-export const fetchAllUsers = async () => {
-    const response = await api.get('/auth/users');
+// Id/label pairs for dropdowns: [{ id, label }]. Needs read permission on the resource and
+// view permission on the label field; q matches the start of the label.
+// State changes the caller may make on a record: [{ field, name, from, to }]
+export const fetchTransitions = async (resource, id) => {
+    const response = await api.get(`/data/${resource}/${id}/transitions`);
     return response.data;
 };
 
-// Fetch employee names for dropdowns (authenticated)
-export const fetchEmployeeNames = async () => {
-    const response = await api.get('/auth/employees');
+export const lookupResource = async (resource, { label, q, limit } = {}) => {
+    const response = await api.get(`/lookup/${resource}`, { params: { label, q, limit } });
     return response.data;
 };